@@ -0,0 +1,91 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// ListUsers returns all users ordered by ID.
+func (a *App) ListUsers(ctx context.Context) ([]*model.User, error) {
+	us, err := a.Users.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("App.ListUsers: %w", err)
+	}
+	return us, nil
+}
+
+// DeleteUser deletes the user and all activities of the user.
+// Returns the number of deleted activities.
+// Activities are deleted first so that running it again completes a failed deletion.
+func (a *App) DeleteUser(ctx context.Context, userID string) (int, error) {
+	if _, err := a.Users.Get(ctx, userID); err != nil {
+		return 0, fmt.Errorf("App.DeleteUser: %w", err)
+	}
+	n, err := a.Activities.DeleteByUser(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("App.DeleteUser: %w", err)
+	}
+	if err := a.Users.Delete(ctx, userID); err != nil {
+		return n, fmt.Errorf("App.DeleteUser: %w", err)
+	}
+	return n, nil
+}
+
+// LinkIdentity links the account of the identity provider to the user, replacing the linked one.
+// Empty subject unlinks the provider.
+// Returns goki.ErrUserAlreadyExist if the account is linked to another user.
+func (a *App) LinkIdentity(ctx context.Context, userID, provider, subject string) (*model.User, error) {
+	u, err := a.Users.Get(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("App.LinkIdentity: %w", err)
+	}
+	u.Link(provider, subject)
+	if err := a.Users.Update(ctx, u); err != nil {
+		return nil, fmt.Errorf("App.LinkIdentity: %w", err)
+	}
+	return u, nil
+}
+
+// ListActivities returns activities of the user in [begin, end) oldest first.
+// The zero value of begin or end means unbounded.
+func (a *App) ListActivities(ctx context.Context, userID string, begin, end time.Time) ([]*model.Activity, error) {
+	acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID, Begin: begin, End: end})
+	if err != nil {
+		return nil, fmt.Errorf("App.ListActivities: %w", err)
+	}
+	return acts, nil
+}
+
+// UserTotal is the total of roaches of an user.
+type UserTotal struct {
+	User  *model.User
+	G     *model.Goki
+	Score int
+}
+
+// Totals returns the total of roaches in [begin, end) of every user ordered by ID,
+// including users without activities. The zero value of begin or end means unbounded.
+// Scores are weighted by a.Weights.
+func (a *App) Totals(ctx context.Context, begin, end time.Time) ([]*UserTotal, error) {
+	users, err := a.Users.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("App.Totals: %w", err)
+	}
+	sums, err := a.Activities.SumByUser(ctx, begin, end)
+	if err != nil {
+		return nil, fmt.Errorf("App.Totals: %w", err)
+	}
+	ret := make([]*UserTotal, len(users))
+	for i, u := range users {
+		g := sums[u.ID]
+		if g == nil {
+			g = model.NewGoki(0, 0, 0)
+		}
+		ret[i] = &UserTotal{User: u, G: g, Score: a.Score(g)}
+	}
+	return ret, nil
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -12,12 +13,19 @@ import (
 type App struct {
 	Users      db.UserDB
 	Activities db.ActivityDB
+	// Weights score roaches. (default model.DefaultWeights)
+	Weights model.Weights
+
+	// logins limits failed login attempts of local accounts.
+	logins *failureLimiter
 }
 
 func NewApp(userDB db.UserDB, activityDB db.ActivityDB) *App {
 	a := &App{
 		Users:      userDB,
 		Activities: activityDB,
+		Weights:    model.DefaultWeights,
+		logins:     newFailureLimiter(LoginMaxFailures, LoginFailureWindow),
 	}
 	return a
 }
@@ -31,72 +39,222 @@ func (a *App) Close() error {
 	return fmt.Errorf("%w: UserDB=%v ActivityDB=%v", goki.ErrAppClose, err2, err1)
 }
 
-func (a *App) AddUser(userID, userName, twitterID string) (*model.User, error) {
+func (a *App) AddUser(ctx context.Context, userID, userName, twitterID string) (*model.User, error) {
 	u := model.NewUser(userID, userName, twitterID)
-	if err := a.Users.Add(u); err != nil {
+	if err := a.Users.Add(ctx, u); err != nil {
 		return nil, fmt.Errorf("App.AddUser: %w", err)
 	}
 	return u, nil
 }
 
-func (a *App) GetUser(userID string) (*model.User, error) {
-	u, err := a.Users.Get(userID)
+// AddUserWithIdentity adds an user linked to an account of the identity provider.
+func (a *App) AddUserWithIdentity(ctx context.Context, userID, userName, provider, subject string) (*model.User, error) {
+	if provider == "" || subject == "" {
+		return nil, fmt.Errorf("App.AddUserWithIdentity: %w: provider and subject are required", goki.ErrInvalidArgument)
+	}
+	u := model.NewUser(userID, userName, "")
+	u.Link(provider, subject)
+	if err := a.Users.Add(ctx, u); err != nil {
+		return nil, fmt.Errorf("App.AddUserWithIdentity: %w", err)
+	}
+	return u, nil
+}
+
+func (a *App) GetUser(ctx context.Context, userID string) (*model.User, error) {
+	u, err := a.Users.Get(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("App.GetUser: %w", err)
 	}
 	return u, nil
 }
 
-func (a *App) GetUserByTwitterID(twitterID string) (*model.User, error) {
-	u, err := a.Users.GetByTwitterID(twitterID)
+func (a *App) GetUserByTwitterID(ctx context.Context, twitterID string) (*model.User, error) {
+	u, err := a.Users.GetByTwitterID(ctx, twitterID)
 	if err != nil {
 		return nil, fmt.Errorf("App.GetUserByTwitterID: %w", err)
 	}
 	return u, nil
 }
 
-func (a *App) Action(user *model.User, numS, numM, numL int) (*model.Activity, error) {
+func (a *App) GetUserByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	u, err := a.Users.GetByIdentity(ctx, provider, subject)
+	if err != nil {
+		return nil, fmt.Errorf("App.GetUserByIdentity: %w", err)
+	}
+	return u, nil
+}
+
+// SetTimeZone sets the IANA time zone of the user. Empty tz resets it to time.Local.
+// Returns goki.ErrInvalidArgument if tz is unknown.
+func (a *App) SetTimeZone(ctx context.Context, user *model.User, tz string) error {
+	if tz == "Local" {
+		return fmt.Errorf("App.SetTimeZone: %w: unknown time zone %q", goki.ErrInvalidArgument, tz)
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("App.SetTimeZone: %w: %v", goki.ErrInvalidArgument, err)
+	}
+	u, err := a.Users.Get(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("App.SetTimeZone: %w", err)
+	}
+	u.TimeZone = tz
+	if err := a.Users.Update(ctx, u); err != nil {
+		return fmt.Errorf("App.SetTimeZone: %w", err)
+	}
+	user.TimeZone = tz
+	return nil
+}
+
+// MaxGokiPerSize is the maximum number of roaches of each size in an activity.
+const MaxGokiPerSize = 21
+
+// ValidateGoki checks the number of roaches in an activity.
+// Returns an error wrapping goki.ErrInvalidArgument.
+func ValidateGoki(numS, numM, numL int) error {
+	if (numS < 0 || numS > MaxGokiPerSize) || (numM < 0 || numM > MaxGokiPerSize) || (numL < 0 || numL > MaxGokiPerSize) {
+		return fmt.Errorf("%w: numS=%v numM=%v numL=%v must be in [0, %v]", goki.ErrInvalidArgument, numS, numM, numL, MaxGokiPerSize)
+	}
+	return nil
+}
+
+func (a *App) Action(ctx context.Context, user *model.User, numS, numM, numL int) (*model.Activity, error) {
 	act := model.NewActivity(user.ID, goki.TimeNow(), numS, numM, numL)
-	if err := a.Activities.Add(act); err != nil {
+	act.ID = goki.NewID()
+	if err := a.Activities.Add(ctx, act); err != nil {
 		return nil, fmt.Errorf("App.Action: %w", err)
 	}
+	// return the stored one since ActivityDB may change the timestamp
+	stored, err := a.Activities.Get(ctx, user.ID, act.ID)
+	if err != nil {
+		return nil, fmt.Errorf("App.Action: %w", err)
+	}
+	return stored, nil
+}
+
+// GetActivity gets an activity of the user.
+// Returns goki.ErrActivityNotFound if the activity does not belong to the user.
+func (a *App) GetActivity(ctx context.Context, user *model.User, activityID string) (*model.Activity, error) {
+	act, err := a.Activities.Get(ctx, user.ID, activityID)
+	if err != nil {
+		return nil, fmt.Errorf("App.GetActivity: %w", err)
+	}
 	return act, nil
 }
 
-func (a *App) CountByYear(userID string, year int, tz ...*time.Location) (*model.Goki, error) {
+// UpdateActivity updates the number of roaches of an activity of the user.
+// Returns goki.ErrActivityNotFound if the activity does not belong to the user.
+func (a *App) UpdateActivity(ctx context.Context, user *model.User, activityID string, numS, numM, numL int) (*model.Activity, error) {
+	act, err := a.Activities.Get(ctx, user.ID, activityID)
+	if err != nil {
+		return nil, fmt.Errorf("App.UpdateActivity: %w", err)
+	}
+	act.G = model.NewGoki(numS, numM, numL)
+	if err := a.Activities.Update(ctx, act); err != nil {
+		return nil, fmt.Errorf("App.UpdateActivity: %w", err)
+	}
+	return act, nil
+}
+
+// DeleteActivity deletes an activity of the user.
+// Returns goki.ErrActivityNotFound if the activity does not belong to the user.
+func (a *App) DeleteActivity(ctx context.Context, user *model.User, activityID string) error {
+	if err := a.Activities.Delete(ctx, user.ID, activityID); err != nil {
+		return fmt.Errorf("App.DeleteActivity: %w", err)
+	}
+	return nil
+}
+
+// RecentActivities returns the latest n activities of the user, newest first.
+func (a *App) RecentActivities(ctx context.Context, userID string, n int) ([]*model.Activity, error) {
+	acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID, Order: db.OrderDesc, Limit: n})
+	if err != nil {
+		return nil, fmt.Errorf("App.RecentActivities: %w", err)
+	}
+	return acts, nil
+}
+
+// OldestActivity returns the oldest activity of the user or nil if the user has no activities.
+func (a *App) OldestActivity(ctx context.Context, userID string) (*model.Activity, error) {
+	acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID, Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("App.OldestActivity: %w", err)
+	}
+	if len(acts) == 0 {
+		return nil, nil
+	}
+	return acts[0], nil
+}
+
+// YearRange returns [begin, end) of the year in the location.
+func YearRange(year int, loc *time.Location) (begin, end time.Time) {
+	begin = time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+	end = time.Date(year+1, time.January, 1, 0, 0, 0, 0, loc)
+	return begin, end
+}
+
+// MonthRange returns [begin, end) of the month in the location.
+func MonthRange(year int, month time.Month, loc *time.Location) (begin, end time.Time) {
+	begin = time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	end = time.Date(year, month+1, 1, 0, 0, 0, 0, loc) // time.Date normalizes December+1
+	return begin, end
+}
+
+func (a *App) CountByYear(ctx context.Context, userID string, year int, tz ...*time.Location) (*model.Goki, error) {
 	loc := time.UTC
 	if len(tz) != 0 {
 		loc = tz[0]
 	}
-	begin := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
-	year++
-	end := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
-	return a.count(userID, begin, end)
+	begin, end := YearRange(year, loc)
+	return a.count(ctx, userID, begin, end)
 }
 
-func (a *App) CountByMonth(userID string, year int, month time.Month, tz ...*time.Location) (*model.Goki, error) {
+func (a *App) CountByMonth(ctx context.Context, userID string, year int, month time.Month, tz ...*time.Location) (*model.Goki, error) {
 	loc := time.UTC
 	if len(tz) != 0 {
 		loc = tz[0]
 	}
-	begin := time.Date(year, month, 1, 0, 0, 0, 0, loc)
-	endMonth := month + 1
-	if month == time.December {
-		endMonth = time.January
+	begin, end := MonthRange(year, month, loc)
+	return a.count(ctx, userID, begin, end)
+}
+
+// Score returns the score of the roaches with a.Weights.
+func (a *App) Score(g *model.Goki) int {
+	return g.Score(a.Weights)
+}
+
+// CountByRange counts roaches of the user in [begin, end).
+func (a *App) CountByRange(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	return a.count(ctx, userID, begin, end)
+}
+
+// History returns activities of the user in [begin, end) newest first, paginated by perPage.
+// page starts from 1. The zero value of begin or end means unbounded.
+// hasNext reports whether the next page exists.
+func (a *App) History(ctx context.Context, userID string, begin, end time.Time, page, perPage int) (acts []*model.Activity, hasNext bool, err error) {
+	if page < 1 {
+		page = 1
 	}
-	end := time.Date(year, endMonth, 1, 0, 0, 0, 0, loc)
-	return a.count(userID, begin, end)
+	acts, err = a.Activities.Query(ctx, db.ActivityQuery{
+		UserID: userID,
+		Begin:  begin,
+		End:    end,
+		Order:  db.OrderDesc,
+		Offset: (page - 1) * perPage,
+		Limit:  perPage + 1, // +1 to check the next page
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("App.History: %w", err)
+	}
+	if len(acts) > perPage {
+		return acts[:perPage], true, nil
+	}
+	return acts, false, nil
 }
 
-func (a *App) count(userID string, begin, end time.Time) (*model.Goki, error) {
-	filter := db.QueryFuncTime(begin, end)
-	acts, err := a.Activities.Query(userID, filter)
+func (a *App) count(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	g, err := a.Activities.Sum(ctx, userID, begin, end)
 	if err != nil {
 		return nil, fmt.Errorf("App.CountBy*: %w", err)
 	}
-	gs := make([]*model.Goki, len(acts))
-	for idx, v := range acts {
-		gs[idx] = v.G
-	}
-	return model.GokiSum(gs...), nil
+	return g, nil
 }
@@ -1,18 +1,30 @@
 package app_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ebiiim/goki"
 	"github.com/ebiiim/goki/app"
 	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/dbtest"
+	"github.com/ebiiim/goki/db/memdb"
+	"github.com/ebiiim/goki/model"
 )
 
 const testdataDir = "./testdata"
 
+var ctx = context.Background()
+
 func copyFile(t *testing.T, src, dst string) {
 	t.Helper()
 	b, err := ioutil.ReadFile(src)
@@ -24,58 +36,40 @@ func copyFile(t *testing.T, src, dst string) {
 	}
 }
 
-func removeFile(t *testing.T, target string) {
-	t.Helper()
-	if err := os.Remove(target); err != nil {
-		t.Error(err)
-	}
-}
-
-func setupAppWithJSONDB(t *testing.T) (_ *app.App, cleanupFn func()) {
+// setupApp returns an App with in-memory databases of alice and bob,
+// and the activities of dbtest.AddQueryData.
+func setupApp(t *testing.T) *app.App {
 	t.Helper()
-
-	udbGoldenFile := filepath.Join(testdataDir, "JSONUserDB.json")
-	udbFile := filepath.Join(testdataDir, fmt.Sprintf("JSONUserDB_%s.json", t.Name()))
-	copyFile(t, udbGoldenFile, udbFile)
-	udb, err := db.NewJSONUserDB(udbFile)
-	if err != nil {
-		t.Error(err)
-	}
-
-	adbGoldenFile := filepath.Join(testdataDir, "JSONActivityDB.json")
-	adbFile := filepath.Join(testdataDir, fmt.Sprintf("JSONActivityDB_%s.json", t.Name()))
-	copyFile(t, adbGoldenFile, adbFile)
-	adb, err := db.NewJSONActivityDB(adbFile)
-	if err != nil {
-		t.Error(err)
-	}
-
-	cleanupFn = func() {
-		removeFile(t, udbFile)
-		removeFile(t, adbFile)
+	udb := memdb.NewUserDB()
+	for _, u := range []*model.User{
+		model.NewUser("123", "alice", "12345678"),
+		model.NewUser("456", "bob", "87654321"),
+	} {
+		if err := udb.Add(ctx, u); err != nil {
+			t.Fatal(err)
+		}
 	}
-
-	return app.NewApp(udb, adb), cleanupFn
+	adb := memdb.NewActivityDB()
+	dbtest.AddQueryData(t, adb)
+	return app.NewApp(udb, adb)
 }
 
 func TestApp_Close(t *testing.T) {
-	a, cleanupFn := setupAppWithJSONDB(t)
-	defer cleanupFn()
+	a := setupApp(t)
 	if err := a.Close(); err != nil {
 		t.Error(err)
 	}
 }
 
 func TestApp_GetUser(t *testing.T) {
-	a, cleanupFn := setupAppWithJSONDB(t)
-	defer cleanupFn()
+	a := setupApp(t)
 	defer func() {
 		if err := a.Close(); err != nil {
 			t.Error(err)
 		}
 	}()
 	// Just try to use the database so complicated tests are not needed.
-	u, err := a.GetUser("123")
+	u, err := a.GetUser(ctx, "123")
 	if err != nil {
 		t.Error(err)
 	}
@@ -85,15 +79,14 @@ func TestApp_GetUser(t *testing.T) {
 }
 
 func TestApp_AddUser(t *testing.T) {
-	a, cleanupFn := setupAppWithJSONDB(t)
-	defer cleanupFn()
+	a := setupApp(t)
 	defer func() {
 		if err := a.Close(); err != nil {
 			t.Error(err)
 		}
 	}()
 	// Just try to use the database so complicated tests are not needed.
-	u, err := a.AddUser("000", "taro", "00000000")
+	u, err := a.AddUser(ctx, "000", "taro", "00000000")
 	if err != nil {
 		t.Error(err)
 	}
@@ -103,16 +96,15 @@ func TestApp_AddUser(t *testing.T) {
 }
 
 func TestApp_Action(t *testing.T) {
-	a, cleanupFn := setupAppWithJSONDB(t)
-	defer cleanupFn()
+	a := setupApp(t)
 	defer func() {
 		if err := a.Close(); err != nil {
 			t.Error(err)
 		}
 	}()
 	// Just try to use the database so complicated tests are not needed.
-	u, _ := a.GetUser("123") // alice
-	act, err := a.Action(u, 1, 10, 100)
+	u, _ := a.GetUser(ctx, "123") // alice
+	act, err := a.Action(ctx, u, 1, 10, 100)
 	if err != nil {
 		t.Error(err)
 	}
@@ -120,3 +112,919 @@ func TestApp_Action(t *testing.T) {
 		t.Error("err")
 	}
 }
+
+func TestApp_CountByMonth(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	cases := []struct {
+		name   string
+		userID string
+		year   int
+		month  time.Month
+		loc    *time.Location
+		exp    model.Goki
+	}{
+		{"alice_UTC202008", "123", 2020, time.August, time.UTC, model.Goki{S: 9, M: 6, L: 0}},
+		{"alice_JST202008", "123", 2020, time.August, jst, model.Goki{S: 6, M: 3, L: 0}},
+		{"alice_JST202009", "123", 2020, time.September, jst, model.Goki{S: 3, M: 3, L: 0}},
+		{"alice_UTC202012", "123", 2020, time.December, time.UTC, model.Goki{}},
+		{"taro_invalid_user", "000", 2020, time.August, time.UTC, model.Goki{}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			g, err := a.CountByMonth(ctx, c.userID, c.year, c.month, c.loc)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if *g != c.exp {
+				t.Errorf("want %+v but got %+v", c.exp, *g)
+			}
+		})
+	}
+}
+
+func TestApp_CountByYear(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	cases := []struct {
+		name   string
+		userID string
+		year   int
+		loc    *time.Location
+		exp    model.Goki
+	}{
+		{"alice_UTC2020", "123", 2020, time.UTC, model.Goki{S: 9, M: 6, L: 0}},
+		{"alice_JST2021", "123", 2021, jst, model.Goki{S: 100, M: 100, L: 100}},
+		{"bob_UTC2020", "456", 2020, time.UTC, model.Goki{S: 0, M: 0, L: 12345678}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			g, err := a.CountByYear(ctx, c.userID, c.year, c.loc)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if *g != c.exp {
+				t.Errorf("want %+v but got %+v", c.exp, *g)
+			}
+		})
+	}
+}
+
+func TestApp_UpdateDeleteActivity(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	alice, _ := a.GetUser(ctx, "123")
+	bob, _ := a.GetUser(ctx, "456")
+	act, err := a.Action(ctx, alice, 1, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bob cannot touch alice's activity
+	if _, err := a.UpdateActivity(ctx, bob, act.ID, 0, 0, 0); !errors.Is(err, goki.ErrActivityNotFound) {
+		t.Errorf("want ErrActivityNotFound but got %v", err)
+	}
+	if err := a.DeleteActivity(ctx, bob, act.ID); !errors.Is(err, goki.ErrActivityNotFound) {
+		t.Errorf("want ErrActivityNotFound but got %v", err)
+	}
+	upd, err := a.UpdateActivity(ctx, alice, act.ID, 2, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *upd.G != (model.Goki{S: 2}) {
+		t.Errorf("got %+v", *upd.G)
+	}
+	recent, err := a.RecentActivities(ctx, alice.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recent) != 1 || recent[0].ID != act.ID || *recent[0].G != (model.Goki{S: 2}) {
+		t.Errorf("got %+v", recent)
+	}
+	if err := a.DeleteActivity(ctx, alice, act.ID); err != nil {
+		t.Error(err)
+	}
+	if _, err := a.GetActivity(ctx, alice, act.ID); !errors.Is(err, goki.ErrActivityNotFound) {
+		t.Errorf("want ErrActivityNotFound but got %v", err)
+	}
+}
+
+func TestApp_History(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	y2020Begin, y2020End := app.YearRange(2020, time.UTC)
+	cases := []struct {
+		name       string
+		begin, end time.Time
+		page       int
+		perPage    int
+		expNum     int
+		expHasNext bool
+		expFirst   time.Time
+	}{
+		{"all_page1", time.Time{}, time.Time{}, 1, 3, 3, true, time.Date(2021, 8, 31, 23, 50, 0, 0, time.UTC)},
+		{"all_page2", time.Time{}, time.Time{}, 2, 3, 1, false, time.Date(2020, 8, 2, 10, 10, 9, 0, time.UTC)},
+		{"all_page3", time.Time{}, time.Time{}, 3, 3, 0, false, time.Time{}},
+		{"all_page0", time.Time{}, time.Time{}, 0, 3, 3, true, time.Date(2021, 8, 31, 23, 50, 0, 0, time.UTC)},
+		{"2020_exact", y2020Begin, y2020End, 1, 3, 3, false, time.Date(2020, 8, 31, 20, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			acts, hasNext, err := a.History(ctx, "123", c.begin, c.end, c.page, c.perPage)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(acts) != c.expNum || hasNext != c.expHasNext {
+				t.Errorf("want %v %v but got %v %v", c.expNum, c.expHasNext, len(acts), hasNext)
+				return
+			}
+			if !c.expFirst.IsZero() && !acts[0].TimeUTC.Equal(c.expFirst) {
+				t.Errorf("want %v but got %v", c.expFirst, acts[0].TimeUTC)
+			}
+		})
+	}
+}
+
+func TestApp_APIToken(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	alice, _ := a.GetUser(ctx, "123")
+	bob, _ := a.GetUser(ctx, "456")
+
+	if _, _, err := a.CreateAPIToken(ctx, alice, " "); !errors.Is(err, goki.ErrInvalidArgument) {
+		t.Errorf("empty name: got %v", err)
+	}
+	token, tk, err := a.CreateAPIToken(ctx, alice, "cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Hash == "" || tk.Hash == token {
+		t.Error("token must be stored hashed")
+	}
+	u, err := a.GetUserByAPIToken(ctx, token)
+	if err != nil || u.ID != alice.ID {
+		t.Errorf("GetUserByAPIToken: got %+v %v", u, err)
+	}
+	for _, invalid := range []string{"", "nodot", ".abc", "123.wrong", "456" + token[3:], token + "x"} {
+		if _, err := a.GetUserByAPIToken(ctx, invalid); !errors.Is(err, goki.ErrInvalidToken) {
+			t.Errorf("GetUserByAPIToken(%q): got %v", invalid, err)
+		}
+	}
+	tokens, err := a.ListAPITokens(ctx, alice)
+	if err != nil || len(tokens) != 1 || tokens[0].Name != "cli" {
+		t.Errorf("ListAPITokens: got %+v %v", tokens, err)
+	}
+	if err := a.RevokeAPIToken(ctx, bob, tk.ID); !errors.Is(err, goki.ErrTokenNotFound) {
+		t.Errorf("RevokeAPIToken other user: got %v", err)
+	}
+	if err := a.RevokeAPIToken(ctx, alice, tk.ID); err != nil {
+		t.Error(err)
+	}
+	if _, err := a.GetUserByAPIToken(ctx, token); !errors.Is(err, goki.ErrInvalidToken) {
+		t.Errorf("revoked: got %v", err)
+	}
+}
+
+func TestApp_Identity(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if _, err := a.AddUserWithIdentity(ctx, "000", "taro", "corp", ""); !errors.Is(err, goki.ErrInvalidArgument) {
+		t.Errorf("empty subject: got %v", err)
+	}
+	if _, err := a.AddUserWithIdentity(ctx, "000", "taro", "corp", "taro@corp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.AddUserWithIdentity(ctx, "001", "taro2", "corp", "taro@corp"); !errors.Is(err, goki.ErrUserAlreadyExist) {
+		t.Errorf("duplicated: got %v", err)
+	}
+	u, err := a.GetUserByIdentity(ctx, "corp", "taro@corp")
+	if err != nil || u.ID != "000" {
+		t.Errorf("GetUserByIdentity: got %+v %v", u, err)
+	}
+	if _, err := a.GetUserByIdentity(ctx, model.ProviderTwitter, "taro@corp"); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("other provider: got %v", err)
+	}
+	// Twitter account linked by setupApp
+	if u, err := a.GetUserByIdentity(ctx, model.ProviderTwitter, "12345678"); err != nil || u.ID != "123" {
+		t.Errorf("legacy: got %+v %v", u, err)
+	}
+}
+
+func TestApp_SignUp(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	cases := []struct {
+		name     string
+		username string
+		password string
+		err      error
+	}{
+		{"ok", " Carol_1 ", "password", nil},
+		{"F_duplicated", "carol_1", "password", goki.ErrUserAlreadyExist},
+		{"F_username_short", "ab", "password", goki.ErrInvalidArgument},
+		{"F_username_char", "carol!", "password", goki.ErrInvalidArgument},
+		{"F_password_short", "dave", "1234567", goki.ErrInvalidArgument},
+		{"F_password_long", "dave", strings.Repeat("a", 73), goki.ErrInvalidArgument},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			u, err := a.SignUp(ctx, c.username, "", c.password)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("expected %v but got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Name != "carol_1" || u.Subject(model.ProviderLocal) != "carol_1" || u.PasswordHash == "" || u.PasswordHash == c.password {
+				t.Errorf("got %+v", u)
+			}
+		})
+	}
+}
+
+func TestApp_LoginWithPassword(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	goki.TimeNow = func() time.Time { return now }
+	defer func() { goki.TimeNow = time.Now }()
+
+	carol, err := a.SignUp(ctx, "carol", "Carol", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := a.LoginWithPassword(ctx, "CAROL", "password", "c1"); err != nil || u.ID != carol.ID {
+		t.Errorf("login: got %+v %v", u, err)
+	}
+	// users without local accounts cannot login
+	for _, username := range []string{"alice", "123", "nobody"} {
+		if _, err := a.LoginWithPassword(ctx, username, "", "c1"); !errors.Is(err, goki.ErrInvalidCredentials) {
+			t.Errorf("%s: got %v", username, err)
+		}
+	}
+
+	// per username
+	for i := 0; i < app.LoginMaxFailures; i++ {
+		if _, err := a.LoginWithPassword(ctx, "carol", "wrong", fmt.Sprintf("client%d", i)); !errors.Is(err, goki.ErrInvalidCredentials) {
+			t.Errorf("failure %d: got %v", i, err)
+		}
+	}
+	if _, err := a.LoginWithPassword(ctx, "carol", "password", "c2"); !errors.Is(err, goki.ErrTooManyAttempts) {
+		t.Errorf("locked: got %v", err)
+	}
+	now = now.Add(app.LoginFailureWindow)
+	if _, err := a.LoginWithPassword(ctx, "carol", "password", "c2"); err != nil {
+		t.Errorf("unlocked: got %v", err)
+	}
+
+	// per client
+	for i := 0; i < app.LoginMaxFailures; i++ {
+		a.LoginWithPassword(ctx, fmt.Sprintf("user%d", i), "wrong", "c3")
+	}
+	if _, err := a.LoginWithPassword(ctx, "carol", "password", "c3"); !errors.Is(err, goki.ErrTooManyAttempts) {
+		t.Errorf("client locked: got %v", err)
+	}
+	if _, err := a.LoginWithPassword(ctx, "carol", "password", "c4"); err != nil {
+		t.Errorf("other client: got %v", err)
+	}
+
+	// unknown clients are not limited together
+	for i := 0; i < app.LoginMaxFailures; i++ {
+		a.LoginWithPassword(ctx, fmt.Sprintf("user%d", i+10), "wrong", "")
+	}
+	if _, err := a.LoginWithPassword(ctx, "carol", "password", ""); err != nil {
+		t.Errorf("unknown client: got %v", err)
+	}
+}
+
+func TestApp_Leaderboard(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	alice, _ := a.GetUser(ctx, "123")
+	bob, _ := a.GetUser(ctx, "456")
+	carol, err := a.AddUser(ctx, "789", "carol", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Activities.Add(ctx, model.NewActivity(carol.ID, time.Date(2020, 8, 15, 0, 0, 0, 0, time.UTC), 9, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	begin, end := app.YearRange(2020, time.UTC)
+
+	if es, err := a.Leaderboard(ctx, begin, end, app.RankByTotal, 0); err != nil || len(es) != 0 {
+		t.Errorf("no public users: got %v %v", es, err)
+	}
+	for _, u := range []*model.User{alice, bob, carol} {
+		if err := a.SetPublic(ctx, u, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u, _ := a.GetUser(ctx, bob.ID); !u.Public {
+		t.Error("SetPublic: not stored")
+	}
+
+	format := func(es []*app.RankEntry) string {
+		var ss []string
+		for _, e := range es {
+			ss = append(ss, fmt.Sprintf("%d:%s:%d", e.Rank, e.UserName, e.Value))
+		}
+		return strings.Join(ss, " ")
+	}
+	cases := []struct {
+		name  string
+		by    app.RankBy
+		limit int
+		exp   string
+	}{
+		{"total", app.RankByTotal, 0, "1:bob:12345678 2:alice:15 3:carol:10"},
+		{"total_limit", app.RankByTotal, 1, "1:bob:12345678"},
+		{"s_tie", app.RankByS, 0, "1:alice:9 1:carol:9"},
+		{"s_tie_limit", app.RankByS, 1, "1:alice:9 1:carol:9"},
+		{"m", app.RankByM, 0, "1:alice:6"},
+		{"l", app.RankByL, 2, "1:bob:12345678 2:carol:1"},
+		{"score_default", app.RankByScore, 0, "1:bob:12345678 2:alice:15 3:carol:10"},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			es, err := a.Leaderboard(ctx, begin, end, c.by, c.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := format(es); got != c.exp {
+				t.Errorf("want %q but got %q", c.exp, got)
+			}
+		})
+	}
+
+	// alice S9 M6 = 9+12, carol S9 L1 = 9+100
+	a.Weights = model.Weights{S: 1, M: 2, L: 100}
+	if es, _ := a.Leaderboard(ctx, begin, end, app.RankByScore, 0); format(es) != "1:bob:1234567800 2:carol:109 3:alice:21" {
+		t.Errorf("weighted score: got %q", format(es))
+	}
+	if es, _ := a.Leaderboard(ctx, begin, end, app.RankByS, 0); es[0].Score != 21 || es[1].Score != 109 {
+		t.Errorf("Score with RankByS: got %+v %+v", es[0], es[1])
+	}
+	a.Weights = model.DefaultWeights
+
+	if err := a.SetPublic(ctx, bob, false); err != nil {
+		t.Fatal(err)
+	}
+	if es, _ := a.Leaderboard(ctx, begin, end, app.RankByTotal, 0); format(es) != "1:alice:15 2:carol:10" {
+		t.Errorf("opt out: got %q", format(es))
+	}
+	begin, end = app.MonthRange(2021, time.August, time.UTC)
+	if es, _ := a.Leaderboard(ctx, begin, end, app.RankByTotal, 0); format(es) != "1:alice:300" {
+		t.Errorf("month: got %q", format(es))
+	}
+	if _, err := a.Leaderboard(ctx, begin, end, "xl", 0); !errors.Is(err, goki.ErrInvalidArgument) {
+		t.Errorf("invalid RankBy: got %v", err)
+	}
+}
+
+func TestApp_SetTimeZone(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	alice, _ := a.GetUser(ctx, "123")
+	for _, tz := range []string{"Mars/Olympus", "Local", "+09:00"} {
+		if err := a.SetTimeZone(ctx, alice, tz); !errors.Is(err, goki.ErrInvalidArgument) {
+			t.Errorf("SetTimeZone(%q): got %v", tz, err)
+		}
+	}
+	if err := a.SetTimeZone(ctx, alice, "Asia/Tokyo"); err != nil {
+		t.Fatal(err)
+	}
+	u, _ := a.GetUser(ctx, alice.ID)
+	if alice.TimeZone != "Asia/Tokyo" || u.TimeZone != "Asia/Tokyo" {
+		t.Errorf("SetTimeZone: got %q and stored %q", alice.TimeZone, u.TimeZone)
+	}
+	// 2020-08-31T20:00:00Z is September in Tokyo
+	g, err := a.CountByMonth(ctx, u.ID, 2020, time.September, u.Location())
+	if err != nil || g.S != 3 || g.M != 3 {
+		t.Errorf("CountByMonth in the user time zone: got %+v %v", g, err)
+	}
+	if err := a.SetTimeZone(ctx, alice, ""); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := a.GetUser(ctx, alice.ID); u.TimeZone != "" || u.Location() != time.Local {
+		t.Errorf("reset: got %q", u.TimeZone)
+	}
+}
+
+func TestApp_Series(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	format := func(bs []*app.Bucket) string {
+		var ss []string
+		for _, b := range bs {
+			ss = append(ss, fmt.Sprintf("%s:%d/%d/%d", b.Begin.Format("01-02"), b.G.S, b.G.M, b.G.L))
+		}
+		return strings.Join(ss, " ")
+	}
+	cases := []struct {
+		name       string
+		begin, end time.Time
+		gran       app.Granularity
+		loc        *time.Location
+		exp        string
+	}{
+		{"day", time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 8, 4, 0, 0, 0, 0, time.UTC), app.Day, time.UTC,
+			"08-01:0/0/0 08-02:6/3/0 08-03:0/0/0"},
+		{"week", time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC), app.Week, time.UTC,
+			"07-27:6/3/0 08-03:0/0/0 08-10:0/0/0 08-17:0/0/0 08-24:0/0/0 08-31:3/3/0"},
+		{"month_JST", time.Date(2020, 8, 1, 0, 0, 0, 0, jst), time.Date(2020, 10, 1, 0, 0, 0, 0, jst), app.Month, jst,
+			"08-01:6/3/0 09-01:3/3/0"},
+		{"month_aligned", time.Date(2020, 8, 15, 0, 0, 0, 0, time.UTC), time.Date(2020, 8, 16, 0, 0, 0, 0, time.UTC), app.Month, time.UTC,
+			"08-01:9/6/0"},
+		{"day_JST", time.Date(2020, 9, 1, 0, 0, 0, 0, jst), time.Date(2020, 9, 2, 0, 0, 0, 0, jst), app.Day, jst,
+			"09-01:3/3/0"},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			bs, err := a.Series(ctx, "123", c.begin, c.end, c.gran, c.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := format(bs); got != c.exp {
+				t.Errorf("want %q but got %q", c.exp, got)
+			}
+		})
+	}
+
+	// days are 23 hours on DST changes
+	ny, _ := time.LoadLocation("America/New_York")
+	bs, err := a.Series(ctx, "123", time.Date(2020, 3, 8, 0, 0, 0, 0, ny), time.Date(2020, 3, 9, 0, 0, 0, 0, ny), app.Day, ny)
+	if err != nil || len(bs) != 1 || bs[0].End.Sub(bs[0].Begin) != 23*time.Hour {
+		t.Errorf("DST: got %v %v", bs, err)
+	}
+
+	d := time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name       string
+		begin, end time.Time
+		gran       app.Granularity
+	}{
+		{"empty_range", d, d, app.Day},
+		{"reversed", d.AddDate(0, 0, 1), d, app.Day},
+		{"unknown_granularity", d, d.AddDate(0, 0, 1), "year"},
+		{"too_long", d, d.AddDate(10, 0, 0), app.Day},
+	} {
+		if _, err := a.Series(ctx, "123", c.begin, c.end, c.gran, time.UTC); !errors.Is(err, goki.ErrInvalidArgument) {
+			t.Errorf("%s: got %v", c.name, err)
+		}
+	}
+}
+
+func TestApp_ExportActivities(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+
+	var b bytes.Buffer
+	if err := a.ExportActivities(ctx, &b, "123", app.FormatCSV, jst); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 || strings.Join(rows[0], ",") != "id,user_id,time,s,m,l" {
+		t.Fatalf("CSV: got %q", rows)
+	}
+	if rows[1][1] != "123" || rows[1][2] != "2020-08-02T19:10:09+09:00" || strings.Join(rows[1][3:], ",") != "3,0,0" || rows[1][0] == "" {
+		t.Errorf("CSV row: got %q", rows[1])
+	}
+
+	b.Reset()
+	if err := a.ExportActivities(ctx, &b, "123", app.FormatJSON, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	var recs []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &recs); err != nil {
+		t.Fatalf("JSON: %v\n%s", err, b.String())
+	}
+	if len(recs) != 4 || recs[3]["time"] != "2021-08-31T23:50:00Z" || recs[3]["l"] != float64(100) {
+		t.Errorf("JSON: got %v", recs)
+	}
+
+	b.Reset()
+	if err := a.ExportActivities(ctx, &b, "000", app.FormatJSON, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b.Bytes(), &recs); err != nil || len(recs) != 0 {
+		t.Errorf("JSON empty: got %v %v\n%s", recs, err, b.String())
+	}
+
+	b.Reset()
+	if err := a.ExportAllActivities(ctx, &b, app.FormatCSV, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = csv.NewReader(&b).ReadAll()
+	if err != nil || len(rows) != 6 || rows[5][1] != "456" {
+		t.Errorf("CSV all: got %q %v", rows, err)
+	}
+
+	// activities of users missing from the UserDB
+	if err := a.Activities.Add(ctx, model.NewActivity("999", time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC), 1, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := a.ExportAllActivities(ctx, &b, app.FormatCSV, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	rows, err = csv.NewReader(&b).ReadAll()
+	if err != nil || len(rows) != 7 || rows[6][1] != "999" {
+		t.Errorf("CSV all with orphans: got %q %v", rows, err)
+	}
+
+	if err := a.ExportActivities(ctx, &b, "123", "xml", time.UTC); !errors.Is(err, goki.ErrInvalidArgument) {
+		t.Errorf("unknown format: got %v", err)
+	}
+}
+
+func TestApp_ImportActivities(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	jst, _ := time.LoadLocation("Asia/Tokyo")
+	errRows := func(res *app.ImportResult) []int {
+		var rows []int
+		for _, e := range res.Errors {
+			rows = append(rows, e.Row)
+		}
+		return rows
+	}
+	count := func(userID string) int {
+		acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(acts)
+	}
+
+	// importing an export adds nothing
+	var b bytes.Buffer
+	if err := a.ExportAllActivities(ctx, &b, app.FormatJSON, jst); err != nil {
+		t.Fatal(err)
+	}
+	res, err := a.ImportActivities(ctx, &b, "", app.FormatJSON, time.UTC, false)
+	if err != nil || res.Added != 0 || res.Duplicates != 5 || len(res.Errors) != 0 {
+		t.Fatalf("export: got %+v %v", res, err)
+	}
+
+	csvFile := "\ufeffID,time,S,M,L,memo\n" +
+		",2020-08-02T10:10:10Z,3,3,0,same\n" +
+		",2020-08-02 19:10:10,3,3,0,same in JST\n" +
+		",2020-08-02T10:10:10Z,1,0,0,conflict\n" +
+		",2022/01/01,1,,,new\n" +
+		",2022-01-01 00:00,1,0,0,same in the file\n" +
+		"x,2022-01-02,22,0,0,too many\n" +
+		",yesterday,1,0,0,unknown time\n" +
+		",2022-01-03,a,0,0,not a number\n" +
+		",2999-01-01,1,0,0,future\n"
+	for _, dryRun := range []bool{true, false} {
+		res, err := a.ImportActivities(ctx, strings.NewReader(csvFile), "123", app.FormatCSV, jst, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Added != 1 || res.Duplicates != 3 || fmt.Sprint(errRows(res)) != "[3 6 7 8 9]" {
+			t.Errorf("CSV dryRun=%v: got %+v %v", dryRun, res, errRows(res))
+		}
+		for _, e := range res.Errors {
+			if !errors.Is(e, goki.ErrInvalidArgument) {
+				t.Errorf("CSV dryRun=%v: got %v", dryRun, e)
+			}
+		}
+		want := 4
+		if !dryRun {
+			want = 5
+		}
+		if n := count("123"); n != want {
+			t.Errorf("CSV dryRun=%v: want %d activities but got %d", dryRun, want, n)
+		}
+	}
+	newT := time.Date(2022, 1, 1, 0, 0, 0, 0, jst)
+	acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: "123", Begin: newT})
+	if err != nil || len(acts) != 1 || !acts[0].TimeUTC.Equal(newT) || *acts[0].G != (model.Goki{S: 1}) {
+		t.Errorf("CSV: got %v %v", acts, err)
+	}
+
+	jsonFile := `[
+{"time": "2023-01-01T00:00:00+09:00", "s": 1},
+{"user_id": "456", "time": "2023-01-02T00:00:00Z"},
+{"time": "2023-01-03T00:00:00Z", "s": "x"}
+]`
+	res, err = a.ImportActivities(ctx, strings.NewReader(jsonFile), "123", app.FormatJSON, jst, false)
+	if err != nil || res.Added != 1 || fmt.Sprint(errRows(res)) != "[2 3]" {
+		t.Errorf("JSON: got %+v %v", res, err)
+	}
+
+	allFile := `[
+{"user_id": "456", "time": "2023-01-01T00:00:00Z", "l": 1},
+{"time": "2023-01-02T00:00:00Z"},
+{"user_id": "999", "time": "2023-01-03T00:00:00Z"}
+]`
+	res, err = a.ImportActivities(ctx, strings.NewReader(allFile), "", app.FormatJSON, jst, false)
+	if err != nil || res.Added != 1 || fmt.Sprint(errRows(res)) != "[2 3]" || !errors.Is(res.Errors[1], goki.ErrUserNotFound) {
+		t.Errorf("all users: got %+v %v", res, err)
+	}
+	if n := count("456"); n != 2 {
+		t.Errorf("all users: want 2 activities but got %d", n)
+	}
+
+	for name, c := range map[string]struct {
+		format app.Format
+		file   string
+	}{
+		"unknown_format": {"xml", "<a/>"},
+		"no_time_column": {app.FormatCSV, "id,s,m,l\n"},
+		"empty_csv":      {app.FormatCSV, ""},
+		"not_array":      {app.FormatJSON, `{"time": "2020-01-01"}`},
+		"broken_json":    {app.FormatJSON, `[{"time": `},
+	} {
+		if _, err := a.ImportActivities(ctx, strings.NewReader(c.file), "123", c.format, jst, false); !errors.Is(err, goki.ErrInvalidArgument) {
+			t.Errorf("%s: want ErrInvalidArgument but got %v", name, err)
+		}
+	}
+}
+
+func TestApp_MigrateTo(t *testing.T) {
+	src := setupApp(t)
+	defer func() {
+		if err := src.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	dir := t.TempDir()
+	udb, adb, err := db.Open(db.DriverJSON, filepath.Join(dir, "userDB.json"), filepath.Join(dir, "activityDB.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := app.NewApp(udb, adb)
+	defer func() {
+		if err := dst.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	migrate := func(name string, a *app.App, dryRun bool, want app.MigrateResult) {
+		t.Helper()
+		res, err := a.MigrateTo(ctx, dst, dryRun)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if *res != want {
+			t.Errorf("%s: want %+v but got %+v", name, want, *res)
+		}
+	}
+	verify := func(name string, a *app.App, wantDiffs int) {
+		t.Helper()
+		diffs, err := a.VerifyMigration(ctx, dst)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(diffs) != wantDiffs {
+			t.Errorf("%s: want %d diffs but got %v", name, wantDiffs, diffs)
+		}
+	}
+
+	migrate("dry_run", src, true, app.MigrateResult{UsersAdded: 2, ActivitiesAdded: 5})
+	verify("dry_run", src, 2)
+	migrate("first", src, false, app.MigrateResult{UsersAdded: 2, ActivitiesAdded: 5})
+	verify("first", src, 0)
+	if u, err := dst.GetUserByTwitterID(ctx, "12345678"); err != nil || u.ID != "123" {
+		t.Errorf("identity: got %v %v", u, err)
+	}
+	migrate("again", src, false, app.MigrateResult{UsersSkipped: 2, ActivitiesSkipped: 5})
+
+	// changes after the first migration are copied
+	u1, _ := src.GetUser(ctx, "123")
+	if err := src.SetTimeZone(ctx, u1, "Asia/Tokyo"); err != nil {
+		t.Fatal(err)
+	}
+	acts, _ := src.RecentActivities(ctx, "123", 1)
+	if _, err := src.UpdateActivity(ctx, u1, acts[0].ID, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	same := model.NewActivity("123", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), 1, 0, 0)
+	other := model.NewActivity("123", same.TimeUTC, 2, 0, 0)
+	if err := src.Activities.Add(ctx, same); err != nil {
+		t.Fatal(err)
+	}
+	verify("changed", src, 1)
+	migrate("changed", src, false, app.MigrateResult{UsersUpdated: 1, UsersSkipped: 1, ActivitiesAdded: 1, ActivitiesUpdated: 1, ActivitiesSkipped: 4})
+	verify("changed", src, 0)
+	if u, _ := dst.GetUser(ctx, "123"); u.TimeZone != "Asia/Tokyo" {
+		t.Errorf("user not updated: got %+v", u)
+	}
+
+	// activities of users missing from the UserDB are copied without the users
+	orphan := model.NewActivity("999", time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC), 1, 2, 3)
+	if err := src.Activities.Add(ctx, orphan); err != nil {
+		t.Fatal(err)
+	}
+	verify("orphan", src, 1)
+	migrate("orphan", src, false, app.MigrateResult{UsersSkipped: 2, ActivitiesAdded: 1, ActivitiesSkipped: 6, OrphanUsers: 1})
+	verify("orphan", src, 0)
+	if _, err := dst.Users.Get(ctx, "999"); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("orphan user: got %v", err)
+	}
+
+	// activities of older versions get new IDs every time the file is loaded
+	legacyPath := filepath.Join(t.TempDir(), "JSONActivityDB.json")
+	copyFile(t, filepath.Join(testdataDir, "JSONActivityDB.json"), legacyPath)
+	legacyADB, err := db.NewJSONActivityDB(legacyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := app.NewApp(src.Users, legacyADB)
+	migrate("new_ids", legacy, false, app.MigrateResult{UsersSkipped: 2, ActivitiesAdded: 1, ActivitiesSkipped: 4})
+	if err := legacyADB.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// activities at the same second are moved by JSON databases
+	if err := dst.Activities.Add(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	jsonDir := t.TempDir()
+	judb, jadb, err := db.Open(db.DriverJSON, filepath.Join(jsonDir, "users.json"), filepath.Join(jsonDir, "activities.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonApp := app.NewApp(judb, jadb)
+	defer func() {
+		if err := jsonApp.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, want := range []app.MigrateResult{
+		{UsersAdded: 2, ActivitiesAdded: 9, OrphanUsers: 1},
+		{UsersSkipped: 2, ActivitiesSkipped: 9, OrphanUsers: 1},
+	} {
+		res, err := dst.MigrateTo(ctx, jsonApp, false)
+		if err != nil || *res != want {
+			t.Errorf("to JSON: want %+v but got %+v %v", want, res, err)
+		}
+	}
+	if diffs, err := dst.VerifyMigration(ctx, jsonApp); err != nil || len(diffs) != 0 {
+		t.Errorf("to JSON: got %v %v", diffs, err)
+	}
+}
+
+func TestApp_Admin(t *testing.T) {
+	a := setupApp(t)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	a.Weights = model.Weights{S: 1, M: 2, L: 5}
+
+	ts, err := a.Totals(ctx, time.Time{}, time.Time{})
+	if err != nil || len(ts) != 2 {
+		t.Fatalf("Totals: got %v %v", ts, err)
+	}
+	if ts[0].User.ID != "123" || *ts[0].G != (model.Goki{S: 109, M: 106, L: 100}) || ts[0].Score != 109+212+500 {
+		t.Errorf("Totals: got %+v %+v", ts[0], ts[0].G)
+	}
+	ts, err = a.Totals(ctx, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Time{})
+	if err != nil || len(ts) != 2 || *ts[1].G != (model.Goki{}) || ts[1].Score != 0 {
+		t.Errorf("Totals since 2021: got %v %v", ts, err)
+	}
+
+	acts, err := a.ListActivities(ctx, "123", time.Date(2020, 8, 2, 10, 10, 10, 0, time.UTC), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil || len(acts) != 2 || acts[0].G.M != 3 {
+		t.Errorf("ListActivities: got %v %v", acts, err)
+	}
+
+	if _, err := a.LinkIdentity(ctx, "123", model.ProviderTwitter, "87654321"); !errors.Is(err, goki.ErrUserAlreadyExist) {
+		t.Errorf("LinkIdentity used: want ErrUserAlreadyExist but got %v", err)
+	}
+	if u, err := a.LinkIdentity(ctx, "123", model.ProviderTwitter, "999"); err != nil || u.Subject(model.ProviderTwitter) != "999" {
+		t.Errorf("LinkIdentity: got %v %v", u, err)
+	}
+	if u, err := a.GetUserByTwitterID(ctx, "999"); err != nil || u.ID != "123" {
+		t.Errorf("GetUserByTwitterID relinked: got %v %v", u, err)
+	}
+	if _, err := a.LinkIdentity(ctx, "000", model.ProviderTwitter, "1"); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("LinkIdentity unknown user: want ErrUserNotFound but got %v", err)
+	}
+
+	if n, err := a.DeleteUser(ctx, "123"); err != nil || n != 4 {
+		t.Errorf("DeleteUser: got %v %v", n, err)
+	}
+	if us, err := a.ListUsers(ctx); err != nil || len(us) != 1 || us[0].ID != "456" {
+		t.Errorf("ListUsers: got %v %v", us, err)
+	}
+	if acts, err := a.ListActivities(ctx, "123", time.Time{}, time.Time{}); err != nil || len(acts) != 0 {
+		t.Errorf("activities of the deleted user: got %v %v", acts, err)
+	}
+	if _, err := a.DeleteUser(ctx, "123"); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("DeleteUser deleted: want ErrUserNotFound but got %v", err)
+	}
+}
+
+func TestApp_DBError(t *testing.T) {
+	a := setupApp(t)
+	udb := memdb.NewFaultyUserDB(a.Users)
+	adb := memdb.NewFaultyActivityDB(a.Activities)
+	a = app.NewApp(udb, adb)
+	defer func() {
+		if err := a.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	u, err := a.GetUser(ctx, "123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adb.Faults.Set("Add", memdb.Fault{Err: goki.ErrDBSave})
+	if _, err := a.Action(ctx, u, 1, 0, 0); !errors.Is(err, goki.ErrDBSave) {
+		t.Errorf("Action: want ErrDBSave but got %v", err)
+	}
+	if adb.Faults.Calls("Get") != 0 {
+		t.Error("Action: the activity is read after the failed Add")
+	}
+
+	// the user is kept if activities cannot be deleted
+	adb.Faults.Set("DeleteByUser", memdb.Fault{Err: goki.ErrDBSave})
+	if _, err := a.DeleteUser(ctx, "123"); !errors.Is(err, goki.ErrDBSave) {
+		t.Errorf("DeleteUser: want ErrDBSave but got %v", err)
+	}
+	if _, err := a.GetUser(ctx, "123"); err != nil {
+		t.Errorf("DeleteUser: the user is deleted: %v", err)
+	}
+
+	// deadlines of callers are respected
+	udb.Faults.Set("List", memdb.Fault{Delay: time.Hour})
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := a.ListUsers(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("ListUsers: want context.DeadlineExceeded but got %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// Format is a file format of exported and imported activities.
+type Format string
+
+// Supported formats.
+//   - CSV: a header row of CSVHeader and a row per activity.
+//   - JSON: an array of objects with the keys of CSVHeader.
+//
+// Times are RFC 3339 with the offset of the time zone.
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+)
+
+// CSVHeader is the header row of CSV files.
+var CSVHeader = []string{"id", "user_id", "time", "s", "m", "l"}
+
+// Valid reports whether f is a known Format.
+func (f Format) Valid() bool {
+	return f == FormatCSV || f == FormatJSON
+}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	if f == FormatJSON {
+		return "application/json; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// activityRecord is an activity in exported files.
+type activityRecord struct {
+	ID     string    `json:"id"`
+	UserID string    `json:"user_id"`
+	Time   time.Time `json:"time"`
+	S      int       `json:"s"`
+	M      int       `json:"m"`
+	L      int       `json:"l"`
+}
+
+// activityWriter writes activities in a format.
+type activityWriter struct {
+	format Format
+	loc    *time.Location
+	w      io.Writer
+	csv    *csv.Writer
+	n      int // number of written activities
+}
+
+func newActivityWriter(w io.Writer, format Format, loc *time.Location) (*activityWriter, error) {
+	aw := &activityWriter{format: format, loc: loc, w: w}
+	switch format {
+	case FormatCSV:
+		aw.csv = csv.NewWriter(w)
+		if err := aw.csv.Write(CSVHeader); err != nil {
+			return nil, err
+		}
+	case FormatJSON:
+		if _, err := io.WriteString(w, "["); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", goki.ErrInvalidArgument, format)
+	}
+	return aw, nil
+}
+
+func (aw *activityWriter) write(acts []*model.Activity) error {
+	for _, a := range acts {
+		t := a.TimeUTC.In(aw.loc)
+		if aw.format == FormatCSV {
+			row := []string{a.ID, a.UserID, t.Format(time.RFC3339), strconv.Itoa(a.G.S), strconv.Itoa(a.G.M), strconv.Itoa(a.G.L)}
+			if err := aw.csv.Write(row); err != nil {
+				return err
+			}
+		} else {
+			b, err := json.Marshal(activityRecord{ID: a.ID, UserID: a.UserID, Time: t, S: a.G.S, M: a.G.M, L: a.G.L})
+			if err != nil {
+				return err
+			}
+			sep := ",\n"
+			if aw.n == 0 {
+				sep = "\n"
+			}
+			if _, err := io.WriteString(aw.w, sep+string(b)); err != nil {
+				return err
+			}
+		}
+		aw.n++
+	}
+	return nil
+}
+
+func (aw *activityWriter) close() error {
+	if aw.format == FormatCSV {
+		aw.csv.Flush()
+		return aw.csv.Error()
+	}
+	_, err := io.WriteString(aw.w, "\n]\n")
+	return err
+}
+
+// ExportActivities writes all activities of the user oldest first.
+// Times are formatted in the location.
+func (a *App) ExportActivities(ctx context.Context, w io.Writer, userID string, format Format, loc *time.Location) error {
+	aw, err := newActivityWriter(w, format, loc)
+	if err != nil {
+		return fmt.Errorf("App.ExportActivities: %w", err)
+	}
+	acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID})
+	if err != nil {
+		return fmt.Errorf("App.ExportActivities: %w", err)
+	}
+	if err := aw.write(acts); err != nil {
+		return fmt.Errorf("App.ExportActivities: %w", err)
+	}
+	if err := aw.close(); err != nil {
+		return fmt.Errorf("App.ExportActivities: %w", err)
+	}
+	return nil
+}
+
+// ExportAllActivities writes activities of all users ordered by user ID and then oldest first.
+// Users are taken from the ActivityDB, so activities of users missing from the UserDB are also written.
+// Times are formatted in the location.
+func (a *App) ExportAllActivities(ctx context.Context, w io.Writer, format Format, loc *time.Location) error {
+	aw, err := newActivityWriter(w, format, loc)
+	if err != nil {
+		return fmt.Errorf("App.ExportAllActivities: %w", err)
+	}
+	sums, err := a.Activities.SumByUser(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("App.ExportAllActivities: %w", err)
+	}
+	userIDs := make([]string, 0, len(sums))
+	for userID := range sums {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+	for _, userID := range userIDs {
+		acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID})
+		if err != nil {
+			return fmt.Errorf("App.ExportAllActivities: %w", err)
+		}
+		if err := aw.write(acts); err != nil {
+			return fmt.Errorf("App.ExportAllActivities: %w", err)
+		}
+	}
+	if err := aw.close(); err != nil {
+		return fmt.Errorf("App.ExportAllActivities: %w", err)
+	}
+	return nil
+}
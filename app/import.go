@@ -0,0 +1,328 @@
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// MaxImportRows is the maximum number of rows in an imported file.
+const MaxImportRows = 10000
+
+// importTimeLayouts are accepted time formats in addition to RFC 3339.
+// Times are in the location of the import as they have no offset.
+var importTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006/01/02",
+}
+
+// ImportError is an invalid row of an imported file.
+type ImportError struct {
+	// Row is the 1-based number of the record, not counting the CSV header.
+	Row int
+	Err error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ImportResult reports an import.
+type ImportResult struct {
+	// Added is the number of added activities, or of activities to be added on dry runs.
+	Added int
+	// Duplicates is the number of rows skipped as the same activities already exist.
+	Duplicates int
+	// Errors are invalid rows, which are skipped.
+	Errors []*ImportError
+}
+
+// importRecord is a row of an imported file.
+type importRecord struct {
+	row    int
+	id     string
+	userID string
+	time   string
+	g      model.Goki
+	err    error // the row is invalid if not nil
+}
+
+// readImportRecords reads all rows of the file.
+// Returns an error only if the file itself is broken; errors of rows are in importRecord.err.
+func readImportRecords(r io.Reader, format Format) ([]*importRecord, error) {
+	switch format {
+	case FormatCSV:
+		return readImportCSV(r)
+	case FormatJSON:
+		return readImportJSON(r)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %q", goki.ErrInvalidArgument, format)
+	}
+}
+
+// readImportCSV reads a CSV file with a header row.
+// Columns are found by the names in CSVHeader, and only "time" is required.
+// Unknown columns are ignored and empty numbers are 0 so that spreadsheets can be imported as is.
+func readImportCSV(r io.Reader) ([]*importRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: no header row", goki.ErrInvalidArgument)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", goki.ErrInvalidArgument, err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))) // BOM written by spreadsheets
+		if _, ok := cols[h]; !ok {
+			cols[h] = i
+		}
+	}
+	if _, ok := cols["time"]; !ok {
+		return nil, fmt.Errorf("%w: the header has no time column", goki.ErrInvalidArgument)
+	}
+	var recs []*importRecord
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", goki.ErrInvalidArgument, err)
+		}
+		if len(recs) == MaxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", goki.ErrInvalidArgument, MaxImportRows)
+		}
+		get := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		rec := &importRecord{row: len(recs) + 1, id: get("id"), userID: get("user_id"), time: get("time")}
+		for _, n := range []struct {
+			name string
+			dst  *int
+		}{{"s", &rec.g.S}, {"m", &rec.g.M}, {"l", &rec.g.L}} {
+			v := get(n.name)
+			if v == "" {
+				continue
+			}
+			if *n.dst, err = strconv.Atoi(v); err != nil {
+				rec.err = fmt.Errorf("%w: %s=%q is not a number", goki.ErrInvalidArgument, n.name, v)
+				break
+			}
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// readImportJSON reads an array of objects with the keys of CSVHeader.
+// Missing numbers are 0.
+func readImportJSON(r io.Reader) ([]*importRecord, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, fmt.Errorf("%w: not a JSON array", goki.ErrInvalidArgument)
+	}
+	var recs []*importRecord
+	for dec.More() {
+		if len(recs) == MaxImportRows {
+			return nil, fmt.Errorf("%w: more than %d rows", goki.ErrInvalidArgument, MaxImportRows)
+		}
+		var v struct {
+			ID     string `json:"id"`
+			UserID string `json:"user_id"`
+			Time   string `json:"time"`
+			S      int    `json:"s"`
+			M      int    `json:"m"`
+			L      int    `json:"l"`
+		}
+		rec := &importRecord{row: len(recs) + 1}
+		if err := dec.Decode(&v); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			if !errors.As(err, &typeErr) {
+				return nil, fmt.Errorf("%w: %v", goki.ErrInvalidArgument, err)
+			}
+			// the whole value has been read so the next row can be decoded
+			rec.err = fmt.Errorf("%w: %v", goki.ErrInvalidArgument, err)
+		}
+		rec.id, rec.userID, rec.time = strings.TrimSpace(v.ID), strings.TrimSpace(v.UserID), strings.TrimSpace(v.Time)
+		rec.g = model.Goki{S: v.S, M: v.M, L: v.L}
+		recs = append(recs, rec)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, fmt.Errorf("%w: %v", goki.ErrInvalidArgument, err)
+	}
+	return recs, nil
+}
+
+// parseImportTime parses RFC 3339 or one of importTimeLayouts in the location.
+// Returns the time in seconds as ActivityDB stores.
+func parseImportTime(s string, loc *time.Location) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, fmt.Errorf("%w: time is required", goki.ErrInvalidArgument)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	for _, layout := range importTimeLayouts {
+		if err == nil {
+			break
+		}
+		t, err = time.ParseInLocation(layout, s, loc)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: unknown time format %q", goki.ErrInvalidArgument, s)
+	}
+	if t.After(goki.TimeNow()) {
+		return time.Time{}, fmt.Errorf("%w: time %q is in the future", goki.ErrInvalidArgument, s)
+	}
+	return t.Truncate(time.Second).UTC(), nil
+}
+
+// ImportActivities adds activities in the file at once.
+//
+// If userID is not empty, activities are added to the user, and the user_id column must be empty or match it.
+// Otherwise the user_id column is required and must be an existing user.
+// Times without an offset are in the location.
+//
+// Invalid rows, including new activities with the numbers of roaches out of ValidateGoki, are skipped and reported in ImportResult.Errors.
+// Rows are duplicates and skipped if an activity with the same ID exists,
+// or an activity of the user has the same time (in seconds) and the same numbers of roaches.
+// Timestamps are kept as is unlike Action, so a row at the same time with different numbers is an error.
+// This makes importing the same file twice safe, e.g. after fixing invalid rows.
+//
+// Nothing is added if dryRun is true.
+// Returns an error wrapping goki.ErrInvalidArgument if the file itself is broken.
+func (a *App) ImportActivities(ctx context.Context, r io.Reader, userID string, format Format, loc *time.Location, dryRun bool) (*ImportResult, error) {
+	recs, err := readImportRecords(r, format)
+	if err != nil {
+		return nil, fmt.Errorf("App.ImportActivities: %w", err)
+	}
+	res := &ImportResult{}
+	users := map[string]bool{} // user exists
+	type key struct {
+		userID string
+		unix   int64
+	}
+	seenTimes := map[key]model.Goki{}
+	seenIDs := map[string]bool{} // UserID + ID
+	var acts []*model.Activity
+	for _, rec := range recs {
+		act, dup, err := a.checkImportRecord(ctx, rec, userID, loc, users)
+		if err != nil && !errors.Is(err, goki.ErrInvalidArgument) && !errors.Is(err, goki.ErrUserNotFound) {
+			return nil, fmt.Errorf("App.ImportActivities: %w", err)
+		}
+		if err == nil && !dup {
+			k := key{act.UserID, act.TimeUTC.Unix()}
+			if g, ok := seenTimes[k]; ok {
+				if g != *act.G {
+					err = fmt.Errorf("%w: another row has the same time", goki.ErrInvalidArgument)
+				}
+				dup = true
+			} else if act.ID != "" && seenIDs[act.UserID+"/"+act.ID] {
+				dup = true
+			}
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, &ImportError{Row: rec.row, Err: err})
+			continue
+		}
+		if dup {
+			res.Duplicates++
+			continue
+		}
+		seenTimes[key{act.UserID, act.TimeUTC.Unix()}] = *act.G
+		if act.ID != "" {
+			seenIDs[act.UserID+"/"+act.ID] = true
+		}
+		acts = append(acts, act)
+	}
+	res.Added = len(acts)
+	if dryRun || len(acts) == 0 {
+		return res, nil
+	}
+	if err := a.Activities.Import(ctx, acts); err != nil {
+		return nil, fmt.Errorf("App.ImportActivities: %w", err)
+	}
+	return res, nil
+}
+
+// checkImportRecord validates the row and checks if the activity already exists.
+// Errors of the row wrap goki.ErrInvalidArgument or goki.ErrUserNotFound.
+// users caches existence of users.
+func (a *App) checkImportRecord(ctx context.Context, rec *importRecord, userID string, loc *time.Location, users map[string]bool) (_ *model.Activity, dup bool, _ error) {
+	if rec.err != nil {
+		return nil, false, rec.err
+	}
+	switch {
+	case userID != "" && rec.userID != "" && rec.userID != userID:
+		return nil, false, fmt.Errorf("%w: user_id %q does not match", goki.ErrInvalidArgument, rec.userID)
+	case userID == "" && rec.userID == "":
+		return nil, false, fmt.Errorf("%w: user_id is required", goki.ErrInvalidArgument)
+	case userID == "":
+		userID = rec.userID
+		exists, ok := users[userID]
+		if !ok {
+			_, err := a.Users.Get(ctx, userID)
+			if err != nil && !errors.Is(err, goki.ErrUserNotFound) {
+				return nil, false, err
+			}
+			exists = err == nil
+			users[userID] = exists
+		}
+		if !exists {
+			return nil, false, fmt.Errorf("%w: %s", goki.ErrUserNotFound, userID)
+		}
+	}
+	t, err := parseImportTime(rec.time, loc)
+	if err != nil {
+		return nil, false, err
+	}
+	act := model.NewActivity(userID, t, rec.g.S, rec.g.M, rec.g.L)
+	act.ID = rec.id
+	if act.ID != "" {
+		if _, err := a.Activities.Get(ctx, userID, act.ID); err == nil {
+			return act, true, nil
+		} else if !errors.Is(err, goki.ErrActivityNotFound) {
+			return nil, false, err
+		}
+	}
+	existing, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID, Begin: t, End: t.Add(time.Second)})
+	if err != nil {
+		return nil, false, err
+	}
+	for _, e := range existing {
+		if *e.G == *act.G {
+			return act, true, nil
+		}
+	}
+	if len(existing) > 0 {
+		return nil, false, fmt.Errorf("%w: another activity exists at %s", goki.ErrInvalidArgument, t.In(loc).Format(time.RFC3339))
+	}
+	// validated after finding duplicates as existing activities may be out of the bounds
+	if err := ValidateGoki(act.G.S, act.G.M, act.G.L); err != nil {
+		return nil, false, err
+	}
+	return act, false, nil
+}
@@ -0,0 +1,82 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ebiiim/goki"
+)
+
+// failureLimiterPruneSize is the number of keys to drop all expired failures.
+const failureLimiterPruneSize = 10000
+
+// failureLimiter blocks keys with too many failures in a sliding window.
+type failureLimiter struct {
+	max    int
+	window time.Duration
+
+	mu sync.Mutex
+	// key -> failure times, oldest first
+	failures map[string][]time.Time
+}
+
+func newFailureLimiter(max int, window time.Duration) *failureLimiter {
+	return &failureLimiter{
+		max:      max,
+		window:   window,
+		failures: map[string][]time.Time{},
+	}
+}
+
+// recent drops expired failures of the key and returns the rest. Callers lock mu.
+func (l *failureLimiter) recent(key string, now time.Time) []time.Time {
+	fs := l.failures[key]
+	i := 0
+	for i < len(fs) && !fs[i].After(now.Add(-l.window)) {
+		i++
+	}
+	fs = fs[i:]
+	if len(fs) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = fs
+	return fs
+}
+
+// allow reports whether all keys are below the limit.
+func (l *failureLimiter) allow(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := goki.TimeNow()
+	for _, k := range keys {
+		if len(l.recent(k, now)) >= l.max {
+			return false
+		}
+	}
+	return true
+}
+
+// fail records a failure of the keys.
+func (l *failureLimiter) fail(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := goki.TimeNow()
+	if len(l.failures) >= failureLimiterPruneSize {
+		for k := range l.failures {
+			l.recent(k, now)
+		}
+	}
+	for _, k := range keys {
+		l.failures[k] = append(l.recent(k, now), now)
+	}
+}
+
+// reset forgets failures of the keys.
+func (l *failureLimiter) reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys {
+		delete(l.failures, k)
+	}
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// Local account constraints.
+const (
+	MinPasswordLen = 8
+	MaxPasswordLen = 72 // bcrypt ignores the rest
+)
+
+// Failed login attempts are limited per username and per client.
+const (
+	LoginMaxFailures   = 5
+	LoginFailureWindow = 15 * time.Minute
+)
+
+var usernameRe = regexp.MustCompile(`^[a-z0-9_]{3,32}$`)
+
+// dummyPasswordHash is compared when the user does not exist so that
+// response times do not reveal which usernames exist.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// NormalizeUsername returns the canonical form of a local account username.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// SignUp adds an user with a local account.
+// The username is normalized, and the display name defaults to the username.
+// Returns goki.ErrUserAlreadyExist if the username is taken.
+func (a *App) SignUp(ctx context.Context, username, userName, password string) (*model.User, error) {
+	username = NormalizeUsername(username)
+	if !usernameRe.MatchString(username) {
+		return nil, fmt.Errorf("App.SignUp: %w: username must be 3 to 32 characters of a-z, 0-9 and _", goki.ErrInvalidArgument)
+	}
+	if len(password) < MinPasswordLen || len(password) > MaxPasswordLen {
+		return nil, fmt.Errorf("App.SignUp: %w: password must be %d to %d bytes", goki.ErrInvalidArgument, MinPasswordLen, MaxPasswordLen)
+	}
+	userName = strings.TrimSpace(userName)
+	if userName == "" {
+		userName = username
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("App.SignUp: %w", err)
+	}
+	u := model.NewUser(goki.NewID(), userName, "")
+	u.Link(model.ProviderLocal, username)
+	u.PasswordHash = string(hash)
+	if err := a.Users.Add(ctx, u); err != nil {
+		return nil, fmt.Errorf("App.SignUp: %w", err)
+	}
+	return u, nil
+}
+
+// LoginWithPassword gets the user of the local account if the password matches.
+// client identifies the requester (e.g. IP address) for rate limiting, or is empty if unknown to limit the username only.
+// Returns goki.ErrInvalidCredentials on wrong username or password,
+// and goki.ErrTooManyAttempts if the username or the client failed too many times.
+func (a *App) LoginWithPassword(ctx context.Context, username, password, client string) (*model.User, error) {
+	username = NormalizeUsername(username)
+	keys := []string{"user:" + username}
+	if client != "" {
+		keys = append(keys, "client:"+client)
+	}
+	if !a.logins.allow(keys...) {
+		return nil, fmt.Errorf("App.LoginWithPassword: %w", goki.ErrTooManyAttempts)
+	}
+	u, err := a.Users.GetByIdentity(ctx, model.ProviderLocal, username)
+	if err != nil && !errors.Is(err, goki.ErrUserNotFound) {
+		return nil, fmt.Errorf("App.LoginWithPassword: %w", err)
+	}
+	hash := dummyPasswordHash
+	if u != nil && u.PasswordHash != "" {
+		hash = []byte(u.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil || u == nil || u.PasswordHash == "" {
+		a.logins.fail(keys...)
+		return nil, fmt.Errorf("App.LoginWithPassword: %w", goki.ErrInvalidCredentials)
+	}
+	a.logins.reset(keys[0]) // keep failures of the client
+	return u, nil
+}
@@ -0,0 +1,291 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// MigrateResult reports a migration. Counts are of changes to be made on dry runs.
+type MigrateResult struct {
+	UsersAdded   int
+	UsersUpdated int
+	UsersSkipped int
+
+	ActivitiesAdded   int
+	ActivitiesUpdated int
+	ActivitiesSkipped int
+
+	// OrphanUsers is the number of users missing from the UserDB whose activities are copied.
+	OrphanUsers int
+}
+
+// MigrateDiff is a user whose activities differ between two databases.
+type MigrateDiff struct {
+	UserID string
+	// Src and Dst are the totals of roaches, or nil if the user is missing.
+	Src, Dst *model.Goki
+	// SrcN and DstN are the numbers of activities.
+	SrcN, DstN int
+}
+
+func (d *MigrateDiff) String() string {
+	total := func(g *model.Goki, n int) string {
+		if g == nil {
+			return "missing"
+		}
+		return fmt.Sprintf("S=%d M=%d L=%d in %d activities", g.S, g.M, g.L, n)
+	}
+	return fmt.Sprintf("%s: src %s, dst %s", d.UserID, total(d.Src, d.SrcN), total(d.Dst, d.DstN))
+}
+
+// MigrateTo copies all users and their activities to dst.
+// Activities of users missing from the UserDB are also copied, without the users, and counted in MigrateResult.OrphanUsers.
+//
+// Migrations are idempotent so that they can be run again, e.g. to copy changes before switching databases.
+//   - Users in dst are replaced if they differ.
+//   - Activities in dst with the same ID are updated if the numbers of roaches differ.
+//   - Activities in dst at the same second with the same numbers are skipped even if the IDs differ,
+//     as activities stored by older versions get new IDs every time the JSON file is loaded.
+//
+// Activities are added with their timestamps kept, but ones at the same second as another activity
+// of the user are moved by ActivityDB.Add of JSON and GCS databases, which cannot store them as is.
+//
+// Nothing is changed if dryRun is true.
+func (a *App) MigrateTo(ctx context.Context, dst *App, dryRun bool) (*MigrateResult, error) {
+	users, err := a.Users.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("App.MigrateTo: %w", err)
+	}
+	orphans, err := a.orphanUserIDs(ctx, users)
+	if err != nil {
+		return nil, fmt.Errorf("App.MigrateTo: %w", err)
+	}
+	res := &MigrateResult{}
+	for _, u := range users {
+		if err := a.migrateUser(ctx, dst, u, dryRun, res); err != nil {
+			return nil, fmt.Errorf("App.MigrateTo: user %s: %w", u.ID, err)
+		}
+	}
+	for _, userID := range orphans {
+		res.OrphanUsers++
+		if err := a.migrateActivities(ctx, dst, userID, dryRun, res); err != nil {
+			return nil, fmt.Errorf("App.MigrateTo: user %s: %w", userID, err)
+		}
+	}
+	return res, nil
+}
+
+// orphanUserIDs returns IDs of users who have activities but are missing from users, ordered by ID.
+// Activity owners are found in the same way as ExportAllActivities.
+func (a *App) orphanUserIDs(ctx context.Context, users []*model.User) ([]string, error) {
+	sums, err := a.Activities.SumByUser(ctx, time.Time{}, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		delete(sums, u.ID)
+	}
+	ret := make([]string, 0, len(sums))
+	for userID := range sums {
+		ret = append(ret, userID)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+func (a *App) migrateUser(ctx context.Context, dst *App, u *model.User, dryRun bool, res *MigrateResult) error {
+	du, err := dst.Users.Get(ctx, u.ID)
+	switch {
+	case errors.Is(err, goki.ErrUserNotFound):
+		res.UsersAdded++
+		err = nil
+		if !dryRun {
+			err = dst.Users.Add(ctx, u)
+		}
+	case err != nil:
+	case sameUser(u, du):
+		res.UsersSkipped++
+	default:
+		res.UsersUpdated++
+		if !dryRun {
+			err = dst.Users.Update(ctx, u)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return a.migrateActivities(ctx, dst, u.ID, dryRun, res)
+}
+
+func (a *App) migrateActivities(ctx context.Context, dst *App, userID string, dryRun bool, res *MigrateResult) error {
+	acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID})
+	if err != nil {
+		return err
+	}
+	dacts, err := dst.Activities.Query(ctx, db.ActivityQuery{UserID: userID})
+	if err != nil {
+		return err
+	}
+	dstByID := map[string]*model.Activity{}
+	taken := map[int64]bool{} // seconds of activities in dst and in the batch
+	for _, da := range dacts {
+		dstByID[da.ID] = da
+		taken[da.TimeUTC.Unix()] = true
+	}
+	var pending []*model.Activity // not matched by ID
+	for _, act := range acts {
+		da, ok := dstByID[act.ID]
+		if !ok {
+			pending = append(pending, act)
+			continue
+		}
+		delete(dstByID, act.ID)
+		if *da.G == *act.G {
+			res.ActivitiesSkipped++
+			continue
+		}
+		res.ActivitiesUpdated++
+		if !dryRun {
+			if err := dst.Activities.Update(ctx, act); err != nil {
+				return err
+			}
+		}
+	}
+	unmatched := map[int64][]*model.Activity{} // dst activities not matched by ID
+	for _, da := range dstByID {
+		ut := da.TimeUTC.Unix()
+		unmatched[ut] = append(unmatched[ut], da)
+	}
+	var batch, rest []*model.Activity // added with Import, and with Add as the second is taken
+	for _, act := range pending {
+		ut := act.TimeUTC.Unix()
+		if i := matchActivity(unmatched[ut], act); i >= 0 {
+			unmatched[ut] = append(unmatched[ut][:i], unmatched[ut][i+1:]...)
+			res.ActivitiesSkipped++
+			continue
+		}
+		res.ActivitiesAdded++
+		if taken[ut] {
+			rest = append(rest, act)
+		} else {
+			taken[ut] = true
+			batch = append(batch, act)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	if len(batch) > 0 {
+		if err := dst.Activities.Import(ctx, batch); err != nil {
+			return err
+		}
+	}
+	for _, act := range rest {
+		if err := dst.Activities.Add(ctx, act); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchActivity returns the index of the activity with the same numbers as act, or -1.
+func matchActivity(acts []*model.Activity, act *model.Activity) int {
+	for i, a := range acts {
+		if *a.G == *act.G {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameUser reports whether the users are the same as stored in any database.
+// Identities are compared as a set, and API tokens in seconds.
+func sameUser(a, b *model.User) bool {
+	if a.ID != b.ID || a.Name != b.Name || a.PasswordHash != b.PasswordHash || a.Public != b.Public || a.TimeZone != b.TimeZone {
+		return false
+	}
+	if len(a.Identities) != len(b.Identities) || len(a.Tokens) != len(b.Tokens) {
+		return false
+	}
+	for _, id := range a.Identities {
+		if b.Subject(id.Provider) != id.Subject {
+			return false
+		}
+	}
+	tokens := map[string]*model.APIToken{}
+	for _, t := range b.Tokens {
+		tokens[t.ID] = t
+	}
+	for _, t := range a.Tokens {
+		bt, ok := tokens[t.ID]
+		if !ok || bt.Name != t.Name || bt.Hash != t.Hash || bt.CreatedUTC.Unix() != t.CreatedUTC.Unix() {
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyMigration compares the total of roaches (model.GokiSum) and the number of activities
+// of each user between the databases. Returns users who differ, ordered by ID.
+// Users missing from the UserDB are compared by their activities alone.
+func (a *App) VerifyMigration(ctx context.Context, dst *App) ([]*MigrateDiff, error) {
+	users, err := a.Users.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("App.VerifyMigration: %w", err)
+	}
+	orphans, err := a.orphanUserIDs(ctx, users)
+	if err != nil {
+		return nil, fmt.Errorf("App.VerifyMigration: %w", err)
+	}
+	var diffs []*MigrateDiff
+	for _, u := range users {
+		d := &MigrateDiff{UserID: u.ID}
+		if d.Src, d.SrcN, err = totalOf(ctx, a.Activities, u.ID); err != nil {
+			return nil, fmt.Errorf("App.VerifyMigration: %w", err)
+		}
+		if _, err := dst.Users.Get(ctx, u.ID); err == nil {
+			if d.Dst, d.DstN, err = totalOf(ctx, dst.Activities, u.ID); err != nil {
+				return nil, fmt.Errorf("App.VerifyMigration: %w", err)
+			}
+		} else if !errors.Is(err, goki.ErrUserNotFound) {
+			return nil, fmt.Errorf("App.VerifyMigration: %w", err)
+		}
+		if d.Dst == nil || *d.Src != *d.Dst || d.SrcN != d.DstN {
+			diffs = append(diffs, d)
+		}
+	}
+	for _, userID := range orphans {
+		d := &MigrateDiff{UserID: userID}
+		if d.Src, d.SrcN, err = totalOf(ctx, a.Activities, userID); err != nil {
+			return nil, fmt.Errorf("App.VerifyMigration: %w", err)
+		}
+		if d.Dst, d.DstN, err = totalOf(ctx, dst.Activities, userID); err != nil {
+			return nil, fmt.Errorf("App.VerifyMigration: %w", err)
+		}
+		if *d.Src != *d.Dst || d.SrcN != d.DstN {
+			diffs = append(diffs, d)
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].UserID < diffs[j].UserID })
+	return diffs, nil
+}
+
+// totalOf sums all activities of the user.
+func totalOf(ctx context.Context, adb db.ActivityDB, userID string) (*model.Goki, int, error) {
+	acts, err := adb.Query(ctx, db.ActivityQuery{UserID: userID})
+	if err != nil {
+		return nil, 0, err
+	}
+	gs := make([]*model.Goki, len(acts))
+	for i, act := range acts {
+		gs[i] = act.G
+	}
+	return model.GokiSum(gs...), len(acts), nil
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// RankBy is the value to rank users by.
+type RankBy string
+
+// Leaderboards rank users by the score, the total or one size of roaches.
+const (
+	RankByScore RankBy = "score"
+	RankByTotal RankBy = "total"
+	RankByS     RankBy = "s"
+	RankByM     RankBy = "m"
+	RankByL     RankBy = "l"
+)
+
+// Valid reports whether b is a known RankBy.
+func (b RankBy) Valid() bool {
+	switch b {
+	case RankByScore, RankByTotal, RankByS, RankByM, RankByL:
+		return true
+	}
+	return false
+}
+
+func (b RankBy) value(g *model.Goki, w model.Weights) int {
+	switch b {
+	case RankByScore:
+		return g.Score(w)
+	case RankByS:
+		return g.S
+	case RankByM:
+		return g.M
+	case RankByL:
+		return g.L
+	default:
+		return g.S + g.M + g.L
+	}
+}
+
+// RankEntry is a row of a leaderboard.
+type RankEntry struct {
+	// Rank starts from 1. Users with the same Value share the Rank.
+	Rank     int
+	UserID   string
+	UserName string
+	G        *model.Goki
+	Score    int
+	Value    int
+}
+
+// Leaderboard ranks public users by their roaches in [begin, end).
+// Scores are weighted by a.Weights.
+// Users without roaches of the kind are not ranked.
+// limit <= 0 means no limit, but tied users at the limit are all included.
+func (a *App) Leaderboard(ctx context.Context, begin, end time.Time, by RankBy, limit int) ([]*RankEntry, error) {
+	if !by.Valid() {
+		return nil, fmt.Errorf("App.Leaderboard: %w: unknown RankBy %q", goki.ErrInvalidArgument, by)
+	}
+	sums, err := a.Activities.SumByUser(ctx, begin, end)
+	if err != nil {
+		return nil, fmt.Errorf("App.Leaderboard: %w", err)
+	}
+	users, err := a.Users.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("App.Leaderboard: %w", err)
+	}
+	var ret []*RankEntry
+	for _, u := range users {
+		g, ok := sums[u.ID]
+		if !u.Public || !ok || by.value(g, a.Weights) <= 0 {
+			continue
+		}
+		ret = append(ret, &RankEntry{UserID: u.ID, UserName: u.Name, G: g, Score: g.Score(a.Weights), Value: by.value(g, a.Weights)})
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		if ret[i].Value != ret[j].Value {
+			return ret[i].Value > ret[j].Value
+		}
+		return ret[i].UserName < ret[j].UserName // users are already ordered by ID
+	})
+	for i, e := range ret {
+		if i > 0 && e.Value == ret[i-1].Value {
+			e.Rank = ret[i-1].Rank
+		} else {
+			e.Rank = i + 1
+		}
+		if limit > 0 && i >= limit && e.Rank != ret[i-1].Rank {
+			return ret[:i], nil
+		}
+	}
+	return ret, nil
+}
+
+// SetPublic sets whether the user appears on leaderboards.
+func (a *App) SetPublic(ctx context.Context, user *model.User, public bool) error {
+	u, err := a.Users.Get(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("App.SetPublic: %w", err)
+	}
+	u.Public = public
+	if err := a.Users.Update(ctx, u); err != nil {
+		return fmt.Errorf("App.SetPublic: %w", err)
+	}
+	user.Public = public
+	return nil
+}
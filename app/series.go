@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// Granularity is the bucket size of a time series.
+type Granularity string
+
+// Buckets start at midnight, on Monday (ISO 8601 week) or on the 1st of the month.
+const (
+	Day   Granularity = "day"
+	Week  Granularity = "week"
+	Month Granularity = "month"
+)
+
+// MaxSeriesBuckets is the maximum number of buckets of a time series.
+const MaxSeriesBuckets = 1000
+
+// Valid reports whether g is a known Granularity.
+func (g Granularity) Valid() bool {
+	switch g {
+	case Day, Week, Month:
+		return true
+	}
+	return false
+}
+
+// Floor returns the beginning of the bucket containing t in the location.
+func (g Granularity) Floor(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	switch g {
+	case Week:
+		// Monday is the first day of ISO weeks
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, loc)
+	case Month:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+}
+
+// Next returns the beginning of the next bucket of the bucket beginning at t.
+// time.Date normalizes the date so that days are not always 24 hours.
+func (g Granularity) Next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	switch g {
+	case Week:
+		return time.Date(y, m, d+7, 0, 0, 0, 0, t.Location())
+	case Month:
+		return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// Bucket is a period of a time series.
+type Bucket struct {
+	// Begin and End are [Begin, End) in the location of the series.
+	Begin time.Time
+	End   time.Time
+	G     *model.Goki
+}
+
+// Series counts roaches of the user per bucket in the location.
+// Buckets cover [begin, end) and are aligned to the granularity, so the first
+// and the last buckets may start before begin or end after end.
+// Buckets without roaches are included with zero counts.
+// Returns goki.ErrInvalidArgument if begin is not before end, or the series is too long.
+func (a *App) Series(ctx context.Context, userID string, begin, end time.Time, gran Granularity, loc *time.Location) ([]*Bucket, error) {
+	if !gran.Valid() {
+		return nil, fmt.Errorf("App.Series: %w: unknown granularity %q", goki.ErrInvalidArgument, gran)
+	}
+	if begin.IsZero() || end.IsZero() || !begin.Before(end) {
+		return nil, fmt.Errorf("App.Series: %w: begin and end are required and begin must be before end", goki.ErrInvalidArgument)
+	}
+	var bs []*Bucket
+	for t := gran.Floor(begin, loc); t.Before(end); t = gran.Next(t) {
+		if len(bs) == MaxSeriesBuckets {
+			return nil, fmt.Errorf("App.Series: %w: more than %d buckets", goki.ErrInvalidArgument, MaxSeriesBuckets)
+		}
+		bs = append(bs, &Bucket{Begin: t, End: gran.Next(t), G: model.NewGoki(0, 0, 0)})
+	}
+	acts, err := a.Activities.Query(ctx, db.ActivityQuery{UserID: userID, Begin: bs[0].Begin, End: bs[len(bs)-1].End})
+	if err != nil {
+		return nil, fmt.Errorf("App.Series: %w", err)
+	}
+	for _, act := range acts {
+		// the last bucket beginning at or before the activity
+		i := sort.Search(len(bs), func(i int) bool { return bs[i].Begin.After(act.TimeUTC) }) - 1
+		if i < 0 {
+			continue
+		}
+		g := bs[i].G
+		g.S += act.G.S
+		g.M += act.G.M
+		g.L += act.G.L
+	}
+	return bs, nil
+}
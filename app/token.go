@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// MaxAPITokenNameLen is the maximum length of APIToken.Name.
+const MaxAPITokenNameLen = 64
+
+// An API token looks like "{userID}.{secret}".
+// The user ID part lets GetUserByAPIToken find the user without a token index.
+const apiTokenSep = "."
+
+func hashAPIToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// CreateAPIToken creates a personal API token of the user.
+// The returned token is not stored anywhere, so show it to the user only once.
+func (a *App) CreateAPIToken(ctx context.Context, user *model.User, name string) (token string, t *model.APIToken, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > MaxAPITokenNameLen {
+		return "", nil, fmt.Errorf("App.CreateAPIToken: %w: name must be 1 to %d bytes", goki.ErrInvalidArgument, MaxAPITokenNameLen)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("App.CreateAPIToken: %w", err)
+	}
+	token = user.ID + apiTokenSep + base64.RawURLEncoding.EncodeToString(secret)
+	t = &model.APIToken{
+		ID:         goki.NewID(),
+		Name:       name,
+		Hash:       hashAPIToken(token),
+		CreatedUTC: goki.TimeNow().In(time.UTC),
+	}
+	u, err := a.Users.Get(ctx, user.ID)
+	if err != nil {
+		return "", nil, fmt.Errorf("App.CreateAPIToken: %w", err)
+	}
+	u.Tokens = append(u.Tokens, t)
+	if err := a.Users.Update(ctx, u); err != nil {
+		return "", nil, fmt.Errorf("App.CreateAPIToken: %w", err)
+	}
+	return token, t, nil
+}
+
+// ListAPITokens returns API tokens of the user.
+func (a *App) ListAPITokens(ctx context.Context, user *model.User) ([]*model.APIToken, error) {
+	u, err := a.Users.Get(ctx, user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("App.ListAPITokens: %w", err)
+	}
+	return u.Tokens, nil
+}
+
+// RevokeAPIToken deletes an API token of the user.
+// Returns goki.ErrTokenNotFound if the token does not belong to the user.
+func (a *App) RevokeAPIToken(ctx context.Context, user *model.User, tokenID string) error {
+	u, err := a.Users.Get(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("App.RevokeAPIToken: %w", err)
+	}
+	for i, t := range u.Tokens {
+		if t.ID == tokenID {
+			u.Tokens = append(u.Tokens[:i], u.Tokens[i+1:]...)
+			if err := a.Users.Update(ctx, u); err != nil {
+				return fmt.Errorf("App.RevokeAPIToken: %w", err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("App.RevokeAPIToken: %w", goki.ErrTokenNotFound)
+}
+
+// GetUserByAPIToken gets the owner of the API token.
+// Returns goki.ErrInvalidToken if the token is malformed, revoked or unknown.
+func (a *App) GetUserByAPIToken(ctx context.Context, token string) (*model.User, error) {
+	i := strings.Index(token, apiTokenSep)
+	if i <= 0 {
+		return nil, fmt.Errorf("App.GetUserByAPIToken: %w", goki.ErrInvalidToken)
+	}
+	u, err := a.Users.Get(ctx, token[:i])
+	if err != nil {
+		if errors.Is(err, goki.ErrUserNotFound) {
+			return nil, fmt.Errorf("App.GetUserByAPIToken: %w", goki.ErrInvalidToken)
+		}
+		return nil, fmt.Errorf("App.GetUserByAPIToken: %w", err)
+	}
+	h := []byte(hashAPIToken(token))
+	for _, t := range u.Tokens {
+		if subtle.ConstantTimeCompare(h, []byte(t.Hash)) == 1 {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("App.GetUserByAPIToken: %w", goki.ErrInvalidToken)
+}
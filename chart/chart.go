@@ -0,0 +1,227 @@
+// Package chart renders simple SVG charts without JavaScript.
+package chart
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Default size of charts in pixels.
+const (
+	DefaultWidth  = 600
+	DefaultHeight = 300
+)
+
+// chart margins in pixels
+const (
+	marginTop    = 30
+	marginRight  = 10
+	marginBottom = 45
+	marginLeft   = 50
+	gridLines    = 4
+	fontSize     = 12
+)
+
+// Series is a named sequence of values, one per label.
+type Series struct {
+	Name string
+	// Color is a CSS color e.g. "#dc3545".
+	Color  string
+	Values []int
+}
+
+// Chart contains data shared by all chart types.
+type Chart struct {
+	Title string
+	// Labels are shown on the X axis.
+	Labels []string
+	Series []Series
+	// Width and Height default to DefaultWidth and DefaultHeight.
+	Width  int
+	Height int
+}
+
+// BarChart draws a bar per series and label.
+type BarChart struct {
+	Chart
+	// Stacked stacks the bars of each label instead of grouping them side by side.
+	Stacked bool
+}
+
+// LineChart draws a line per series.
+type LineChart struct {
+	Chart
+}
+
+// niceCeil returns the smallest number of 1, 2 or 5 times a power of 10 not less than v.
+func niceCeil(v int) int {
+	if v <= 0 {
+		return 1
+	}
+	for p := 1; ; p *= 10 {
+		for _, m := range []int{1, 2, 5} {
+			if m*p >= v {
+				return m * p
+			}
+		}
+	}
+}
+
+// plot is the drawing area of a chart.
+type plot struct {
+	buf           bytes.Buffer
+	width, height int
+	x, y, w, h    float64 // plot area
+	max           int     // value at the top of the plot area
+}
+
+func esc(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s)) // never fails on bytes.Buffer
+	return b.String()
+}
+
+// begin writes the frame, title, grid, X labels and legend of c.
+func (c *Chart) begin(max int) *plot {
+	p := &plot{width: c.Width, height: c.Height}
+	if p.width <= 0 {
+		p.width = DefaultWidth
+	}
+	if p.height <= 0 {
+		p.height = DefaultHeight
+	}
+	p.x, p.y = marginLeft, marginTop
+	p.w = float64(p.width - marginLeft - marginRight)
+	p.h = float64(p.height - marginTop - marginBottom)
+	p.max = niceCeil(max)
+	if p.max < gridLines {
+		p.max = gridLines // keep grid labels integers
+	}
+
+	fmt.Fprintf(&p.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="%d">`+"\n",
+		p.width, p.height, p.width, p.height, fontSize)
+	fmt.Fprintf(&p.buf, `<title>%s</title>`+"\n", esc(c.Title))
+	fmt.Fprintf(&p.buf, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", p.width, p.height)
+	fmt.Fprintf(&p.buf, `<text x="%d" y="%d" text-anchor="middle" font-size="%d">%s</text>`+"\n", p.width/2, marginTop-12, fontSize+2, esc(c.Title))
+	// Y grid
+	for i := 0; i <= gridLines; i++ {
+		v := p.max * i / gridLines
+		y := p.valueY(v)
+		fmt.Fprintf(&p.buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#dee2e6"/>`+"\n", p.x, y, p.x+p.w, y)
+		fmt.Fprintf(&p.buf, `<text x="%.1f" y="%.1f" text-anchor="end" dominant-baseline="middle">%d</text>`+"\n", p.x-4, y, v)
+	}
+	// X labels
+	for i, l := range c.Labels {
+		fmt.Fprintf(&p.buf, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", p.labelX(i, len(c.Labels)), p.y+p.h+16, esc(l))
+	}
+	// legend
+	lx := p.x
+	for _, s := range c.Series {
+		fmt.Fprintf(&p.buf, `<rect x="%.1f" y="%d" width="10" height="10" fill="%s"/>`+"\n", lx, p.height-14, esc(s.Color))
+		fmt.Fprintf(&p.buf, `<text x="%.1f" y="%d">%s</text>`+"\n", lx+14, p.height-5, esc(s.Name))
+		lx += 14 + float64(len([]rune(s.Name))*fontSize) + 16
+	}
+	return p
+}
+
+// valueY returns the Y coordinate of the value.
+func (p *plot) valueY(v int) float64 {
+	return p.y + p.h - p.h*float64(v)/float64(p.max)
+}
+
+// labelX returns the X coordinate of the center of the i-th of n labels.
+func (p *plot) labelX(i, n int) float64 {
+	return p.x + p.w*(float64(i)+0.5)/float64(n)
+}
+
+func (p *plot) end(w io.Writer) error {
+	p.buf.WriteString("</svg>\n")
+	_, err := p.buf.WriteTo(w)
+	return err
+}
+
+// value returns the i-th value of the series or 0.
+func (s *Series) value(i int) int {
+	if i < len(s.Values) && s.Values[i] > 0 {
+		return s.Values[i]
+	}
+	return 0
+}
+
+// WriteSVG writes the chart as an SVG document.
+func (c *BarChart) WriteSVG(w io.Writer) error {
+	max := 0
+	for i := range c.Labels {
+		sum := 0
+		for _, s := range c.Series {
+			if c.Stacked {
+				sum += s.value(i)
+			} else if s.value(i) > sum {
+				sum = s.value(i)
+			}
+		}
+		if sum > max {
+			max = sum
+		}
+	}
+	p := c.begin(max)
+	n := len(c.Labels)
+	if n == 0 || len(c.Series) == 0 {
+		return p.end(w)
+	}
+	slot := p.w / float64(n)
+	barW := slot * 0.7
+	if !c.Stacked {
+		barW /= float64(len(c.Series))
+	}
+	for i := range c.Labels {
+		x := p.labelX(i, n) - slot*0.35
+		base := 0
+		for _, s := range c.Series {
+			v := s.value(i)
+			top := p.valueY(base + v)
+			fmt.Fprintf(&p.buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s %s: %d</title></rect>`+"\n",
+				x, top, barW, p.valueY(base)-top, esc(s.Color), esc(c.Labels[i]), esc(s.Name), v)
+			if c.Stacked {
+				base += v
+			} else {
+				x += barW
+			}
+		}
+	}
+	return p.end(w)
+}
+
+// WriteSVG writes the chart as an SVG document.
+func (c *LineChart) WriteSVG(w io.Writer) error {
+	max := 0
+	for _, s := range c.Series {
+		for i := range c.Labels {
+			if s.value(i) > max {
+				max = s.value(i)
+			}
+		}
+	}
+	p := c.begin(max)
+	n := len(c.Labels)
+	for _, s := range c.Series {
+		if n == 0 {
+			break
+		}
+		fmt.Fprintf(&p.buf, `<polyline fill="none" stroke="%s" stroke-width="2" points="`, esc(s.Color))
+		for i := range c.Labels {
+			if i > 0 {
+				p.buf.WriteByte(' ')
+			}
+			fmt.Fprintf(&p.buf, "%.1f,%.1f", p.labelX(i, n), p.valueY(s.value(i)))
+		}
+		p.buf.WriteString("\"/>\n")
+		for i := range c.Labels {
+			fmt.Fprintf(&p.buf, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s %s: %d</title></circle>`+"\n",
+				p.labelX(i, n), p.valueY(s.value(i)), esc(s.Color), esc(s.Name), esc(c.Labels[i]), s.value(i))
+		}
+	}
+	return p.end(w)
+}
@@ -0,0 +1,153 @@
+package chart_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/ebiiim/goki/chart"
+)
+
+// svgStats parses the SVG document and counts elements by name.
+// Also returns texts of the <text> elements.
+func svgStats(t *testing.T, b []byte) (counts map[string]int, texts []string) {
+	t.Helper()
+	counts = map[string]int{}
+	dec := xml.NewDecoder(bytes.NewReader(b))
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return counts, texts
+		}
+		if err != nil {
+			t.Fatalf("invalid SVG: %v\n%s", err, b)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			counts[tok.Name.Local]++
+			inText = tok.Name.Local == "text"
+		case xml.CharData:
+			if inText {
+				texts = append(texts, string(tok))
+			}
+		case xml.EndElement:
+			inText = false
+		}
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func testSeries() []chart.Series {
+	return []chart.Series{
+		{Name: "S", Color: "#000", Values: []int{1, 2, 3}},
+		{Name: "M", Color: "#111", Values: []int{4, 0, 0}},
+		{Name: "L", Color: "#222", Values: []int{2}}, // shorter than labels
+	}
+}
+
+func TestBarChart_WriteSVG(t *testing.T) {
+	cases := []struct {
+		name    string
+		stacked bool
+		top     string // label of the top grid line
+	}{
+		{"stacked", true, "10"}, // 1+4+2 = 7
+		{"grouped", false, "5"}, // max 4
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			bc := &chart.BarChart{
+				Chart: chart.Chart{
+					Title:  "Monthly",
+					Labels: []string{"Jan", "Feb", "Mar"},
+					Series: testSeries(),
+				},
+				Stacked: c.stacked,
+			}
+			var b bytes.Buffer
+			if err := bc.WriteSVG(&b); err != nil {
+				t.Fatal(err)
+			}
+			counts, texts := svgStats(t, b.Bytes())
+			// background + 9 bars + 3 legends
+			if counts["svg"] != 1 || counts["rect"] != 1+9+3 {
+				t.Errorf("got %v", counts)
+			}
+			for _, s := range []string{"Monthly", "Jan", "Mar", "S", "L", "0", c.top} {
+				if !contains(texts, s) {
+					t.Errorf("%q not found in %q", s, texts)
+				}
+			}
+		})
+	}
+}
+
+func TestLineChart_WriteSVG(t *testing.T) {
+	lc := &chart.LineChart{
+		Chart: chart.Chart{
+			Title:  "Year over year",
+			Labels: []string{"Jan", "Feb", "Mar"},
+			Series: testSeries()[:2],
+			Width:  300,
+			Height: 200,
+		},
+	}
+	var b bytes.Buffer
+	if err := lc.WriteSVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	counts, texts := svgStats(t, b.Bytes())
+	if counts["polyline"] != 2 || counts["circle"] != 6 {
+		t.Errorf("got %v", counts)
+	}
+	if !contains(texts, "5") {
+		t.Errorf("top grid line not found in %q", texts)
+	}
+	if !strings.Contains(b.String(), `width="300" height="200"`) {
+		t.Error("size not applied")
+	}
+}
+
+func TestChart_Empty(t *testing.T) {
+	for _, c := range []interface{ WriteSVG(io.Writer) error }{
+		&chart.BarChart{},
+		&chart.BarChart{Chart: chart.Chart{Labels: []string{"Jan"}}},
+		&chart.LineChart{Chart: chart.Chart{Series: testSeries()}},
+	} {
+		var b bytes.Buffer
+		if err := c.WriteSVG(&b); err != nil {
+			t.Fatal(err)
+		}
+		svgStats(t, b.Bytes())
+	}
+}
+
+func TestChart_Escape(t *testing.T) {
+	bc := &chart.BarChart{
+		Chart: chart.Chart{
+			Title:  `<script>alert("x")</script>`,
+			Labels: []string{"a&b"},
+			Series: []chart.Series{{Name: "<s>", Color: `"red`, Values: []int{1}}},
+		},
+	}
+	var b bytes.Buffer
+	if err := bc.WriteSVG(&b); err != nil {
+		t.Fatal(err)
+	}
+	counts, texts := svgStats(t, b.Bytes())
+	if counts["script"] != 0 || !contains(texts, `<script>alert("x")</script>`) || !contains(texts, "a&b") {
+		t.Errorf("got %v %q", counts, texts)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/model"
+)
+
+// newFlagSet returns a flag set with the usage of positional arguments.
+func newFlagSet(name, args, desc string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gokictl %s [flags] %s\n\n%s\n\nFlags:\n", name, args, desc)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseArgs parses the flags and exits if the number of positional arguments is less than n.
+func parseArgs(fs *flag.FlagSet, args []string, n int) []string {
+	fs.Parse(args)
+	if fs.NArg() < n {
+		fs.Usage()
+		os.Exit(2)
+	}
+	return fs.Args()
+}
+
+// withApp opens the databases, runs fn and closes them.
+func (f *dbFlags) withApp(fn func(ctx context.Context, a *app.App) error) (err error) {
+	a, err := f.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := a.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	return fn(context.Background(), a)
+}
+
+// confirm asks y/N on stdin unless yes is true.
+func confirm(yes bool, format string, args ...interface{}) bool {
+	if yes {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, format+" [y/N] ", args...)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	ans := strings.ToLower(strings.TrimSpace(line))
+	return ans == "y" || ans == "yes"
+}
+
+// newTable returns a writer aligning tab-separated columns on stdout. Flush it after use.
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+// location returns the time zone of the -tz flag, or of the user if tz is empty.
+func location(tz string, u *model.User) (*time.Location, error) {
+	if tz == "" {
+		return u.Location(), nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// period returns [begin, end) of -year and -month in the location.
+// The zero values mean unbounded.
+func period(year, month int, loc *time.Location) (begin, end time.Time, err error) {
+	switch {
+	case month != 0 && year == 0:
+		return begin, end, fmt.Errorf("-month requires -year")
+	case month < 0 || month > 12:
+		return begin, end, fmt.Errorf("-month must be in [1, 12]")
+	case month != 0:
+		begin, end = app.MonthRange(year, time.Month(month), loc)
+	case year != 0:
+		begin, end = app.YearRange(year, loc)
+	}
+	return begin, end, nil
+}
+
+func formatGoki(g *model.Goki) string {
+	return fmt.Sprintf("%d\t%d\t%d\t%d", g.S, g.M, g.L, g.S+g.M+g.L)
+}
+
+func runUsers(args []string) error {
+	fs := newFlagSet("users", "", "Lists users ordered by ID.")
+	dbf := addDBFlags(fs, "", "")
+	parseArgs(fs, args, 0)
+
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		us, err := a.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "ID\tNAME\tIDENTITIES\tPUBLIC\tTIME ZONE\tTOKENS")
+		for _, u := range us {
+			var ids []string
+			for _, id := range u.Identities {
+				ids = append(ids, id.Provider+":"+id.Subject)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%v\t%s\t%d\n", u.ID, u.Name, strings.Join(ids, ","), u.Public, u.TimeZone, len(u.Tokens))
+		}
+		return tw.Flush()
+	})
+}
+
+func runShowUser(args []string) error {
+	fs := newFlagSet("show-user", "<user-id>", "Shows a user with the total of roaches.")
+	dbf := addDBFlags(fs, "", "")
+	tz := fs.String("tz", "", "IANA time zone of timestamps (default the time zone of the user)")
+	userID := parseArgs(fs, args, 1)[0]
+
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		u, err := a.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		loc, err := location(*tz, u)
+		if err != nil {
+			return err
+		}
+		acts, err := a.ListActivities(ctx, u.ID, time.Time{}, time.Time{})
+		if err != nil {
+			return err
+		}
+		tw := newTable()
+		fmt.Fprintf(tw, "ID:\t%s\n", u.ID)
+		fmt.Fprintf(tw, "Name:\t%s\n", u.Name)
+		for _, id := range u.Identities {
+			fmt.Fprintf(tw, "Identity:\t%s:%s\n", id.Provider, id.Subject)
+		}
+		fmt.Fprintf(tw, "Password:\t%v\n", u.PasswordHash != "")
+		fmt.Fprintf(tw, "Public:\t%v\n", u.Public)
+		if u.TimeZone == "" {
+			fmt.Fprintf(tw, "Time zone:\t(server)\n")
+		} else {
+			fmt.Fprintf(tw, "Time zone:\t%s\n", u.TimeZone)
+		}
+		for _, t := range u.Tokens {
+			fmt.Fprintf(tw, "API token:\t%s %s (created %s)\n", t.ID, t.Name, t.CreatedUTC.In(loc).Format(time.RFC3339))
+		}
+		gs := make([]*model.Goki, len(acts))
+		for i, act := range acts {
+			gs[i] = act.G
+		}
+		g := model.GokiSum(gs...)
+		fmt.Fprintf(tw, "Activities:\t%d\n", len(acts))
+		fmt.Fprintf(tw, "Total:\tS=%d M=%d L=%d (%d)\n", g.S, g.M, g.L, g.S+g.M+g.L)
+		fmt.Fprintf(tw, "Score:\t%d\n", a.Score(g))
+		if len(acts) > 0 {
+			fmt.Fprintf(tw, "First:\t%s\n", acts[0].TimeUTC.In(loc).Format(time.RFC3339))
+			fmt.Fprintf(tw, "Last:\t%s\n", acts[len(acts)-1].TimeUTC.In(loc).Format(time.RFC3339))
+		}
+		return tw.Flush()
+	})
+}
+
+func runDeleteUser(args []string) error {
+	fs := newFlagSet("delete-user", "<user-id>", "Deletes a user and all activities of the user.")
+	dbf := addDBFlags(fs, "", "")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	userID := parseArgs(fs, args, 1)[0]
+
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		u, err := a.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !confirm(*yes, "delete the user %s (%s) and all activities?", u.ID, u.Name) {
+			return fmt.Errorf("canceled")
+		}
+		n, err := a.DeleteUser(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted the user %s and %d activities\n", u.ID, n)
+		return nil
+	})
+}
+
+func runRelink(args []string) error {
+	fs := newFlagSet("relink", "<user-id> <twitter-id>", "Links a user to another Twitter account, or unlinks it if twitter-id is empty.")
+	dbf := addDBFlags(fs, "", "")
+	args = parseArgs(fs, args, 2)
+	userID, twitterID := args[0], strings.TrimSpace(args[1])
+
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		u, err := a.LinkIdentity(ctx, userID, model.ProviderTwitter, twitterID)
+		if err != nil {
+			return err
+		}
+		if twitterID == "" {
+			fmt.Printf("unlinked the user %s from Twitter\n", u.ID)
+		} else {
+			fmt.Printf("linked the user %s to the Twitter ID %s\n", u.ID, twitterID)
+		}
+		return nil
+	})
+}
+
+func runActivities(args []string) error {
+	fs := newFlagSet("activities", "<user-id>", "Lists activities of a user oldest first.")
+	dbf := addDBFlags(fs, "", "")
+	tz := fs.String("tz", "", "IANA time zone of timestamps, -year and -month (default the time zone of the user)")
+	year := fs.Int("year", 0, "list only the year")
+	month := fs.Int("month", 0, "list only the month of -year")
+	userID := parseArgs(fs, args, 1)[0]
+
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		u, err := a.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		loc, err := location(*tz, u)
+		if err != nil {
+			return err
+		}
+		begin, end, err := period(*year, *month, loc)
+		if err != nil {
+			return err
+		}
+		acts, err := a.ListActivities(ctx, u.ID, begin, end)
+		if err != nil {
+			return err
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "ID\tTIME\tS\tM\tL\tTOTAL")
+		for _, act := range acts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", act.ID, act.TimeUTC.In(loc).Format(time.RFC3339), formatGoki(act.G))
+		}
+		return tw.Flush()
+	})
+}
+
+func runDeleteActivities(args []string) error {
+	fs := newFlagSet("delete-activities", "<user-id> <activity-id>...", "Deletes activities of a user.")
+	dbf := addDBFlags(fs, "", "")
+	yes := fs.Bool("yes", false, "do not ask for confirmation")
+	args = parseArgs(fs, args, 2)
+	userID, ids := args[0], args[1:]
+
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		u, err := a.GetUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		// check all first not to delete only some of them by a typo
+		for _, id := range ids {
+			if _, err := a.GetActivity(ctx, u, id); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		}
+		if !confirm(*yes, "delete %d activities of the user %s (%s)?", len(ids), u.ID, u.Name) {
+			return fmt.Errorf("canceled")
+		}
+		for _, id := range ids {
+			if err := a.DeleteActivity(ctx, u, id); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		}
+		fmt.Printf("deleted %d activities of the user %s\n", len(ids), u.ID)
+		return nil
+	})
+}
+
+func runTotals(args []string) error {
+	fs := newFlagSet("totals", "", "Prints the total of roaches and the score of each user ordered by ID.")
+	dbf := addDBFlags(fs, "", "")
+	tz := fs.String("tz", "UTC", "IANA time zone of -year and -month")
+	year := fs.Int("year", 0, "count only the year")
+	month := fs.Int("month", 0, "count only the month of -year")
+	parseArgs(fs, args, 0)
+
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	begin, end, err := period(*year, *month, loc)
+	if err != nil {
+		return err
+	}
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		ts, err := a.Totals(ctx, begin, end)
+		if err != nil {
+			return err
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "ID\tNAME\tS\tM\tL\tTOTAL\tSCORE")
+		for _, t := range ts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", t.User.ID, t.User.Name, formatGoki(t.G), t.Score)
+		}
+		return tw.Flush()
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ebiiim/goki/app"
+)
+
+func runExport(args []string) (err error) {
+	fs := newFlagSet("export", "", "Exports activities of all users, or of -user, ordered by user ID and then oldest first.")
+	dbf := addDBFlags(fs, "", "source")
+	format := fs.String("format", string(app.FormatCSV), "output format: csv or json")
+	userID := fs.String("user", "", "export only the user ID (default all users)")
+	tz := fs.String("tz", "UTC", "IANA time zone of timestamps")
+	out := fs.String("o", "-", "output file, or - for stdout")
+	parseArgs(fs, args, 0)
+
+	f := app.Format(*format)
+	if !f.Valid() {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := file.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}()
+		w = file
+	}
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		if *userID != "" {
+			if _, err := a.GetUser(ctx, *userID); err != nil {
+				return err
+			}
+			return a.ExportActivities(ctx, w, *userID, f, loc)
+		}
+		return a.ExportAllActivities(ctx, w, f, loc)
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ebiiim/goki/app"
+)
+
+func runImport(args []string) (err error) {
+	fs := newFlagSet("import", "<file>", "Imports a CSV or JSON file as gokictl export writes, or - for stdin.")
+	dbf := addDBFlags(fs, "", "destination")
+	format := fs.String("format", "", "input format: csv or json (default from the file extension, or csv)")
+	userID := fs.String("user", "", "import to the user ID (default the user_id column)")
+	tz := fs.String("tz", "UTC", "IANA time zone of timestamps without an offset")
+	dryRun := fs.Bool("dry-run", false, "validate and count without adding")
+	name := parseArgs(fs, args, 1)[0]
+
+	f := app.Format(*format)
+	if f == "" {
+		f = app.FormatCSV
+		if strings.EqualFold(filepath.Ext(name), ".json") {
+			f = app.FormatJSON
+		}
+	}
+	if !f.Valid() {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	return dbf.withApp(func(ctx context.Context, a *app.App) error {
+		if *userID != "" {
+			if _, err := a.GetUser(ctx, *userID); err != nil {
+				return err
+			}
+		}
+		res, err := a.ImportActivities(ctx, r, *userID, f, loc, *dryRun)
+		if err != nil {
+			return err
+		}
+		for _, e := range res.Errors {
+			fmt.Fprintln(os.Stderr, e)
+		}
+		verb := "added"
+		if *dryRun {
+			verb = "to be added"
+		}
+		fmt.Printf("%d %s, %d duplicates, %d errors\n", res.Added, verb, res.Duplicates, len(res.Errors))
+		if len(res.Errors) > 0 {
+			return fmt.Errorf("%d rows are invalid and skipped", len(res.Errors))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,148 @@
+// gokictl is the admin tool of goki databases.
+//
+// Usage:
+//
+//	gokictl <command> [flags]
+//
+// Run `gokictl <command> -h` for the flags of each command.
+// Databases are selected by flags, or by the db section of config.json of cmd/server with -config.
+// Stop cmd/server before changing json databases, as it does not reload files.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// Default databases are the same as cmd/server.
+const (
+	defaultDBDriver       = db.DriverJSON
+	defaultUserDBPath     = "./userDB.json"
+	defaultActivityDBPath = "./activityDB.json"
+)
+
+// command is a subcommand of gokictl.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"activities":        {"list activities of a user", runActivities},
+	"delete-activities": {"delete activities of a user", runDeleteActivities},
+	"delete-user":       {"delete a user and the activities", runDeleteUser},
+	"export":            {"export activities of all users or a user as CSV or JSON", runExport},
+	"import":            {"import activities from CSV or JSON skipping duplicates", runImport},
+	"migrate":           {"copy users and activities between databases", runMigrate},
+	"relink":            {"link a user to another Twitter account", runRelink},
+	"show-user":         {"show a user with the total of roaches", runShowUser},
+	"totals":            {"print the total of roaches of each user", runTotals},
+	"users":             {"list users", runUsers},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: gokictl <command> [flags]\n\nCommands:\n")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", name, commands[name].usage)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "gokictl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// serverConfig is the part of config.json of cmd/server used by gokictl.
+// The config package is not used as it requires config.json to start.
+type serverConfig struct {
+	DB struct {
+		Driver     string `json:"driver"`
+		UserDB     string `json:"user_db"`
+		ActivityDB string `json:"activity_db"`
+	} `json:"db"`
+	Score *model.Weights `json:"score"`
+}
+
+// dbFlags are flags to open a pair of UserDB and ActivityDB.
+type dbFlags struct {
+	config, driver, users, activities string
+}
+
+// addDBFlags adds -{prefix}config, -{prefix}driver, -{prefix}users and -{prefix}activities to the flag set.
+func addDBFlags(fs *flag.FlagSet, prefix, desc string) *dbFlags {
+	f := &dbFlags{}
+	usage := func(s string) string { return strings.TrimSpace(desc + " " + s) }
+	fs.StringVar(&f.config, prefix+"config", "", usage("config.json of cmd/server to use its db and score sections instead of -"+prefix+"driver, -"+prefix+"users and -"+prefix+"activities"))
+	fs.StringVar(&f.driver, prefix+"driver", defaultDBDriver, usage("database driver: json, json-journal, sqlite or gcs"))
+	fs.StringVar(&f.users, prefix+"users", defaultUserDBPath, usage("UserDB file, or {bucket}/{object} for gcs"))
+	fs.StringVar(&f.activities, prefix+"activities", defaultActivityDBPath, usage("ActivityDB file, or {bucket}/{object} for gcs"))
+	return f
+}
+
+// open opens the databases. Close the returned App after use.
+func (f *dbFlags) open() (*app.App, error) {
+	driver, users, activities := f.driver, f.users, f.activities
+	weights := model.DefaultWeights
+	if f.config != "" {
+		b, err := ioutil.ReadFile(f.config)
+		if err != nil {
+			return nil, err
+		}
+		var c serverConfig
+		if err := json.Unmarshal(b, &c); err != nil {
+			return nil, fmt.Errorf("could not decode config file %s: %w", f.config, err)
+		}
+		// the same defaults as cmd/server
+		driver, users, activities = c.DB.Driver, c.DB.UserDB, c.DB.ActivityDB
+		if driver == "" {
+			driver = defaultDBDriver
+		}
+		if users == "" {
+			users = defaultUserDBPath
+		}
+		if activities == "" {
+			activities = defaultActivityDBPath
+		}
+		if c.Score != nil {
+			if !c.Score.Valid() {
+				return nil, fmt.Errorf("invalid score weights %+v in config file %s", *c.Score, f.config)
+			}
+			weights = *c.Score
+		}
+	}
+	udb, adb, err := db.Open(driver, users, activities)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s databases %s and %s: %w", driver, users, activities, err)
+	}
+	for _, err := range db.Recovered(udb, adb) {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	a := app.NewApp(udb, adb)
+	a.Weights = weights
+	return a, nil
+}
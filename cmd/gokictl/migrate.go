@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+func runMigrate(args []string) (err error) {
+	fs := newFlagSet("migrate", "", "Copies users and activities from -from-* databases to -to-* databases, updating changed ones.")
+	src := addDBFlags(fs, "from-", "source")
+	dst := addDBFlags(fs, "to-", "destination")
+	dryRun := fs.Bool("dry-run", false, "count changes without copying")
+	verify := fs.Bool("verify", false, "compare the totals of roaches of each user after copying")
+	parseArgs(fs, args, 0)
+
+	if *src == *dst {
+		return fmt.Errorf("the source and the destination are the same")
+	}
+	ctx := context.Background()
+	sa, err := src.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := sa.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+	da, err := dst.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := da.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	res, err := sa.MigrateTo(ctx, da, *dryRun)
+	if err != nil {
+		return err
+	}
+	prefix := ""
+	if *dryRun {
+		prefix = "dry run: "
+	}
+	fmt.Printf("%susers: %d added, %d updated, %d unchanged\n", prefix, res.UsersAdded, res.UsersUpdated, res.UsersSkipped)
+	fmt.Printf("%sactivities: %d added, %d updated, %d unchanged\n", prefix, res.ActivitiesAdded, res.ActivitiesUpdated, res.ActivitiesSkipped)
+	if res.OrphanUsers > 0 {
+		fmt.Printf("%sactivities of %d users missing from the source user database are also copied\n", prefix, res.OrphanUsers)
+	}
+	if !*verify {
+		return nil
+	}
+	diffs, err := sa.VerifyMigration(ctx, da)
+	if err != nil {
+		return err
+	}
+	for _, d := range diffs {
+		fmt.Fprintln(os.Stderr, d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("verify: %d users differ", len(diffs))
+	}
+	fmt.Println("verify: all users have the same totals")
+	return nil
+}
@@ -6,12 +6,14 @@ import (
 	"os"
 	"os/signal"
 	"time"
+	_ "time/tzdata" // embed the time zone database for model.User.TimeZone
 
 	"cloud.google.com/go/firestore"
 	sessions "github.com/GoogleCloudPlatform/firestore-gorilla-sessions"
 	"github.com/ebiiim/goki/app"
 	"github.com/ebiiim/goki/config"
 	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/metrics"
 	"github.com/ebiiim/goki/server"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/option"
@@ -37,7 +39,8 @@ func main() {
 	if err != nil {
 		log.Fatalf("could not load activity database: %v", err)
 	}
-	ap := app.NewApp(udb, adb)
+	ap := app.NewApp(metrics.InstrumentUserDB(udb, db.DriverGCS), metrics.InstrumentActivityDB(adb, db.DriverGCS))
+	ap.Weights = *config.Params.Score
 
 	// session store
 	ctx := context.Background()
@@ -71,7 +74,7 @@ func main() {
 	}()
 	log.Printf("%s://%s\n", config.Params.Server.Scheme, config.Params.Server.Address)
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
 	log.Println("SIGINT Received!")
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	_ "time/tzdata" // embed the time zone database for model.User.TimeZone
 
 	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/acme/autocert"
@@ -13,25 +14,37 @@ import (
 	"github.com/ebiiim/goki/app"
 	"github.com/ebiiim/goki/config"
 	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/metrics"
 	"github.com/ebiiim/goki/server"
 )
 
 const (
-	userDBPath     = "./userDB.json"
-	activityDBPath = "./activityDB.json"
-	sessionDirPath = "./sessions"
+	defaultDBDriver       = db.DriverJSON
+	defaultUserDBPath     = "./userDB.json"
+	defaultActivityDBPath = "./activityDB.json"
+	sessionDirPath        = "./sessions"
 )
 
 func main() {
-	udb, err := db.NewJSONUserDB(userDBPath)
-	if err != nil {
-		log.Fatalf("could not load user database file %s: %v", userDBPath, err)
+	driver, userDB, activityDB := config.Params.DB.Driver, config.Params.DB.UserDB, config.Params.DB.ActivityDB
+	if driver == "" {
+		driver = defaultDBDriver
+	}
+	if userDB == "" {
+		userDB = defaultUserDBPath
 	}
-	adb, err := db.NewJSONActivityDB(activityDBPath)
+	if activityDB == "" {
+		activityDB = defaultActivityDBPath
+	}
+	udb, adb, err := db.Open(driver, userDB, activityDB)
 	if err != nil {
-		log.Fatalf("could not load activity database file %s: %v", activityDBPath, err)
+		log.Fatalf("could not load %s databases %s and %s: %v", driver, userDB, activityDB, err)
+	}
+	for _, err := range db.Recovered(udb, adb) {
+		log.Printf("[WARN] %v", err)
 	}
-	ap := app.NewApp(udb, adb)
+	ap := app.NewApp(metrics.InstrumentUserDB(udb, driver), metrics.InstrumentActivityDB(adb, driver))
+	ap.Weights = *config.Params.Score
 	ss := sessions.NewFilesystemStore(sessionDirPath, []byte(config.Params.Session.Key))
 	s := server.NewServer(config.Params.Server.Address, ap, ss)
 	go func() {
@@ -50,13 +63,14 @@ func main() {
 	}()
 	log.Printf("%s://%s\n", config.Params.Server.Scheme, config.Params.Server.Address)
 
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
 	log.Println("SIGINT Received!")
 
 	ctx, cancel := context.WithTimeout(context.Background(), config.ServerShutdownTimeout)
 	defer cancel()
+	// also closes the databases, which compacts the journal of json-journal
 	if err := s.Shutdown(ctx); err != nil {
 		log.Println(err)
 	}
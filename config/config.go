@@ -5,12 +5,16 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 	"time"
+
+	"github.com/ebiiim/goki/model"
 )
 
 const (
 	SessionName           = "goki.nullpo-t.net#goki"
 	SessionUserID         = "user_id"
+	SessionOIDCName       = "goki.nullpo-t.net#oidc"
 	ServerWriteTimeout    = 15 * time.Second
 	ServerReadTimeout     = 15 * time.Second
 	ServerIdleTimeout     = 60 * time.Second
@@ -22,12 +26,25 @@ type config struct {
 		Scheme   string `json:"scheme"`
 		Address  string `json:"address"`
 		BasePath string `json:"base_path"`
+		// TrustedProxies are IP addresses or CIDRs of reverse proxies e.g. "10.0.0.0/8".
+		// Failed local logins are limited per client, taken from X-Forwarded-For of requests from them,
+		// and from the remote address of other requests.
+		// If empty, they are limited per username only, as the remote address may be a proxy shared by all clients.
+		TrustedProxies []string `json:"trusted_proxies"`
 	} `json:"server"`
 	Web struct {
 		TemplateDir string `json:"template_dir"`
 		StaticDir   string `json:"static_dir"`
 		ServeStatic bool   `json:"serve_static"`
 	} `json:"web"`
+	DB struct {
+		// Driver is one of "json", "json-journal", "sqlite" and "gcs". (default "json")
+		Driver string `json:"driver"`
+		// UserDB and ActivityDB are file paths (the same as "json" for "json-journal"), or "{bucket}/{object}" for "gcs".
+		// SQLite can use the same file for both.
+		UserDB     string `json:"user_db"`
+		ActivityDB string `json:"activity_db"`
+	} `json:"db"`
 	Session struct {
 		Key string `json:"key"`
 	} `json:"session"`
@@ -41,6 +58,34 @@ type config struct {
 		// {config.Server.Scheme}://{config.Server.Address}{CallbackPath}
 		CallbackPath string `json:"callback_path"`
 	} `json:"twitter"`
+	Local struct {
+		// Enabled enables login with local accounts.
+		Enabled bool `json:"enabled"`
+		// Signup allows anyone to create a local account.
+		Signup bool `json:"signup"`
+	} `json:"local"`
+	Metrics struct {
+		// Token enables /metrics for requests with `Authorization: Bearer {Token}`. (default disabled)
+		Token string `json:"token"`
+	} `json:"metrics"`
+	// Score sets points of each roach size. (default 1 point each)
+	Score *model.Weights `json:"score"`
+	// OIDC are OpenID Connect identity providers.
+	OIDC []struct {
+		// Name identifies the provider in login URLs and linked identities e.g. "corp".
+		// DO NOT change it after users have logged in.
+		Name string `json:"name"`
+		// Label is shown on the login button.
+		Label        string `json:"label"`
+		Issuer       string `json:"issuer"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		// RedirectURL is the callback URL registered to the provider and served at its path.
+		// Login starts at {config.Server.BasePath}/login/{Name}.
+		// e.g. https://goki.example.com/login/corp/callback
+		RedirectURL string   `json:"redirect_url"`
+		Scopes      []string `json:"scopes"`
+	} `json:"oidc"`
 }
 
 var Params config
@@ -57,6 +102,13 @@ func init() {
 	if err := json.Unmarshal(f, &Params); err != nil {
 		log.Fatalf("[FATAL] could not decode config file %v: %v", p, err)
 	}
+	if Params.Score == nil {
+		w := model.DefaultWeights
+		Params.Score = &w
+	}
+	if !Params.Score.Valid() {
+		log.Fatalf("[FATAL] invalid score weights %+v in config file %v", *Params.Score, p)
+	}
 	// override credentials if env is set.
 	tk, ok := os.LookupEnv("TWITTER_CONSUMER_KEY")
 	if ok {
@@ -66,4 +118,14 @@ func init() {
 	if ok {
 		Params.Twitter.Secret = ts
 	}
+	mt, ok := os.LookupEnv("METRICS_TOKEN")
+	if ok {
+		Params.Metrics.Token = mt
+	}
+	for i, p := range Params.OIDC {
+		cs, ok := os.LookupEnv("OIDC_CLIENT_SECRET_" + strings.ToUpper(p.Name))
+		if ok {
+			Params.OIDC[i].ClientSecret = cs
+		}
+	}
 }
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// activityMap is the in-memory store shared by JSONActivityDB and GCSActivityDB.
+// Methods DO NOT lock mu; callers lock it while using and saving the data.
+type activityMap struct {
+	// UserID -> time.Unix -> Activity
+	db     map[string]map[int64]*model.Activity
+	idx    timeIndex
+	ids    idIndex
+	rollup monthRollup
+	mu     sync.Mutex
+}
+
+func newActivityMap() activityMap {
+	return activityMap{
+		db:     map[string]map[int64]*model.Activity{},
+		idx:    timeIndex{},
+		ids:    idIndex{},
+		rollup: monthRollup{},
+	}
+}
+
+// reset replaces the data and rebuilds indexes and the rollup.
+// Activities without ID (stored by older versions) get new IDs, and reports whether any did.
+func (d *activityMap) reset(db map[string]map[int64]*model.Activity) (newIDs bool) {
+	if db == nil {
+		db = map[string]map[int64]*model.Activity{}
+	}
+	for _, al := range db {
+		for _, a := range al {
+			if a.ID == "" {
+				a.ID = goki.NewID()
+				newIDs = true
+			}
+		}
+	}
+	d.db = db
+	d.idx = newTimeIndex(db)
+	d.ids = newIDIndex(db)
+	d.rollup = newMonthRollup(db)
+	return newIDs
+}
+
+func (d *activityMap) get(userID, activityID string) (*model.Activity, error) {
+	k, ok := d.ids.get(userID, activityID)
+	if !ok {
+		return nil, goki.ErrActivityNotFound
+	}
+	var act model.Activity
+	deepCopy(&act, d.db[userID][k])
+	return &act, nil
+}
+
+// add adds an activity.
+// If an activity in DB has same timestamp with the given activity, then store the given one with timestamp++.
+// A new ID is assigned if Activity.ID is empty.
+func (d *activityMap) add(act *model.Activity) error {
+	id := act.ID
+	if id == "" {
+		id = goki.NewID()
+	} else if _, ok := d.ids.get(act.UserID, id); ok {
+		return goki.ErrActivityAlreadyExist
+	}
+	// init
+	if chk, ok := d.db[act.UserID]; !ok || chk == nil {
+		d.db[act.UserID] = map[int64]*model.Activity{}
+	}
+	ut := act.TimeUTC.Unix()
+	for {
+		_, ok := d.db[act.UserID][ut]
+		if ok {
+			ut++
+			continue
+		}
+		a := model.NewActivity(act.UserID, time.Unix(ut, 0).In(time.UTC), act.G.S, act.G.M, act.G.L)
+		a.ID = id
+		d.db[act.UserID][ut] = a
+		d.idx.insert(act.UserID, ut)
+		d.ids.set(act.UserID, id, ut)
+		d.rollup.add(act.UserID, ut, a.G)
+		break
+	}
+	return nil
+}
+
+// importActivities adds activities without changing timestamps.
+// Checks all activities first so that nothing is added on error.
+// New IDs are assigned if Activity.ID is empty.
+func (d *activityMap) importActivities(acts []*model.Activity) error {
+	ids := map[string]bool{}   // UserID + ID
+	times := map[string]bool{} // UserID + time.Unix
+	for _, act := range acts {
+		ut := act.TimeUTC.Unix()
+		if _, ok := d.db[act.UserID][ut]; ok {
+			return goki.ErrActivityAlreadyExist
+		}
+		tk := fmt.Sprintf("%s/%d", act.UserID, ut)
+		if times[tk] {
+			return goki.ErrActivityAlreadyExist
+		}
+		times[tk] = true
+		if act.ID == "" {
+			continue
+		}
+		if _, ok := d.ids.get(act.UserID, act.ID); ok {
+			return goki.ErrActivityAlreadyExist
+		}
+		ik := act.UserID + "/" + act.ID
+		if ids[ik] {
+			return goki.ErrActivityAlreadyExist
+		}
+		ids[ik] = true
+	}
+	for _, act := range acts {
+		// never bumps the timestamp as it is checked above
+		if err := d.add(act); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update updates Activity.G of the activity.
+func (d *activityMap) update(act *model.Activity) error {
+	k, ok := d.ids.get(act.UserID, act.ID)
+	if !ok {
+		return goki.ErrActivityNotFound
+	}
+	stored := d.db[act.UserID][k]
+	d.rollup.sub(act.UserID, k, stored.G)
+	stored.G = model.NewGoki(act.G.S, act.G.M, act.G.L)
+	d.rollup.add(act.UserID, k, stored.G)
+	return nil
+}
+
+func (d *activityMap) delete(userID, activityID string) error {
+	k, ok := d.ids.get(userID, activityID)
+	if !ok {
+		return goki.ErrActivityNotFound
+	}
+	d.rollup.sub(userID, k, d.db[userID][k].G)
+	delete(d.db[userID], k)
+	d.idx.remove(userID, k)
+	d.ids.remove(userID, activityID)
+	return nil
+}
+
+// deleteByUser deletes all activities of the user.
+func (d *activityMap) deleteByUser(userID string) int {
+	n := len(d.db[userID])
+	delete(d.db, userID)
+	delete(d.idx, userID)
+	delete(d.ids, userID)
+	delete(d.rollup, userID)
+	return n
+}
+
+// query runs an ActivityQuery using the timeIndex.
+// Returns deep copies.
+func (d *activityMap) query(q ActivityQuery) []*model.Activity {
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	keys := d.idx.between(q.UserID, q.Begin, q.End)
+	n := len(keys) - q.Offset
+	if n <= 0 {
+		return []*model.Activity{}
+	}
+	if q.Limit > 0 && q.Limit < n {
+		n = q.Limit
+	}
+	al := d.db[q.UserID]
+	ret := make([]*model.Activity, n)
+	for i := 0; i < n; i++ {
+		k := keys[q.Offset+i]
+		if q.Order == OrderDesc {
+			k = keys[len(keys)-1-q.Offset-i]
+		}
+		var act model.Activity
+		deepCopy(&act, al[k])
+		ret[i] = &act
+	}
+	return ret
+}
+
+func (d *activityMap) sumByUser(begin, end time.Time) map[string]*model.Goki {
+	ret := map[string]*model.Goki{}
+	for userID := range d.rollup {
+		g, n := d.sum(userID, begin, end)
+		if n == 0 {
+			continue
+		}
+		ret[userID] = &g
+	}
+	return ret
+}
@@ -1,28 +1,103 @@
 package db
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"strings"
 	"time"
 
+	"github.com/ebiiim/goki"
 	"github.com/ebiiim/goki/model"
 )
 
 // UserDB interface provides User operations.
 type UserDB interface {
 	io.Closer
-	Get(userID string) (*model.User, error)
-	GetByTwitterID(twitterID string) (*model.User, error)
-	Add(user *model.User) error
+	Get(ctx context.Context, userID string) (*model.User, error)
+	// GetByIdentity gets an user by an account of an identity provider.
+	GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error)
+	// GetByTwitterID is a shorthand for GetByIdentity(model.ProviderTwitter, twitterID).
+	GetByTwitterID(ctx context.Context, twitterID string) (*model.User, error)
+	// Add adds an user.
+	// Returns goki.ErrUserAlreadyExist if the ID or any identity is already used.
+	Add(ctx context.Context, user *model.User) error
+	// Update replaces an user.
+	// Returns goki.ErrUserAlreadyExist if any identity is used by another user.
+	Update(ctx context.Context, user *model.User) error
+	// List returns all users ordered by ID.
+	List(ctx context.Context) ([]*model.User, error)
+	// Delete deletes an user. Activities of the user are not deleted.
+	Delete(ctx context.Context, userID string) error
 }
 
 // ActivityDB interface provides Activity operations.
 type ActivityDB interface {
 	io.Closer
-	Add(activity *model.Activity) error
-	Query(userID string, queryFn func(a *model.Activity) bool) ([]*model.Activity, error)
+	Get(ctx context.Context, userID, activityID string) (*model.Activity, error)
+	Add(ctx context.Context, activity *model.Activity) error
+	// Import adds activities at once, keeping their timestamps unlike Add.
+	// Returns goki.ErrActivityAlreadyExist and adds nothing if an ID is already used,
+	// or if the user already has an activity at the same second.
+	Import(ctx context.Context, activities []*model.Activity) error
+	Update(ctx context.Context, activity *model.Activity) error
+	Delete(ctx context.Context, userID, activityID string) error
+	// DeleteByUser deletes all activities of the user and returns the number of deleted activities.
+	DeleteByUser(ctx context.Context, userID string) (int, error)
+	Query(ctx context.Context, q ActivityQuery) ([]*model.Activity, error)
+	// Sum sums roaches of the user in [begin, end) without copying activities.
+	// The zero value of begin or end means unbounded.
+	Sum(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error)
+	// SumByUser sums roaches of each user in [begin, end) at once.
+	// The zero value of begin or end means unbounded. Users without activities are omitted.
+	SumByUser(ctx context.Context, begin, end time.Time) (map[string]*model.Goki, error)
 }
 
-// QueryFuncTime returns a queryFn for ActivityDB.Query method.
+// Order specifies the order of ActivityDB.Query results.
+type Order int
+
+const (
+	// OrderAsc sorts activities by timestamp, oldest first. (default)
+	OrderAsc Order = iota
+	// OrderDesc sorts activities by timestamp, newest first.
+	OrderDesc
+)
+
+// ActivityQuery represents conditions for ActivityDB.Query method.
+type ActivityQuery struct {
+	// UserID is the owner of activities.
+	UserID string
+	// Begin is the inclusive lower bound of Activity.TimeUTC. The zero value means unbounded.
+	Begin time.Time
+	// End is the exclusive upper bound of Activity.TimeUTC. The zero value means unbounded.
+	End time.Time
+	// Order sorts activities before applying Offset and Limit.
+	Order Order
+	// Offset skips the first N activities.
+	Offset int
+	// Limit is the maximum number of activities. 0 means no limit.
+	Limit int
+}
+
+// QueryFunc returns activities of the user that queryFn returns true (may be empty).
+// This is a compatibility adapter for the old closure-based ActivityDB.Query
+// and scans all activities of the user, so use ActivityQuery if possible.
+func QueryFunc(ctx context.Context, d ActivityDB, userID string, queryFn func(a *model.Activity) bool) ([]*model.Activity, error) {
+	acts, err := d.Query(ctx, ActivityQuery{UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+	var ret []*model.Activity
+	for _, a := range acts {
+		if queryFn(a) {
+			ret = append(ret, a)
+		}
+	}
+	return ret, nil
+}
+
+// QueryFuncTime returns a queryFn for QueryFunc.
+// Note that both afterUTC and beforeUTC are exclusive unlike ActivityQuery.
 func QueryFuncTime(afterUTC time.Time, beforeUTC time.Time) func(a *model.Activity) bool {
 	return func(a *model.Activity) bool {
 		if a.TimeUTC.After(afterUTC) && a.TimeUTC.Before(beforeUTC) {
@@ -31,3 +106,88 @@ func QueryFuncTime(afterUTC time.Time, beforeUTC time.Time) func(a *model.Activi
 		return false
 	}
 }
+
+// Drivers supported by Open.
+const (
+	DriverJSON        = "json"
+	DriverJSONJournal = "json-journal"
+	DriverSQLite      = "sqlite"
+	DriverGCS         = "gcs"
+)
+
+// Open opens an UserDB and an ActivityDB with the given driver.
+// userSrc and activitySrc are file paths for DriverJSON, DriverJSONJournal and DriverSQLite (may be the same file for SQLite),
+// and "{bucket}/{object}" for DriverGCS.
+// DriverJSONJournal is DriverJSON with a journaled JSONActivityDB.
+func Open(driver, userSrc, activitySrc string) (UserDB, ActivityDB, error) {
+	var (
+		udb UserDB
+		adb ActivityDB
+		err error
+	)
+	switch driver {
+	case DriverJSON:
+		if udb, err = NewJSONUserDB(userSrc); err != nil {
+			return nil, nil, err
+		}
+		if adb, err = NewJSONActivityDB(activitySrc); err != nil {
+			udb.Close()
+			return nil, nil, err
+		}
+	case DriverJSONJournal:
+		if udb, err = NewJSONUserDB(userSrc); err != nil {
+			return nil, nil, err
+		}
+		if adb, err = NewJournaledJSONActivityDB(activitySrc); err != nil {
+			udb.Close()
+			return nil, nil, err
+		}
+	case DriverSQLite:
+		if udb, err = NewSQLiteUserDB(userSrc); err != nil {
+			return nil, nil, err
+		}
+		if adb, err = NewSQLiteActivityDB(activitySrc); err != nil {
+			udb.Close()
+			return nil, nil, err
+		}
+	case DriverGCS:
+		ub, uf, err := splitGCSPath(userSrc)
+		if err != nil {
+			return nil, nil, err
+		}
+		ab, af, err := splitGCSPath(activitySrc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if udb, err = NewGCSUserDB(ub, uf); err != nil {
+			return nil, nil, err
+		}
+		if adb, err = NewGCSActivityDB(ab, af); err != nil {
+			udb.Close()
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, goki.ErrWrap(goki.ErrDBOpen, fmt.Errorf("unknown driver %q", driver))
+	}
+	return udb, adb, nil
+}
+
+// Recovered returns the problems the databases recovered from on opening, e.g. *RecoveredError of JSON files restored from backups,
+// for the caller to log as warnings.
+func Recovered(udb UserDB, adb ActivityDB) []error {
+	var ret []error
+	for _, d := range []interface{}{udb, adb} {
+		if r, ok := d.(interface{ Recovered() error }); ok && r.Recovered() != nil {
+			ret = append(ret, r.Recovered())
+		}
+	}
+	return ret
+}
+
+func splitGCSPath(src string) (bucket, object string, err error) {
+	ss := strings.SplitN(strings.TrimPrefix(src, "gs://"), "/", 2)
+	if len(ss) != 2 || ss[0] == "" || ss[1] == "" {
+		return "", "", goki.ErrWrap(goki.ErrDBOpen, fmt.Errorf("invalid GCS path %q", src))
+	}
+	return ss[0], ss[1], nil
+}
@@ -0,0 +1,572 @@
+// Package dbtest is a conformance test suite of db.UserDB and db.ActivityDB implementations.
+//
+// Every backend runs TestUserDB and TestActivityDB with a function opening an empty database.
+// The other functions are the tests run by them, for backends that also test their own state, e.g. after reopening.
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+var ctx = context.Background()
+
+var (
+	jst, _         = time.LoadLocation("Asia/Tokyo")
+	u1             = model.NewUser("123", "alice", "12345678")
+	u2             = model.NewUser("456", "bob", "87654321")
+	a1t            = time.Date(2020, 8, 1, 9, 0, 0, 0, time.UTC)
+	a2t            = time.Date(2020, 8, 2, 10, 10, 10, 10, time.UTC)
+	a3t            = time.Date(2020, 8, 1, 9, 0, 0, 0, time.UTC)
+	a3             = model.NewActivity(u2.ID, a3t, 0, 0, 12345678)
+	utc202008Begin = time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)
+	utc202009Begin = time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	jst202008Begin = time.Date(2020, 8, 1, 0, 0, 0, 0, jst).In(time.UTC)
+	jst202009Begin = time.Date(2020, 9, 1, 0, 0, 0, 0, jst).In(time.UTC)
+	utc202109Begin = time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	utc202110Begin = time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)
+	jst202109Begin = time.Date(2021, 9, 1, 0, 0, 0, 0, jst).In(time.UTC)
+	jst202110Begin = time.Date(2021, 10, 1, 0, 0, 0, 0, jst).In(time.UTC)
+)
+
+// activityQueryCases are ActivityQuery test cases against the query data.
+var activityQueryCases = []struct {
+	name     string
+	q        db.ActivityQuery
+	expNum   int
+	expFirst time.Time // not checked if zero
+}{
+	{"taro_invalid_user", db.ActivityQuery{UserID: "000"}, 0, time.Time{}},
+	{"alice_all", db.ActivityQuery{UserID: u1.ID}, 4, time.Date(2020, 8, 2, 10, 10, 9, 0, time.UTC)},
+	{"alice_UTC202008", db.ActivityQuery{UserID: u1.ID, Begin: utc202008Begin, End: utc202009Begin}, 3, time.Time{}},
+	{"alice_JST202008", db.ActivityQuery{UserID: u1.ID, Begin: jst202008Begin, End: jst202009Begin}, 2, time.Time{}},
+	{"alice_UTC202109", db.ActivityQuery{UserID: u1.ID, Begin: utc202109Begin, End: utc202110Begin}, 0, time.Time{}},
+	{"alice_JST202109", db.ActivityQuery{UserID: u1.ID, Begin: jst202109Begin, End: jst202110Begin}, 1, time.Time{}},
+	{"alice_begin_inclusive", db.ActivityQuery{UserID: u1.ID, Begin: time.Date(2020, 8, 31, 20, 0, 0, 0, time.UTC), End: time.Date(2020, 8, 31, 20, 0, 1, 0, time.UTC)}, 1, time.Time{}},
+	{"alice_end_exclusive", db.ActivityQuery{UserID: u1.ID, Begin: utc202008Begin, End: time.Date(2020, 8, 31, 20, 0, 0, 0, time.UTC)}, 2, time.Time{}},
+	{"alice_desc_limit", db.ActivityQuery{UserID: u1.ID, Order: db.OrderDesc, Limit: 1}, 1, time.Date(2021, 8, 31, 23, 50, 0, 0, time.UTC)},
+	{"alice_asc_offset_limit", db.ActivityQuery{UserID: u1.ID, Offset: 2, Limit: 1}, 1, time.Date(2020, 8, 31, 20, 0, 0, 0, time.UTC)},
+	{"alice_desc_offset", db.ActivityQuery{UserID: u1.ID, Order: db.OrderDesc, Offset: 3}, 1, time.Date(2020, 8, 2, 10, 10, 9, 0, time.UTC)},
+	{"alice_offset_over", db.ActivityQuery{UserID: u1.ID, Offset: 10}, 0, time.Time{}},
+	{"bob_UTC202008", db.ActivityQuery{UserID: u2.ID, Begin: utc202008Begin, End: utc202009Begin}, 1, time.Time{}},
+}
+
+// ActivityCRUD tests Get, Update and Delete with an empty ActivityDB.
+func ActivityCRUD(t *testing.T, d db.ActivityDB) {
+	t.Helper()
+	a1 := model.NewActivity(u1.ID, a1t, 1, 2, 3)
+	a1.ID = "alice-id"
+	if err := d.Add(ctx, a1); err != nil {
+		t.Fatal(err)
+	}
+	a2 := model.NewActivity(u2.ID, a1t, 0, 0, 1)
+	a2.ID = "given-id"
+	if err := d.Add(ctx, a2); err != nil {
+		t.Fatal(err)
+	}
+	noID := model.NewActivity(u2.ID, a2t, 0, 1, 0)
+	if err := d.Add(ctx, noID); err != nil {
+		t.Fatal(err)
+	}
+	if acts, err := d.Query(ctx, db.ActivityQuery{UserID: u2.ID, Begin: a2t.Truncate(time.Second)}); err != nil || len(acts) != 1 || acts[0].ID == "" {
+		t.Fatalf("Add without ID: got %v %v", acts, err)
+	}
+	dup := model.NewActivity(u2.ID, a2t, 0, 0, 1)
+	dup.ID = a2.ID
+	if err := d.Add(ctx, dup); !errors.Is(err, goki.ErrActivityAlreadyExist) {
+		t.Errorf("Add: want ErrActivityAlreadyExist but got %v", err)
+	}
+	getCases := []struct {
+		name       string
+		userID     string
+		activityID string
+		expG       model.Goki
+		err        error
+	}{
+		{"alice", u1.ID, a1.ID, model.Goki{S: 1, M: 2, L: 3}, nil},
+		{"bob", u2.ID, a2.ID, model.Goki{S: 0, M: 0, L: 1}, nil},
+		{"F_bob_gets_alice", u2.ID, a1.ID, model.Goki{}, goki.ErrActivityNotFound},
+		{"F_invalid_id", u1.ID, "000", model.Goki{}, goki.ErrActivityNotFound},
+	}
+	for _, c := range getCases {
+		c := c
+		t.Run("Get_"+c.name, func(t *testing.T) {
+			a, err := d.Get(ctx, c.userID, c.activityID)
+			if c.err != nil {
+				if !errors.Is(err, c.err) {
+					t.Errorf("want %v but got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if a.ID != c.activityID || a.UserID != c.userID || *a.G != c.expG {
+				t.Errorf("data %+v", a)
+			}
+		})
+	}
+	// Update
+	upd := model.NewActivity(u1.ID, a1t, 9, 9, 9)
+	upd.ID = a1.ID
+	if err := d.Update(ctx, upd); err != nil {
+		t.Error(err)
+	}
+	if a, err := d.Get(ctx, u1.ID, a1.ID); err != nil || *a.G != (model.Goki{S: 9, M: 9, L: 9}) || !a.TimeUTC.Equal(a1t) {
+		t.Errorf("Update: got %+v %v", a, err)
+	}
+	upd.UserID = u2.ID
+	if err := d.Update(ctx, upd); !errors.Is(err, goki.ErrActivityNotFound) {
+		t.Errorf("Update: want ErrActivityNotFound but got %v", err)
+	}
+	// Delete
+	if err := d.Delete(ctx, u2.ID, a1.ID); !errors.Is(err, goki.ErrActivityNotFound) {
+		t.Errorf("Delete: want ErrActivityNotFound but got %v", err)
+	}
+	if err := d.Delete(ctx, u1.ID, a1.ID); err != nil {
+		t.Error(err)
+	}
+	if _, err := d.Get(ctx, u1.ID, a1.ID); !errors.Is(err, goki.ErrActivityNotFound) {
+		t.Errorf("Get after Delete: want ErrActivityNotFound but got %v", err)
+	}
+	if acts, err := d.Query(ctx, db.ActivityQuery{UserID: u1.ID}); err != nil || len(acts) != 0 {
+		t.Errorf("Query after Delete: got %v %v", acts, err)
+	}
+}
+
+// ActivityQuery tests Query with the query data.
+func ActivityQuery(t *testing.T, d db.ActivityDB) {
+	t.Helper()
+	for _, c := range activityQueryCases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			res, err := d.Query(ctx, c.q)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(res) != c.expNum {
+				t.Errorf("want %v but got %v", c.expNum, len(res))
+				return
+			}
+			if !c.expFirst.IsZero() && !res[0].TimeUTC.Equal(c.expFirst) {
+				t.Errorf("want %v but got %v", c.expFirst, res[0].TimeUTC)
+			}
+			for _, a := range res {
+				if a.ID == "" {
+					t.Error("no Activity.ID")
+				}
+			}
+		})
+	}
+}
+
+// ActivitySumByUser tests SumByUser with the query data.
+func ActivitySumByUser(t *testing.T, d db.ActivityDB) {
+	t.Helper()
+	cases := []struct {
+		name       string
+		begin, end time.Time
+		exp        map[string]model.Goki
+	}{
+		{"all", time.Time{}, time.Time{}, map[string]model.Goki{u1.ID: {S: 109, M: 106, L: 100}, u2.ID: {L: 12345678}}},
+		{"UTC202008", utc202008Begin, utc202009Begin, map[string]model.Goki{u1.ID: {S: 9, M: 6}, u2.ID: {L: 12345678}}},
+		{"JST202008", jst202008Begin, jst202009Begin, map[string]model.Goki{u1.ID: {S: 6, M: 3}, u2.ID: {L: 12345678}}},
+		{"since_UTC202009", utc202009Begin, time.Time{}, map[string]model.Goki{u1.ID: {S: 100, M: 100, L: 100}}},
+		{"UTC202109", utc202109Begin, utc202110Begin, map[string]model.Goki{}},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			res, err := d.SumByUser(ctx, c.begin, c.end)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(res) != len(c.exp) {
+				t.Errorf("want %v but got %v", c.exp, res)
+				return
+			}
+			for id, g := range c.exp {
+				if res[id] == nil || *res[id] != g {
+					t.Errorf("%s: want %+v but got %+v", id, g, res[id])
+				}
+			}
+		})
+	}
+}
+
+// ActivitySum tests Sum with the query data, also after changes of the activities.
+func ActivitySum(t *testing.T, d db.ActivityDB) {
+	t.Helper()
+	check := func(name, userID string, begin, end time.Time, want model.Goki) {
+		t.Helper()
+		g, err := d.Sum(ctx, userID, begin, end)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			return
+		}
+		if *g != want {
+			t.Errorf("%s: want %+v but got %+v", name, want, *g)
+		}
+	}
+	check("all", u1.ID, time.Time{}, time.Time{}, model.Goki{S: 109, M: 106, L: 100})
+	check("UTC202008", u1.ID, utc202008Begin, utc202009Begin, model.Goki{S: 9, M: 6})
+	check("JST202008", u1.ID, jst202008Begin, jst202009Begin, model.Goki{S: 6, M: 3})
+	check("JST202109", u1.ID, jst202109Begin, jst202110Begin, model.Goki{S: 100, M: 100, L: 100})
+	check("UTC202109", u1.ID, utc202109Begin, utc202110Begin, model.Goki{})
+	check("begin_inclusive", u1.ID, time.Date(2020, 8, 31, 20, 0, 0, 0, time.UTC), time.Date(2020, 8, 31, 20, 0, 1, 0, time.UTC), model.Goki{S: 3, M: 3})
+	check("until_2021", u1.ID, time.Time{}, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), model.Goki{S: 9, M: 6})
+	check("bob", u2.ID, utc202008Begin, utc202009Begin, model.Goki{L: 12345678})
+	check("invalid_user", "000", time.Time{}, time.Time{}, model.Goki{})
+
+	acts, err := d.Query(ctx, db.ActivityQuery{UserID: u1.ID, Begin: utc202008Begin, End: utc202009Begin})
+	if err != nil || len(acts) != 3 {
+		t.Fatalf("got %v %v", acts, err)
+	}
+	if err := d.Delete(ctx, u1.ID, acts[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	acts[2].G = model.NewGoki(1, 1, 1)
+	if err := d.Update(ctx, acts[2]); err != nil {
+		t.Fatal(err)
+	}
+	check("UTC202008_changed", u1.ID, utc202008Begin, utc202009Begin, model.Goki{S: 4, M: 4, L: 1})
+	check("JST202008_changed", u1.ID, jst202008Begin, jst202009Begin, model.Goki{S: 3, M: 3})
+
+	if _, err := d.DeleteByUser(ctx, u1.ID); err != nil {
+		t.Fatal(err)
+	}
+	check("deleted", u1.ID, time.Time{}, time.Time{}, model.Goki{})
+	if sums, err := d.SumByUser(ctx, time.Time{}, time.Time{}); err != nil || len(sums) != 1 || sums[u2.ID] == nil {
+		t.Errorf("SumByUser deleted: got %v %v", sums, err)
+	}
+}
+
+// ActivityImport tests Import with the query data.
+func ActivityImport(t *testing.T, d db.ActivityDB) {
+	t.Helper()
+	newT := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	withID := func(a *model.Activity, id string) *model.Activity {
+		a.ID = id
+		return a
+	}
+	conflicts := []struct {
+		name string
+		acts []*model.Activity
+	}{
+		{"existing_time", []*model.Activity{
+			model.NewActivity(u1.ID, newT, 1, 0, 0),
+			model.NewActivity(u1.ID, a2t, 1, 0, 0),
+		}},
+		{"same_time_in_batch", []*model.Activity{
+			model.NewActivity(u1.ID, newT, 1, 0, 0),
+			model.NewActivity(u1.ID, newT, 2, 0, 0),
+		}},
+		{"same_id_in_batch", []*model.Activity{
+			withID(model.NewActivity(u1.ID, newT, 1, 0, 0), "imp1"),
+			withID(model.NewActivity(u1.ID, newT.Add(time.Second), 1, 0, 0), "imp1"),
+		}},
+	}
+	for _, c := range conflicts {
+		if err := d.Import(ctx, c.acts); !errors.Is(err, goki.ErrActivityAlreadyExist) {
+			t.Errorf("%s: want ErrActivityAlreadyExist but got %v", c.name, err)
+		}
+	}
+	if acts, _ := d.Query(ctx, db.ActivityQuery{UserID: u1.ID}); len(acts) != 4 {
+		t.Fatalf("added on error: got %d activities", len(acts))
+	}
+
+	if err := d.Import(ctx, []*model.Activity{
+		withID(model.NewActivity(u1.ID, newT, 1, 2, 3), "imp1"),
+		model.NewActivity(u1.ID, newT.Add(time.Second), 4, 5, 6),
+		model.NewActivity(u2.ID, a2t, 1, 0, 0), // same time as alice's
+	}); err != nil {
+		t.Fatal(err)
+	}
+	acts, err := d.Query(ctx, db.ActivityQuery{UserID: u1.ID, Begin: newT})
+	if err != nil || len(acts) != 2 {
+		t.Fatalf("got %v %v", acts, err)
+	}
+	if acts[0].ID != "imp1" || !acts[0].TimeUTC.Equal(newT) || *acts[0].G != (model.Goki{S: 1, M: 2, L: 3}) {
+		t.Errorf("got %+v", acts[0])
+	}
+	if acts[1].ID == "" || !acts[1].TimeUTC.Equal(newT.Add(time.Second)) {
+		t.Errorf("got %+v", acts[1])
+	}
+	if acts, _ := d.Query(ctx, db.ActivityQuery{UserID: u2.ID}); len(acts) != 2 {
+		t.Errorf("bob: got %d activities", len(acts))
+	}
+	if err := d.Import(ctx, []*model.Activity{withID(model.NewActivity(u1.ID, newT.Add(time.Hour), 1, 0, 0), "imp1")}); !errors.Is(err, goki.ErrActivityAlreadyExist) {
+		t.Errorf("existing_id: want ErrActivityAlreadyExist but got %v", err)
+	}
+}
+
+// UserDelete tests Delete with an empty UserDB.
+func UserDelete(t *testing.T, d db.UserDB) {
+	t.Helper()
+	for _, u := range []*model.User{u1, u2} {
+		if err := d.Add(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Delete(ctx, u1.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, u1.ID); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("Get deleted: want ErrUserNotFound but got %v", err)
+	}
+	if _, err := d.GetByTwitterID(ctx, u1.Subject(model.ProviderTwitter)); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("GetByTwitterID deleted: want ErrUserNotFound but got %v", err)
+	}
+	if err := d.Delete(ctx, u1.ID); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("Delete deleted: want ErrUserNotFound but got %v", err)
+	}
+	if us, err := d.List(ctx); err != nil || len(us) != 1 || us[0].ID != u2.ID {
+		t.Errorf("List: got %v %v", us, err)
+	}
+	// the identity can be linked again
+	if err := d.Add(ctx, model.NewUser("789", "alice2", u1.Subject(model.ProviderTwitter))); err != nil {
+		t.Error(err)
+	}
+}
+
+// ActivityDeleteByUser tests DeleteByUser with the query data.
+func ActivityDeleteByUser(t *testing.T, d db.ActivityDB) {
+	t.Helper()
+	if n, err := d.DeleteByUser(ctx, u1.ID); err != nil || n != 4 {
+		t.Errorf("want 4 but got %v %v", n, err)
+	}
+	if acts, err := d.Query(ctx, db.ActivityQuery{UserID: u1.ID}); err != nil || len(acts) != 0 {
+		t.Errorf("Query deleted: got %v %v", acts, err)
+	}
+	if acts, err := d.Query(ctx, db.ActivityQuery{UserID: u2.ID}); err != nil || len(acts) != 1 {
+		t.Errorf("Query other user: got %v %v", acts, err)
+	}
+	if n, err := d.DeleteByUser(ctx, u1.ID); err != nil || n != 0 {
+		t.Errorf("want 0 but got %v %v", n, err)
+	}
+	// activities can be added again
+	if err := d.Add(ctx, model.NewActivity(u1.ID, a2t, 1, 0, 0)); err != nil {
+		t.Error(err)
+	}
+}
+
+// UserList tests List with an empty UserDB.
+func UserList(t *testing.T, d db.UserDB) {
+	t.Helper()
+	if us, err := d.List(ctx); err != nil || len(us) != 0 {
+		t.Errorf("List empty: got %v %v", us, err)
+	}
+	u3 := model.NewUser("012", "carol", "")
+	u3.Link("corp", "carol@corp")
+	u3.Tokens = []*model.APIToken{{ID: "t1", Name: "cli", Hash: "h1", CreatedUTC: a1t}}
+	for _, u := range []*model.User{u2, u1, u3} {
+		if err := d.Add(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	us, err := d.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 3 || us[0].ID != u3.ID || us[1].ID != u1.ID || us[2].ID != u2.ID {
+		t.Fatalf("List: got %+v", us)
+	}
+	if us[0].Subject("corp") != "carol@corp" || len(us[0].Tokens) != 1 || us[1].Subject(model.ProviderTwitter) != u1.Subject(model.ProviderTwitter) {
+		t.Errorf("List: got %+v %+v", us[0], us[1])
+	}
+	us[0].Name = "not stored" // must not affect the stored user
+	if u, err := d.Get(ctx, u3.ID); err != nil || u.Name != "carol" {
+		t.Errorf("List returned a shared user: got %+v %v", u, err)
+	}
+}
+
+// UserUpdate tests Update with an empty UserDB.
+func UserUpdate(t *testing.T, d db.UserDB) {
+	t.Helper()
+	if err := d.Add(ctx, u1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Add(ctx, u2); err != nil {
+		t.Fatal(err)
+	}
+	u, err := d.Get(ctx, u1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Name = "alice2"
+	u.PasswordHash = "hash"
+	u.Public = true
+	u.TimeZone = "Asia/Tokyo"
+	u.Tokens = []*model.APIToken{
+		{ID: "t1", Name: "cli", Hash: "h1", CreatedUTC: a1t},
+		{ID: "t2", Name: "bot", Hash: "h2", CreatedUTC: a3t},
+	}
+	if err := d.Update(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	u.Name = "not stored" // must not affect the stored user
+	got, err := d.Get(ctx, u1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "alice2" || got.PasswordHash != "hash" || !got.Public || got.TimeZone != "Asia/Tokyo" || len(got.Tokens) != 2 || got.Tokens[1].Hash != "h2" || !got.Tokens[0].CreatedUTC.Equal(a1t) {
+		t.Errorf("Update: got %+v", got)
+	}
+	got.Tokens = got.Tokens[1:]
+	if err := d.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.GetByTwitterID(ctx, u1.Subject(model.ProviderTwitter)); err != nil || len(got.Tokens) != 1 || got.Tokens[0].ID != "t2" {
+		t.Errorf("Update remove token: got %+v %v", got, err)
+	}
+	dup := model.NewUser(u2.ID, u2.Name, u1.Subject(model.ProviderTwitter))
+	if err := d.Update(ctx, dup); !errors.Is(err, goki.ErrUserAlreadyExist) {
+		t.Errorf("Update Twitter ID duplicated: got %v", err)
+	}
+	if err := d.Update(ctx, model.NewUser("000", "taro", "00000000")); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("Update not found: got %v", err)
+	}
+}
+
+// UserIdentity tests identities with an empty UserDB.
+func UserIdentity(t *testing.T, d db.UserDB) {
+	t.Helper()
+	local := model.NewUser("789", "carol", "") // no identity
+	local2 := model.NewUser("000", "taro", "")
+	for _, u := range []*model.User{u1, local, local2} {
+		if err := d.Add(ctx, u); err != nil {
+			t.Fatalf("Add %s: %v", u.Name, err)
+		}
+	}
+	u, err := d.Get(ctx, u1.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Link("corp", "alice@corp")
+	if err := d.Update(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct{ provider, subject, userID string }{
+		{"corp", "alice@corp", u1.ID},
+		{model.ProviderTwitter, u1.Subject(model.ProviderTwitter), u1.ID},
+		{"corp", "", ""},
+		{"corp", u1.Subject(model.ProviderTwitter), ""},
+		{model.ProviderTwitter, "", ""},
+	} {
+		got, err := d.GetByIdentity(ctx, c.provider, c.subject)
+		if c.userID == "" {
+			if !errors.Is(err, goki.ErrUserNotFound) {
+				t.Errorf("GetByIdentity(%q, %q): got %+v %v", c.provider, c.subject, got, err)
+			}
+			continue
+		}
+		if err != nil || got.ID != c.userID || len(got.Identities) != 2 {
+			t.Errorf("GetByIdentity(%q, %q): got %+v %v", c.provider, c.subject, got, err)
+		}
+	}
+	dup := model.NewUser("999", "mallory", "")
+	dup.Link("corp", "alice@corp")
+	if err := d.Add(ctx, dup); !errors.Is(err, goki.ErrUserAlreadyExist) {
+		t.Errorf("Add identity duplicated: got %v", err)
+	}
+	local.Link("corp", "alice@corp")
+	if err := d.Update(ctx, local); !errors.Is(err, goki.ErrUserAlreadyExist) {
+		t.Errorf("Update identity duplicated: got %v", err)
+	}
+	// unlink and link to another user
+	u.Link("corp", "")
+	if err := d.Update(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Update(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.GetByIdentity(ctx, "corp", "alice@corp"); err != nil || got.ID != local.ID {
+		t.Errorf("relink: got %+v %v", got, err)
+	}
+}
+
+// TestUserDB runs all UserDB tests, each with a new empty UserDB returned by open.
+func TestUserDB(t *testing.T, open func(t *testing.T) db.UserDB) {
+	t.Helper()
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, d db.UserDB)
+	}{
+		{"Update", UserUpdate},
+		{"Identity", UserIdentity},
+		{"List", UserList},
+		{"Delete", UserDelete},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			d := open(t)
+			defer func() {
+				if err := d.Close(); err != nil {
+					t.Error(err)
+				}
+			}()
+			c.fn(t, d)
+		})
+	}
+}
+
+// TestActivityDB runs all ActivityDB tests, each with a new empty ActivityDB returned by open.
+// The query data is added by AddQueryData for tests which need it.
+func TestActivityDB(t *testing.T, open func(t *testing.T) db.ActivityDB) {
+	t.Helper()
+	cases := []struct {
+		name      string
+		queryData bool
+		fn        func(t *testing.T, d db.ActivityDB)
+	}{
+		{"CRUD", false, ActivityCRUD},
+		{"Query", true, ActivityQuery},
+		{"Sum", true, ActivitySum},
+		{"SumByUser", true, ActivitySumByUser},
+		{"Import", true, ActivityImport},
+		{"DeleteByUser", true, ActivityDeleteByUser},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			d := open(t)
+			defer func() {
+				if err := d.Close(); err != nil {
+					t.Error(err)
+				}
+			}()
+			if c.queryData {
+				AddQueryData(t, d)
+			}
+			c.fn(t, d)
+		})
+	}
+}
+
+// AddQueryData adds the query data, the same activities as db/testdata/JSONActivityDB_Query.json:
+// 4 activities of the user "123" and 1 of the user "456".
+func AddQueryData(t *testing.T, d db.ActivityDB) {
+	t.Helper()
+	for _, a := range []*model.Activity{
+		model.NewActivity(u1.ID, a2t.Add(-time.Second), 3, 0, 0),
+		model.NewActivity(u1.ID, a2t, 3, 3, 0),
+		model.NewActivity(u1.ID, utc202009Begin.Add(-4*time.Hour), 3, 3, 0),
+		model.NewActivity(u1.ID, utc202109Begin.Add(-10*time.Minute), 100, 100, 100),
+		a3,
+	} {
+		if err := d.Add(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -3,19 +3,38 @@ package db
 import (
 	"context"
 	"encoding/json"
-	"sync"
+	"errors"
+	"math/rand"
+	"net/http"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/ebiiim/goki"
 	"github.com/ebiiim/goki/model"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
 var gcsClient *storage.Client // client.Close need not be called at exit
 var gcsAccessTimeout = 10 * time.Second
 
+// gcsMaxRetries is the number of times a change is applied again on conflicts with other instances.
+const gcsMaxRetries = 5
+
+// gcsRetryWait is the maximum wait before the first retry, doubled on every retry.
+// Waits are random not to conflict again with instances retrying at the same time.
+const gcsRetryWait = 100 * time.Millisecond
+
+// DefaultGCSRefreshInterval is the default interval of checking changes by other instances on reads.
+const DefaultGCSRefreshInterval = 5 * time.Second
+
+// errGCSConflict is returned by gcsObject.write if another instance has changed the object.
+var errGCSConflict = errors.New("the object has been changed by another instance")
+
+// errGCSStale is returned if the loaded data has a change failed to be saved and could not be reloaded.
+var errGCSStale = errors.New("could not reload the data after a failed save")
+
 func initClientIfNeeded() error {
 	if gcsClient != nil {
 		return nil
@@ -34,15 +53,92 @@ func initClientIfNeeded() error {
 	return nil
 }
 
+// gcsObject is a JSON object in GCS written with a generation precondition,
+// so that changes by other instances are never overwritten.
+type gcsObject struct {
+	client *storage.Client
+	bucket string
+	name   string
+	gen    int64     // generation of the loaded data, 0 if not loaded
+	synced time.Time // when gen was checked
+	stale  bool      // the loaded data has a change failed to be written
+}
+
+func (o *gcsObject) handle() *storage.ObjectHandle {
+	return o.client.Bucket(o.bucket).Object(o.name)
+}
+
+// read decodes the object into v and keeps its generation.
+func (o *gcsObject) read(ctx context.Context, v interface{}) error {
+	ctx, cancelFunc := context.WithTimeout(ctx, gcsAccessTimeout)
+	defer cancelFunc()
+	reader, err := o.handle().NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	if err := json.NewDecoder(reader).Decode(v); err != nil {
+		return err
+	}
+	o.gen, o.synced, o.stale = reader.Attrs.Generation, time.Now(), false
+	return nil
+}
+
+// write encodes v into the object unless another instance has changed it since read or written.
+// Returns errGCSConflict if changed.
+func (o *gcsObject) write(ctx context.Context, v interface{}) error {
+	ctx, cancelFunc := context.WithTimeout(ctx, gcsAccessTimeout)
+	defer cancelFunc()
+	cond := storage.Conditions{GenerationMatch: o.gen}
+	if o.gen == 0 {
+		cond = storage.Conditions{DoesNotExist: true}
+	}
+	writer := o.handle().If(cond).NewWriter(ctx)
+	if err := json.NewEncoder(writer).Encode(v); err != nil {
+		return err // the upload is canceled with ctx
+	}
+	// Writes happen asynchronously!
+	if err := writer.Close(); err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+			return errGCSConflict
+		}
+		return err
+	}
+	o.gen, o.synced = writer.Attrs().Generation, time.Now()
+	return nil
+}
+
+// changed reports whether another instance has changed the object or the loaded data is stale.
+// GCS is accessed at most once per interval, and false is returned in between.
+func (o *gcsObject) changed(ctx context.Context, interval time.Duration) (bool, error) {
+	if o.stale {
+		return true, nil
+	}
+	if time.Since(o.synced) < interval {
+		return false, nil
+	}
+	ctx, cancelFunc := context.WithTimeout(ctx, gcsAccessTimeout)
+	defer cancelFunc()
+	attrs, err := o.handle().Attrs(ctx)
+	if err != nil {
+		return false, err
+	}
+	o.synced = time.Now()
+	return attrs.Generation != o.gen, nil
+}
+
 // GCSUserDB is an easy UserDB stores data in a JSON file and saves it in GCS.
-// Cannot be read from multiple app instances.
+// Multiple app instances can share the file; changes are saved only if no other instance has saved
+// the file since loaded, and are applied again to the reloaded data otherwise.
+// Reads reload the file if changed, checked every RefreshInterval.
+// A change failed to be saved is dropped by reloading the file, and reads fail until it is reloaded.
 type GCSUserDB struct {
-	bucket string
-	file   string
-	client *storage.Client
+	obj gcsObject
+	// RefreshInterval is the interval of checking changes by other instances on reads. (default DefaultGCSRefreshInterval)
+	RefreshInterval time.Duration
 
-	db map[string]*model.User
-	mu sync.Mutex
+	userMap
 }
 
 var _ UserDB = (*GCSUserDB)(nil)
@@ -54,119 +150,157 @@ func NewGCSUserDB(bucket, file string) (*GCSUserDB, error) {
 	if err := initClientIfNeeded(); err != nil {
 		return nil, err
 	}
+	return NewGCSUserDBWithClient(gcsClient, bucket, file)
+}
 
+// NewGCSUserDBWithClient initializes a GCSUserDB with the client, e.g. of an emulator.
+// A UserDB must be created in GCS.
+func NewGCSUserDBWithClient(client *storage.Client, bucket, file string) (*GCSUserDB, error) {
 	d := &GCSUserDB{
-		bucket: bucket,
-		file:   file,
-		client: gcsClient,
-		db:     map[string]*model.User{},
+		obj:             gcsObject{client: client, bucket: bucket, name: file},
+		RefreshInterval: DefaultGCSRefreshInterval,
+		userMap:         newUserMap(),
 	}
-	if err := d.load(); err != nil {
+	if err := d.load(context.Background()); err != nil {
 		return nil, goki.ErrWrap(goki.ErrDBOpen, err)
 	}
 	return d, nil
 }
 
-func (d *GCSUserDB) load() error {
-	// Load JSON.
-	ctx := context.Background()
-	ctx, cancelFunc := context.WithTimeout(ctx, gcsAccessTimeout)
-	defer cancelFunc()
-	// logf(DEBUG, "GCSUserDB.load: read GCS")
-	obj := d.client.Bucket(d.bucket).Object(d.file)
-	reader, err := obj.NewReader(ctx)
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
+func (d *GCSUserDB) load(ctx context.Context) error {
 	var db map[string]*model.User
-	if err := json.NewDecoder(reader).Decode(&db); err != nil {
+	if err := d.obj.read(ctx, &db); err != nil {
 		return err
 	}
-	d.db = db
+	d.reset(db)
 	return nil
 }
 
-func (d *GCSUserDB) save() error {
-	ctx := context.Background()
-	ctx, cancelFunc := context.WithTimeout(ctx, gcsAccessTimeout)
-	defer cancelFunc()
-	// logf(DEBUG, "GCSUserDB.save: write GCS")
-	obj := d.client.Bucket(d.bucket).Object(d.file)
-	writer := obj.NewWriter(ctx)
-	if err := json.NewEncoder(writer).Encode(&d.db); err != nil {
-		return err
+// refresh reloads the data if another instance has changed it or a change failed to be saved.
+// Errors are ignored to keep serving the loaded data while GCS is unavailable,
+// but errGCSStale is returned if the data still has a change failed to be saved.
+func (d *GCSUserDB) refresh(ctx context.Context) error {
+	if changed, err := d.obj.changed(ctx, d.RefreshInterval); err == nil && changed {
+		d.load(ctx)
 	}
-	// Writes happen asynchronously!
-	if err := writer.Close(); err != nil {
-		return err
+	if d.obj.stale {
+		return errGCSStale
 	}
 	return nil
 }
 
-// Close saves data to the database JSON file.
-func (d *GCSUserDB) Close() error {
-	if err := d.save(); err != nil {
-		return goki.ErrWrap(goki.ErrDBClose, err)
+// discard drops a change failed to be saved by reloading the data.
+// If GCS is unavailable, the data stays stale and is reloaded on the next access.
+// The context of the change is not used as it may be canceled.
+func (d *GCSUserDB) discard() {
+	d.obj.stale = true
+	d.load(context.Background())
+}
+
+// change applies fn to the data and saves it.
+// If another instance has saved the data in the meantime, reloads it and applies fn again.
+func (d *GCSUserDB) change(ctx context.Context, fn func() error) error {
+	if err := d.refresh(ctx); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
 	}
+	for i := 0; ; i++ {
+		if err := fn(); err != nil {
+			return err
+		}
+		err := d.obj.write(ctx, &d.db)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errGCSConflict) || i == gcsMaxRetries {
+			d.discard()
+			return goki.ErrWrap(goki.ErrDBSave, err)
+		}
+		if err := sleepCtx(ctx, time.Duration(rand.Int63n(int64(gcsRetryWait<<i)))); err != nil {
+			d.discard()
+			return goki.ErrWrap(goki.ErrDBSave, err)
+		}
+		if err := d.load(ctx); err != nil {
+			d.discard()
+			return goki.ErrWrap(goki.ErrDBSave, err)
+		}
+	}
+}
+
+// Close does nothing as every change has been saved.
+func (d *GCSUserDB) Close() error {
 	return nil
 }
 
 // Get gets an user or error.
-func (d *GCSUserDB) Get(userID string) (*model.User, error) {
+func (d *GCSUserDB) Get(ctx context.Context, userID string) (*model.User, error) {
 	d.mu.Lock()
-	u, ok := d.db[userID]
-	d.mu.Unlock()
-	if !ok {
-		return nil, goki.ErrUserNotFound
+	defer d.mu.Unlock()
+	if err := d.refresh(ctx); err != nil {
+		return nil, err
 	}
-	var uu model.User
-	deepCopy(&uu, u)
-	return &uu, nil
+	return d.get(userID)
 }
 
-// GetByTwitterID gets an user by Twitter ID or error.
-func (d *GCSUserDB) GetByTwitterID(twitterID string) (*model.User, error) {
-	var uu model.User
+// GetByIdentity gets an user by an account of an identity provider or error.
+func (d *GCSUserDB) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	for _, u := range d.db {
-		if u.Twitter.ID == twitterID {
-			deepCopy(&uu, u)
-			return &uu, nil
-		}
+	if err := d.refresh(ctx); err != nil {
+		return nil, err
 	}
-	return nil, goki.ErrUserNotFound
+	return d.getByIdentity(provider, subject)
+}
+
+// GetByTwitterID gets an user by Twitter ID or error.
+func (d *GCSUserDB) GetByTwitterID(ctx context.Context, twitterID string) (*model.User, error) {
+	return d.GetByIdentity(ctx, model.ProviderTwitter, twitterID)
 }
 
 // Add adds an user.
-func (d *GCSUserDB) Add(user *model.User) error {
-	if _, err := d.Get(user.ID); err == nil {
-		return goki.ErrUserAlreadyExist
-	}
-	if _, err := d.GetByTwitterID(user.Twitter.ID); err == nil {
-		return goki.ErrUserAlreadyExist
-	}
-	u := model.NewUser(user.ID, user.Name, user.Twitter.ID)
+func (d *GCSUserDB) Add(ctx context.Context, user *model.User) error {
 	d.mu.Lock()
-	d.db[user.ID] = u
-	d.mu.Unlock()
-	if err := d.save(); err != nil {
-		return goki.ErrWrap(goki.ErrDBSave, err)
+	defer d.mu.Unlock()
+	return d.change(ctx, func() error {
+		return d.add(user)
+	})
+}
+
+// Update replaces an user.
+func (d *GCSUserDB) Update(ctx context.Context, user *model.User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.change(ctx, func() error {
+		return d.update(user)
+	})
+}
+
+// Delete deletes an user.
+func (d *GCSUserDB) Delete(ctx context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.change(ctx, func() error {
+		return d.delete(userID)
+	})
+}
+
+// List returns all users ordered by ID.
+func (d *GCSUserDB) List(ctx context.Context) ([]*model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.refresh(ctx); err != nil {
+		return nil, err
 	}
-	return nil
+	return d.list(), nil
 }
 
 // GCSActivityDB is an easy ActivityDB stores data in a JSON file and saves it in GCS.
-// Cannot be read from multiple app instances.
+// Multiple app instances can share the file in the same way as GCSUserDB.
 type GCSActivityDB struct {
-	bucket string
-	file   string
-	client *storage.Client
+	obj gcsObject
+	// RefreshInterval is the interval of checking changes by other instances on reads. (default DefaultGCSRefreshInterval)
+	RefreshInterval time.Duration
 
-	// UserID -> time.Unix -> Activity
-	db map[string]map[int64]*model.Activity
-	mu sync.Mutex
+	activityMap
 }
 
 var _ ActivityDB = (*GCSActivityDB)(nil)
@@ -178,110 +312,202 @@ func NewGCSActivityDB(bucket, file string) (*GCSActivityDB, error) {
 	if err := initClientIfNeeded(); err != nil {
 		return nil, err
 	}
+	return NewGCSActivityDBWithClient(gcsClient, bucket, file)
+}
 
+// NewGCSActivityDBWithClient initializes a GCSActivityDB with the client, e.g. of an emulator.
+// An ActivityDB must be created in GCS.
+func NewGCSActivityDBWithClient(client *storage.Client, bucket, file string) (*GCSActivityDB, error) {
 	d := &GCSActivityDB{
-		bucket: bucket,
-		file:   file,
-		client: gcsClient,
-		db:     map[string]map[int64]*model.Activity{},
+		obj:             gcsObject{client: client, bucket: bucket, name: file},
+		RefreshInterval: DefaultGCSRefreshInterval,
+		activityMap:     newActivityMap(),
 	}
-	if err := d.load(); err != nil {
+	ctx := context.Background()
+	newIDs, err := d.load(ctx)
+	if err != nil {
 		return nil, goki.ErrWrap(goki.ErrDBOpen, err)
 	}
+	if newIDs {
+		// save IDs given to legacy activities so that all instances use the same IDs
+		if err := d.change(ctx, func() error { return nil }); err != nil {
+			return nil, goki.ErrWrap(goki.ErrDBOpen, err)
+		}
+	}
 	return d, nil
 }
 
-func (d *GCSActivityDB) load() error {
-	// Load JSON.
-	ctx := context.Background()
-	ctx, cancelFunc := context.WithTimeout(ctx, gcsAccessTimeout)
-	defer cancelFunc()
-	// logf(DEBUG, "GCSActivityDB.load: read GCS")
-	obj := d.client.Bucket(d.bucket).Object(d.file)
-	reader, err := obj.NewReader(ctx)
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
+// load loads the data and reports whether legacy activities got new IDs.
+func (d *GCSActivityDB) load(ctx context.Context) (bool, error) {
 	var db map[string]map[int64]*model.Activity
-	if err := json.NewDecoder(reader).Decode(&db); err != nil {
-		return err
+	if err := d.obj.read(ctx, &db); err != nil {
+		return false, err
 	}
-	d.db = db
-	return nil
+	return d.reset(db), nil
 }
 
-func (d *GCSActivityDB) save() error {
-	ctx := context.Background()
-	ctx, cancelFunc := context.WithTimeout(ctx, gcsAccessTimeout)
-	defer cancelFunc()
-	// logf(DEBUG, "GCSActivityDB.save: write GCS")
-	obj := d.client.Bucket(d.bucket).Object(d.file)
-	writer := obj.NewWriter(ctx)
-	if err := json.NewEncoder(writer).Encode(&d.db); err != nil {
-		return err
+// refresh reloads the data if another instance has changed it or a change failed to be saved.
+// Errors are ignored to keep serving the loaded data while GCS is unavailable,
+// but errGCSStale is returned if the data still has a change failed to be saved.
+func (d *GCSActivityDB) refresh(ctx context.Context) error {
+	if changed, err := d.obj.changed(ctx, d.RefreshInterval); err == nil && changed {
+		d.load(ctx)
 	}
-	// Writes happen asynchronously!
-	if err := writer.Close(); err != nil {
-		return err
+	if d.obj.stale {
+		return errGCSStale
 	}
 	return nil
 }
 
-// Close saves data to the database JSON file.
-func (d *GCSActivityDB) Close() error {
-	if err := d.save(); err != nil {
-		return goki.ErrWrap(goki.ErrDBClose, err)
+// discard drops a change failed to be saved by reloading the data.
+// If GCS is unavailable, the data stays stale and is reloaded on the next access.
+// The context of the change is not used as it may be canceled.
+func (d *GCSActivityDB) discard() {
+	d.obj.stale = true
+	d.load(context.Background())
+}
+
+// change applies fn to the data and saves it.
+// If another instance has saved the data in the meantime, reloads it and applies fn again.
+func (d *GCSActivityDB) change(ctx context.Context, fn func() error) error {
+	if err := d.refresh(ctx); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
 	}
+	for i := 0; ; i++ {
+		if err := fn(); err != nil {
+			return err
+		}
+		err := d.obj.write(ctx, &d.db)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errGCSConflict) || i == gcsMaxRetries {
+			d.discard()
+			return goki.ErrWrap(goki.ErrDBSave, err)
+		}
+		if err := sleepCtx(ctx, time.Duration(rand.Int63n(int64(gcsRetryWait<<i)))); err != nil {
+			d.discard()
+			return goki.ErrWrap(goki.ErrDBSave, err)
+		}
+		if _, err := d.load(ctx); err != nil {
+			d.discard()
+			return goki.ErrWrap(goki.ErrDBSave, err)
+		}
+	}
+}
+
+// Close does nothing as every change has been saved.
+func (d *GCSActivityDB) Close() error {
 	return nil
 }
 
-// Add adds an activity.
-// This method DOES NOT validate Activity.UserID in the given activity.
-// In this UserDB implementation, if an activity in DB has same timestamp with the given activity, then store the given one with timestamp++.
-// Always returns nil
-func (d *GCSActivityDB) Add(act *model.Activity) error {
-	// init
-	if chk, ok := d.db[act.UserID]; !ok || chk == nil {
-		d.db[act.UserID] = map[int64]*model.Activity{}
-	}
-	ut := act.TimeUTC.Unix()
+// Get gets an activity of the user or error.
+func (d *GCSActivityDB) Get(ctx context.Context, userID, activityID string) (*model.Activity, error) {
 	d.mu.Lock()
-	for {
-		_, ok := d.db[act.UserID][ut]
-		if ok {
-			ut++
-			continue
-		}
-		a := model.NewActivity(act.UserID, time.Unix(ut, 0).In(time.UTC), act.G.S, act.G.M, act.G.L)
-		d.db[act.UserID][ut] = a
-		break
+	defer d.mu.Unlock()
+	if err := d.refresh(ctx); err != nil {
+		return nil, err
 	}
-	d.mu.Unlock()
-	if err := d.save(); err != nil {
-		return goki.ErrWrap(goki.ErrDBSave, err)
+	return d.get(userID, activityID)
+}
+
+// Add adds an activity. A new ID is assigned if Activity.ID is empty.
+// This method DOES NOT validate Activity.UserID in the given activity.
+// In this ActivityDB implementation, if an activity in DB has same timestamp with the given activity, then store the given one with timestamp++.
+func (d *GCSActivityDB) Add(ctx context.Context, act *model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	act = withIDs(act)[0] // the same ID on retries
+	return d.change(ctx, func() error {
+		return d.add(act)
+	})
+}
+
+// DeleteByUser deletes all activities of the user with one save.
+func (d *GCSActivityDB) DeleteByUser(ctx context.Context, userID string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var n int
+	if err := d.change(ctx, func() error {
+		n = d.deleteByUser(userID)
+		return nil
+	}); err != nil {
+		return 0, err
 	}
-	return nil
+	return n, nil
+}
+
+// Import adds activities with one save, keeping their timestamps.
+// Returns goki.ErrActivityAlreadyExist and adds nothing if an ID or a timestamp of the user is already used.
+func (d *GCSActivityDB) Import(ctx context.Context, acts []*model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	acts = withIDs(acts...) // the same IDs on retries
+	return d.change(ctx, func() error {
+		return d.importActivities(acts)
+	})
+}
+
+// Update updates Activity.G of the activity specified by Activity.UserID and Activity.ID.
+func (d *GCSActivityDB) Update(ctx context.Context, act *model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.change(ctx, func() error {
+		return d.update(act)
+	})
+}
+
+// Delete deletes an activity of the user.
+func (d *GCSActivityDB) Delete(ctx context.Context, userID, activityID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.change(ctx, func() error {
+		return d.delete(userID, activityID)
+	})
 }
 
 // Query returns a slice of Activity (may be empty).
 // This method DOES NOT validate Activity.UserID in the given activity.
 // Just returns an empty slice when the given userID is invalid.
-// Always returns nil
-func (d *GCSActivityDB) Query(userID string, queryFn func(a *model.Activity) bool) ([]*model.Activity, error) {
-	var ret []*model.Activity
+// Returns an error only if the data has a change failed to be saved and could not be reloaded.
+func (d *GCSActivityDB) Query(ctx context.Context, q ActivityQuery) ([]*model.Activity, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	al, ok := d.db[userID]
-	if !ok {
-		return ret, nil
-	}
-	for _, v := range al {
-		if queryFn(v) {
-			var act model.Activity
-			deepCopy(&act, v)
-			ret = append(ret, &act)
-		}
+	if err := d.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return d.query(q), nil
+}
+
+// Sum sums roaches of the user in [begin, end) using the monthly rollup.
+func (d *GCSActivityDB) Sum(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.refresh(ctx); err != nil {
+		return nil, err
+	}
+	g, _ := d.sum(userID, begin, end)
+	return &g, nil
+}
+
+// SumByUser sums roaches of each user in [begin, end).
+func (d *GCSActivityDB) SumByUser(ctx context.Context, begin, end time.Time) (map[string]*model.Goki, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return d.sumByUser(begin, end), nil
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return ret, nil
 }
@@ -0,0 +1,378 @@
+package db_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/dbtest"
+	"github.com/ebiiim/goki/model"
+)
+
+const testBucket = "goki-test"
+
+// fakeGCS is a GCS server with the part of the JSON API used by the storage client:
+// media downloads, object metadata and multipart uploads with generation preconditions.
+type fakeGCS struct {
+	mu        sync.Mutex
+	objects   map[string]*fakeObject // bucket/name
+	gen       int64
+	conflicts int // uploads failed by preconditions
+	failures  int // the number of next uploads failing with 500, retried by the client until the deadline
+}
+
+type fakeObject struct {
+	data []byte
+	gen  int64
+}
+
+// newFakeGCS starts a fakeGCS and returns a client of it.
+func newFakeGCS(t *testing.T) (*fakeGCS, *storage.Client) {
+	t.Helper()
+	f := &fakeGCS{objects: map[string]*fakeObject{}}
+	ts := httptest.NewTLSServer(f)
+	t.Cleanup(ts.Close)
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL+"/storage/v1/"), option.WithHTTPClient(ts.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, client
+}
+
+func (f *fakeGCS) put(name string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.gen++
+	f.objects[testBucket+"/"+name] = &fakeObject{data: data, gen: f.gen}
+}
+
+func (f *fakeGCS) failUploads(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = n
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/storage/v1/b/"):
+		f.upload(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/upload/storage/v1/b/"), "/o"))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/"):
+		// /storage/v1/b/{bucket}/o/{name}
+		p := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"), "/o/", 2)
+		if len(p) != 2 {
+			http.Error(w, "bad path", http.StatusBadRequest)
+			return
+		}
+		o, ok := f.objects[p[0]+"/"+p[1]]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		f.writeMeta(w, p[0], p[1], o)
+	case r.Method == http.MethodGet:
+		// /{bucket}/{name}
+		o, ok := f.objects[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Goog-Generation", strconv.FormatInt(o.gen, 10))
+		w.Header().Set("X-Goog-Metageneration", "1")
+		w.Write(o.data)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeGCS) upload(w http.ResponseWriter, r *http.Request, bucket string) {
+	if r.URL.Query().Get("uploadType") != "multipart" {
+		http.Error(w, "only multipart uploads are implemented", http.StatusNotImplemented)
+		return
+	}
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var meta struct {
+		Name string `json:"name"`
+	}
+	part, err := mr.NextPart()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := json.NewDecoder(part).Decode(&meta); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	part, err = mr.NextPart()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(part)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if f.failures > 0 {
+		f.failures--
+		http.Error(w, "backend error", http.StatusInternalServerError)
+		return
+	}
+	key := bucket + "/" + meta.Name
+	if v := r.URL.Query().Get("ifGenerationMatch"); v != "" {
+		var cur int64 // 0 means the object does not exist
+		if o, ok := f.objects[key]; ok {
+			cur = o.gen
+		}
+		if want, _ := strconv.ParseInt(v, 10, 64); want != cur {
+			f.conflicts++
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+	}
+	f.gen++
+	o := &fakeObject{data: data, gen: f.gen}
+	f.objects[key] = o
+	f.writeMeta(w, bucket, meta.Name, o)
+}
+
+func (f *fakeGCS) writeMeta(w http.ResponseWriter, bucket, name string, o *fakeObject) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"kind":           "storage#object",
+		"bucket":         bucket,
+		"name":           name,
+		"generation":     strconv.FormatInt(o.gen, 10),
+		"metageneration": "1",
+		"size":           strconv.Itoa(len(o.data)),
+	})
+}
+
+func openGCSUserDB(t *testing.T, client *storage.Client) *db.GCSUserDB {
+	t.Helper()
+	d, err := db.NewGCSUserDBWithClient(client, testBucket, "users.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func openGCSActivityDB(t *testing.T, client *storage.Client) *db.GCSActivityDB {
+	t.Helper()
+	d, err := db.NewGCSActivityDBWithClient(client, testBucket, "activities.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestGCSUserDB(t *testing.T) {
+	dbtest.TestUserDB(t, func(t *testing.T) db.UserDB {
+		f, client := newFakeGCS(t)
+		f.put("users.json", []byte("{}"))
+		return openGCSUserDB(t, client)
+	})
+}
+
+func TestGCSActivityDB(t *testing.T) {
+	dbtest.TestActivityDB(t, func(t *testing.T) db.ActivityDB {
+		f, client := newFakeGCS(t)
+		f.put("activities.json", []byte("{}"))
+		return openGCSActivityDB(t, client)
+	})
+}
+
+func TestNewGCSUserDB_NotFound(t *testing.T) {
+	_, client := newFakeGCS(t)
+	if _, err := db.NewGCSUserDBWithClient(client, testBucket, "users.json"); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestGCSUserDB_Conflict(t *testing.T) {
+	f, client := newFakeGCS(t)
+	f.put("users.json", []byte("{}"))
+	d1 := openGCSUserDB(t, client)
+	d2 := openGCSUserDB(t, client)
+	d2.RefreshInterval = time.Hour // not to find changes of d1 before saving
+
+	if err := d1.Add(ctx, U1); err != nil {
+		t.Fatal(err)
+	}
+	// applied again to the data reloaded on the conflict
+	if err := d2.Add(ctx, U2); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2.Add(ctx, U1); !errors.Is(err, goki.ErrUserAlreadyExist) {
+		t.Errorf("want ErrUserAlreadyExist but got %v", err)
+	}
+	if f.conflicts != 1 {
+		t.Errorf("conflicts got %d want 1", f.conflicts)
+	}
+
+	// reads find changes of other instances
+	d1.RefreshInterval = 0
+	us, err := d1.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(us) != 2 {
+		t.Errorf("got %d users want 2", len(us))
+	}
+	d3 := openGCSUserDB(t, client)
+	if us, _ := d3.List(ctx); len(us) != 2 {
+		t.Errorf("saved %d users want 2", len(us))
+	}
+}
+
+func TestGCSActivityDB_Concurrent(t *testing.T) {
+	f, client := newFakeGCS(t)
+	f.put("activities.json", []byte("{}"))
+	const instances, n = 3, 5
+	ds := make([]*db.GCSActivityDB, instances)
+	for i := range ds {
+		ds[i] = openGCSActivityDB(t, client)
+		ds[i].RefreshInterval = time.Hour
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, instances*n)
+	for i, d := range ds {
+		wg.Add(1)
+		go func(i int, d *db.GCSActivityDB) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				act := model.NewActivity(U1.ID, A1t.Add(time.Duration(i*n+j)*time.Minute), 1, 0, 0)
+				act.ID = fmt.Sprintf("%d-%d", i, j)
+				if err := d.Add(ctx, act); err != nil {
+					errs <- err
+				}
+			}
+		}(i, d)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	acts, err := openGCSActivityDB(t, client).Query(ctx, db.ActivityQuery{UserID: U1.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(acts) != instances*n {
+		t.Errorf("got %d activities want %d", len(acts), instances*n)
+	}
+	if f.conflicts == 0 {
+		t.Error("no conflicts")
+	}
+}
+
+func TestGCSActivityDB_LegacyIDs(t *testing.T) {
+	f, client := newFakeGCS(t)
+	// activities without IDs stored by older versions
+	f.put("activities.json", []byte(`{"123":{"1596363009":{"UserID":"123","TimeUTC":"2020-08-02T10:10:09Z","G":{"S":3,"M":0,"L":0}},`+
+		`"1596363010":{"UserID":"123","TimeUTC":"2020-08-02T10:10:10Z","G":{"S":3,"M":3,"L":0}}}}`))
+	d1 := openGCSActivityDB(t, client)
+	d2 := openGCSActivityDB(t, client)
+	a1, _ := d1.Query(ctx, db.ActivityQuery{UserID: U1.ID})
+	a2, _ := d2.Query(ctx, db.ActivityQuery{UserID: U1.ID})
+	if len(a1) == 0 || len(a1) != len(a2) {
+		t.Fatalf("got %d and %d activities", len(a1), len(a2))
+	}
+	for i := range a1 {
+		if a1[i].ID != a2[i].ID {
+			t.Errorf("%d: IDs differ %s %s", i, a1[i].ID, a2[i].ID)
+		}
+	}
+}
+
+func TestGCSUserDB_WriteError(t *testing.T) {
+	f, client := newFakeGCS(t)
+	f.put("users.json", []byte("{}"))
+	d := openGCSUserDB(t, client)
+	f.failUploads(math.MaxInt32)
+	short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := d.Add(short, U1); err == nil {
+		t.Fatal("no error")
+	}
+	f.failUploads(0)
+	// the failed change is not visible
+	if _, err := d.Get(ctx, U1.ID); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Errorf("want ErrUserNotFound but got %v", err)
+	}
+	// nor saved by the next change
+	if err := d.Add(ctx, U2); err != nil {
+		t.Fatal(err)
+	}
+	if us, _ := openGCSUserDB(t, client).List(ctx); len(us) != 1 || us[0].ID != U2.ID {
+		t.Errorf("saved %d users want only %s", len(us), U2.ID)
+	}
+}
+
+func TestGCSActivityDB_WriteError(t *testing.T) {
+	f, client := newFakeGCS(t)
+	f.put("activities.json", []byte("{}"))
+	d := openGCSActivityDB(t, client)
+	f.failUploads(math.MaxInt32)
+	short, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	if err := d.Add(short, A1); err == nil {
+		t.Fatal("no error")
+	}
+	f.failUploads(0)
+	if acts, _ := d.Query(ctx, db.ActivityQuery{UserID: A1.UserID}); len(acts) != 0 {
+		t.Errorf("got %d activities want 0", len(acts))
+	}
+	if g, _ := d.Sum(ctx, A1.UserID, time.Time{}, time.Time{}); *g != *model.NewGoki(0, 0, 0) {
+		t.Errorf("got sum %+v want 0", g)
+	}
+	if err := d.Add(ctx, A2); err != nil {
+		t.Fatal(err)
+	}
+	if acts, _ := openGCSActivityDB(t, client).Query(ctx, db.ActivityQuery{UserID: A1.UserID}); len(acts) != 1 {
+		t.Errorf("saved %d activities want 1", len(acts))
+	}
+}
+
+func TestGCSActivityDB_Canceled(t *testing.T) {
+	f, client := newFakeGCS(t)
+	f.put("activities.json", []byte("{}"))
+	d := openGCSActivityDB(t, client)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := d.Add(canceled, A1); !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled but got %v", err)
+	}
+	if acts, _ := d.Query(ctx, db.ActivityQuery{UserID: A1.UserID}); len(acts) != 0 {
+		t.Errorf("got %d activities want 0", len(acts))
+	}
+	if acts, _ := openGCSActivityDB(t, client).Query(ctx, db.ActivityQuery{UserID: A1.UserID}); len(acts) != 0 {
+		t.Errorf("saved %d activities want 0", len(acts))
+	}
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ebiiim/goki/model"
+)
+
+// timeIndex holds sorted time.Unix keys of activities per user
+// so that in-memory ActivityDB implementations can answer ActivityQuery without a full scan.
+type timeIndex map[string][]int64
+
+// newTimeIndex builds a timeIndex from UserID -> time.Unix -> Activity.
+func newTimeIndex(db map[string]map[int64]*model.Activity) timeIndex {
+	idx := timeIndex{}
+	for userID, al := range db {
+		keys := make([]int64, 0, len(al))
+		for k := range al {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		idx[userID] = keys
+	}
+	return idx
+}
+
+// insert adds a key of the user keeping the order.
+func (idx timeIndex) insert(userID string, key int64) {
+	keys := idx[userID]
+	i := sort.Search(len(keys), func(i int) bool { return keys[i] >= key })
+	keys = append(keys, 0)
+	copy(keys[i+1:], keys[i:])
+	keys[i] = key
+	idx[userID] = keys
+}
+
+// remove deletes a key of the user.
+func (idx timeIndex) remove(userID string, key int64) {
+	keys := idx[userID]
+	i := sort.Search(len(keys), func(i int) bool { return keys[i] >= key })
+	if i < len(keys) && keys[i] == key {
+		idx[userID] = append(keys[:i], keys[i+1:]...)
+	}
+}
+
+// between returns keys of the user in [begin, end) keeping the order.
+// The zero value of begin or end means unbounded.
+func (idx timeIndex) between(userID string, begin, end time.Time) []int64 {
+	b, e := unixRange(begin, end)
+	return idx.keysIn(userID, b, e)
+}
+
+// keysIn returns keys of the user in [b, e) keeping the order.
+func (idx timeIndex) keysIn(userID string, b, e int64) []int64 {
+	keys := idx[userID]
+	lo := sort.Search(len(keys), func(i int) bool { return keys[i] >= b })
+	hi := sort.Search(len(keys), func(i int) bool { return keys[i] >= e })
+	if lo >= hi {
+		return nil
+	}
+	return keys[lo:hi]
+}
+
+// idIndex maps UserID -> Activity.ID -> time.Unix.
+type idIndex map[string]map[string]int64
+
+// newIDIndex builds an idIndex from UserID -> time.Unix -> Activity.
+func newIDIndex(db map[string]map[int64]*model.Activity) idIndex {
+	idx := idIndex{}
+	for userID, al := range db {
+		for k, a := range al {
+			idx.set(userID, a.ID, k)
+		}
+	}
+	return idx
+}
+
+func (idx idIndex) get(userID, activityID string) (int64, bool) {
+	k, ok := idx[userID][activityID]
+	return k, ok
+}
+
+func (idx idIndex) set(userID, activityID string, key int64) {
+	if idx[userID] == nil {
+		idx[userID] = map[string]int64{}
+	}
+	idx[userID][activityID] = key
+}
+
+func (idx idIndex) remove(userID, activityID string) {
+	delete(idx[userID], activityID)
+}
+
+// ceilUnix returns the smallest time.Unix value not before t.
+func ceilUnix(t time.Time) int64 {
+	u := t.Unix()
+	if t.Nanosecond() > 0 {
+		u++
+	}
+	return u
+}
+
+// identityIndex maps provider -> subject -> UserID
+// so that in-memory UserDB implementations can find the owner of an identity without a full scan.
+type identityIndex map[string]map[string]string
+
+// newIdentityIndex builds an identityIndex from UserID -> User.
+func newIdentityIndex(db map[string]*model.User) identityIndex {
+	idx := identityIndex{}
+	for _, u := range db {
+		idx.add(u)
+	}
+	return idx
+}
+
+func (idx identityIndex) get(provider, subject string) (string, bool) {
+	userID, ok := idx[provider][subject]
+	return userID, ok
+}
+
+// add adds the identities of the user.
+func (idx identityIndex) add(u *model.User) {
+	for _, id := range u.Identities {
+		if id.Subject == "" {
+			continue
+		}
+		if idx[id.Provider] == nil {
+			idx[id.Provider] = map[string]string{}
+		}
+		idx[id.Provider][id.Subject] = u.ID
+	}
+}
+
+// remove deletes the identities of the user.
+func (idx identityIndex) remove(u *model.User) {
+	for _, id := range u.Identities {
+		if idx[id.Provider][id.Subject] == u.ID {
+			delete(idx[id.Provider], id.Subject)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// DefaultJournalCompactEvery is the default number of journal entries which triggers compaction.
+const DefaultJournalCompactEvery = 1000
+
+// Operations of journal entries.
+const (
+	journalAdd          = "add"
+	journalUpdate       = "update"
+	journalDelete       = "delete"
+	journalDeleteByUser = "delete_user"
+)
+
+// journalEntry is a change of activities, written as a line of the journal file.
+type journalEntry struct {
+	Op string `json:"op"`
+	// Activities are added activities as stored, or an updated activity.
+	Activities []*model.Activity `json:"activities,omitempty"`
+	// UserID and ActivityID are of deleted activities.
+	UserID     string `json:"user_id,omitempty"`
+	ActivityID string `json:"activity_id,omitempty"`
+}
+
+// journal is an append-only file of journalEntry as JSON lines.
+// Every entry is synced before the change returns, so it is as crash-safe as saving the whole file.
+type journal struct {
+	path string
+	f    *os.File
+	size int64 // bytes of complete entries
+	n    int   // entries
+}
+
+// append writes the entry and syncs it. A partially written entry is removed on error.
+func (j *journal) append(e *journalEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if j.f == nil {
+		f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, jsonFileMode)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		j.f, j.size = f, fi.Size()
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		j.f.Truncate(j.size)
+		return err
+	}
+	if err := j.f.Sync(); err != nil {
+		j.f.Truncate(j.size)
+		return err
+	}
+	j.size += int64(len(b)) + 1
+	j.n++
+	return nil
+}
+
+// truncate removes all entries. Call it after saving them to the JSON file.
+func (j *journal) truncate() error {
+	if err := j.close(); err != nil {
+		return err
+	}
+	j.size, j.n = 0, 0
+	if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (j *journal) close() error {
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// readJournal reads all entries of the journal file, or nothing if the file does not exist.
+// The last line without a newline is ignored as the write was not completed.
+func readJournal(path string) ([]*journalEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := bytes.Split(b, []byte("\n"))
+	lines = lines[:len(lines)-1] // empty, or the incomplete line
+	es := make([]*journalEntry, 0, len(lines))
+	for i, line := range lines {
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", path, i+1, err)
+		}
+		es = append(es, &e)
+	}
+	return es, nil
+}
+
+// replay applies the entry.
+// Entries may be applied again to data which already contains them, e.g. after a crash between
+// saving the JSON file and truncating the journal, so added activities with existing IDs are skipped
+// and missing activities are ignored. The result is the same as the state after the last entry.
+func (d *activityMap) replay(e *journalEntry) error {
+	switch e.Op {
+	case journalAdd:
+		for _, act := range e.Activities {
+			if _, ok := d.ids.get(act.UserID, act.ID); ok {
+				continue
+			}
+			if err := d.add(act); err != nil {
+				return err
+			}
+		}
+	case journalUpdate:
+		for _, act := range e.Activities {
+			if err := d.update(act); err != nil && !errors.Is(err, goki.ErrActivityNotFound) {
+				return err
+			}
+		}
+	case journalDelete:
+		if err := d.delete(e.UserID, e.ActivityID); err != nil && !errors.Is(err, goki.ErrActivityNotFound) {
+			return err
+		}
+	case journalDeleteByUser:
+		d.deleteByUser(e.UserID)
+	default:
+		return fmt.Errorf("unknown journal operation %q", e.Op)
+	}
+	return nil
+}
+
+// stored returns the stored activities by the IDs of acts, to journal them with bumped timestamps.
+func (d *activityMap) stored(acts ...*model.Activity) []*model.Activity {
+	ret := make([]*model.Activity, 0, len(acts))
+	for _, act := range acts {
+		if k, ok := d.ids.get(act.UserID, act.ID); ok {
+			ret = append(ret, d.db[act.UserID][k])
+		}
+	}
+	return ret
+}
+
+// withIDs returns copies of the activities with new IDs assigned to ones without ID,
+// so that they can be found after they are added.
+func withIDs(acts ...*model.Activity) []*model.Activity {
+	ret := make([]*model.Activity, len(acts))
+	for i, act := range acts {
+		a := *act
+		if a.ID == "" {
+			a.ID = goki.NewID()
+		}
+		ret[i] = &a
+	}
+	return ret
+}
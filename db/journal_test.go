@@ -0,0 +1,182 @@
+package db_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/dbtest"
+	"github.com/ebiiim/goki/model"
+)
+
+// openJournaled opens a journaled copy of testdata/JSONActivityDB_Query.json, or an empty one, without compaction.
+func openJournaled(t *testing.T, empty bool) (*db.JSONActivityDB, string) {
+	t.Helper()
+	testDBPath := filepath.Join(t.TempDir(), "JSONActivityDB_Journal.json")
+	if !empty {
+		copyFile(t, filepath.Join(testdataDir, "JSONActivityDB_Query.json"), testDBPath)
+	}
+	d, err := db.NewJournaledJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.CompactEvery = 0
+	return d, testDBPath
+}
+
+func allActivities(t *testing.T, d db.ActivityDB) map[string][]*model.Activity {
+	t.Helper()
+	ret := map[string][]*model.Activity{}
+	for _, u := range []string{U1.ID, U2.ID} {
+		acts, err := d.Query(ctx, db.ActivityQuery{UserID: u})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret[u] = acts
+	}
+	return ret
+}
+
+func TestJournaledJSONActivityDB_Replay(t *testing.T) {
+	cases := []struct {
+		name  string
+		empty bool
+		fn    func(t *testing.T, d db.ActivityDB)
+	}{
+		{"CRUD", true, dbtest.ActivityCRUD},
+		{"Import", false, dbtest.ActivityImport},
+		{"DeleteByUser", false, dbtest.ActivityDeleteByUser},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, testDBPath := openJournaled(t, c.empty)
+			snapshot, err := ioutil.ReadFile(testDBPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c.fn(t, d)
+			want := allActivities(t, d)
+
+			// the file is not rewritten
+			b, err := ioutil.ReadFile(testDBPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, snapshot) {
+				t.Error("the file is rewritten")
+			}
+			journal, err := ioutil.ReadFile(testDBPath + ".journal")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// crash without Close
+			d2, err := db.NewJSONActivityDB(testDBPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := allActivities(t, d2); !reflect.DeepEqual(got, want) {
+				t.Errorf("replay: got %+v want %+v", got, want)
+			}
+			if _, err := os.Stat(testDBPath + ".journal"); !os.IsNotExist(err) {
+				t.Errorf("the journal is not compacted: %v", err)
+			}
+
+			// crash after saving the file and before removing the journal
+			if err := ioutil.WriteFile(testDBPath+".journal", journal, 0600); err != nil {
+				t.Fatal(err)
+			}
+			d3, err := db.NewJSONActivityDB(testDBPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := allActivities(t, d3); !reflect.DeepEqual(got, want) {
+				t.Errorf("replay twice: got %+v want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestJournaledJSONActivityDB_Compact(t *testing.T) {
+	d, testDBPath := openJournaled(t, false)
+	d.CompactEvery = 2
+	if err := d.Add(ctx, model.NewActivity(U1.ID, A2t, 1, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(testDBPath + ".journal"); err != nil {
+		t.Errorf("no journal: %v", err)
+	}
+	// the same time is bumped
+	if err := d.Add(ctx, model.NewActivity(U1.ID, A2t, 2, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(testDBPath + ".journal"); !os.IsNotExist(err) {
+		t.Errorf("the journal is not compacted: %v", err)
+	}
+	want := allActivities(t, d)
+	d2, err := db.NewJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := allActivities(t, d2); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+
+	// Close compacts
+	if err := d.Add(ctx, model.NewActivity(U2.ID, A2t, 3, 0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(testDBPath + ".journal"); !os.IsNotExist(err) {
+		t.Errorf("the journal is not compacted: %v", err)
+	}
+}
+
+func TestJournaledJSONActivityDB_IncompleteEntry(t *testing.T) {
+	d, testDBPath := openJournaled(t, false)
+	act := model.NewActivity(U1.ID, UTC202109Begin, 1, 2, 3)
+	act.ID = "given-id"
+	if err := d.Add(ctx, act); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(testDBPath+".journal", os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// crash while writing
+	if _, err := f.WriteString(`{"op":"add","activities":[{"id":"x"`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	d2, err := db.NewJournaledJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d2.Get(ctx, U1.ID, act.ID); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJournaledJSONActivityDB_BrokenEntry(t *testing.T) {
+	testDBPath := filepath.Join(t.TempDir(), "JSONActivityDB_Journal.json")
+	if err := ioutil.WriteFile(testDBPath+".journal", []byte("{\n{\"op\":\"delete_user\",\"user_id\":\"123\"}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewJournaledJSONActivityDB(testDBPath); err == nil {
+		t.Error("no error")
+	}
+}
+
+func TestJournaledJSONActivityDB_Conformance(t *testing.T) {
+	dbtest.TestActivityDB(t, func(t *testing.T) db.ActivityDB {
+		d, _ := openJournaled(t, true)
+		return d
+	})
+}
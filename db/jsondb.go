@@ -1,12 +1,11 @@
 package db
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/ebiiim/goki"
@@ -17,8 +16,12 @@ import (
 // Cannot be read from multiple app instances.
 type JSONUserDB struct {
 	filePath string
-	db       map[string]*model.User
-	mu       sync.Mutex
+	// Backups is the number of backups rotated on every save, 0 disables them. (default DefaultJSONBackups)
+	Backups int
+	// recovered is the *RecoveredError if the file was restored from a backup on open.
+	recovered error
+	f         jsonFile
+	userMap
 }
 
 var _ UserDB = (*JSONUserDB)(nil)
@@ -27,7 +30,9 @@ var _ UserDB = (*JSONUserDB)(nil)
 func NewJSONUserDB(filePath string) (*JSONUserDB, error) {
 	d := &JSONUserDB{
 		filePath: filePath,
-		db:       map[string]*model.User{},
+		Backups:  DefaultJSONBackups,
+		userMap:  newUserMap(),
+		f:        jsonFile{path: filePath},
 	}
 	if isFile(d.filePath) {
 		if err := d.load(); err != nil {
@@ -41,33 +46,44 @@ func NewJSONUserDB(filePath string) (*JSONUserDB, error) {
 	return d, nil
 }
 
+// load loads the JSON file, or the newest valid backup if the file is broken.
 func (d *JSONUserDB) load() error {
-	// Load JSON.
-	f, err := ioutil.ReadFile(d.filePath)
-	if err != nil {
-		return goki.ErrWrap(goki.ErrDBOpen, err)
-	}
 	var db map[string]*model.User
-	if err := json.Unmarshal(f, &db); err != nil {
+	recovered, err := d.file().read(func(b []byte) error {
+		db = nil
+		return json.Unmarshal(b, &db)
+	})
+	if err != nil {
 		return goki.ErrWrap(goki.ErrDBOpen, err)
 	}
-	d.db = db
+	d.recovered = recovered
+	d.reset(db)
 	return nil
 }
 
+// Recovered returns the *RecoveredError if the file was broken and restored from a backup on open, or nil.
+func (d *JSONUserDB) Recovered() error {
+	return d.recovered
+}
+
+// save replaces the JSON file atomically and rotates backups.
 func (d *JSONUserDB) save() error {
 	b, err := json.Marshal(d.db)
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(d.filePath, b, os.ModePerm); err != nil {
-		return err
-	}
-	return nil
+	return d.file().write(b)
+}
+
+func (d *JSONUserDB) file() *jsonFile {
+	d.f.backups = d.Backups
+	return &d.f
 }
 
 // Close saves data to the database JSON file.
 func (d *JSONUserDB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	if err := d.save(); err != nil {
 		return goki.ErrWrap(goki.ErrDBClose, err)
 	}
@@ -75,145 +91,289 @@ func (d *JSONUserDB) Close() error {
 }
 
 // Get gets an user or error.
-func (d *JSONUserDB) Get(userID string) (*model.User, error) {
+func (d *JSONUserDB) Get(ctx context.Context, userID string) (*model.User, error) {
 	d.mu.Lock()
-	u, ok := d.db[userID]
-	d.mu.Unlock()
-	if !ok {
-		return nil, goki.ErrUserNotFound
-	}
-	var uu model.User
-	deepCopy(&uu, u)
-	return &uu, nil
+	defer d.mu.Unlock()
+	return d.get(userID)
+}
+
+// GetByIdentity gets an user by an account of an identity provider or error.
+func (d *JSONUserDB) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.getByIdentity(provider, subject)
 }
 
 // GetByTwitterID gets an user by Twitter ID or error.
-func (d *JSONUserDB) GetByTwitterID(twitterID string) (*model.User, error) {
-	var uu model.User
+func (d *JSONUserDB) GetByTwitterID(ctx context.Context, twitterID string) (*model.User, error) {
+	return d.GetByIdentity(ctx, model.ProviderTwitter, twitterID)
+}
+
+// Add adds an user.
+func (d *JSONUserDB) Add(ctx context.Context, user *model.User) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	for _, u := range d.db {
-		if u.Twitter.ID == twitterID {
-			deepCopy(&uu, u)
-			return &uu, nil
-		}
+	if err := d.add(user); err != nil {
+		return err
+	}
+	if err := d.save(); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
 	}
-	return nil, goki.ErrUserNotFound
+	return nil
 }
 
-// Add adds an user.
-func (d *JSONUserDB) Add(user *model.User) error {
-	if _, err := d.Get(user.ID); err == nil {
-		return goki.ErrUserAlreadyExist
+// Update replaces an user.
+func (d *JSONUserDB) Update(ctx context.Context, user *model.User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.update(user); err != nil {
+		return err
 	}
-	if _, err := d.GetByTwitterID(user.Twitter.ID); err == nil {
-		return goki.ErrUserAlreadyExist
+	if err := d.save(); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
 	}
-	u := model.NewUser(user.ID, user.Name, user.Twitter.ID)
+	return nil
+}
+
+// Delete deletes an user.
+func (d *JSONUserDB) Delete(ctx context.Context, userID string) error {
 	d.mu.Lock()
-	d.db[user.ID] = u
-	d.mu.Unlock()
+	defer d.mu.Unlock()
+	if err := d.delete(userID); err != nil {
+		return err
+	}
 	if err := d.save(); err != nil {
 		return goki.ErrWrap(goki.ErrDBSave, err)
 	}
 	return nil
 }
 
+// List returns all users ordered by ID.
+func (d *JSONUserDB) List(ctx context.Context) ([]*model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.list(), nil
+}
+
 // JSONActivityDB is an easy ActivityDB stores data in a JSON file.
 // Cannot be read from multiple app instances.
+//
+// Journaled databases opened by NewJournaledJSONActivityDB append each change to {file}.journal
+// instead of rewriting the whole file, and compact the journal into the file every CompactEvery changes.
+// Journals are replayed and compacted on opening in both modes.
 type JSONActivityDB struct {
 	filePath string
-	// UserID -> time.Unix -> Activity
-	db map[string]map[int64]*model.Activity
-	mu sync.Mutex
+	// Backups is the number of backups rotated on every save, 0 disables them. (default DefaultJSONBackups)
+	Backups int
+	// recovered is the *RecoveredError if the file was restored from a backup on open.
+	recovered error
+	f         jsonFile
+	// CompactEvery is the number of journal entries which triggers compaction, 0 compacts only on opening and closing.
+	// (default DefaultJournalCompactEvery)
+	CompactEvery int
+	journal      *journal // nil unless journaled
+	activityMap
 }
 
 var _ ActivityDB = (*JSONActivityDB)(nil)
 
 // NewJSONActivityDB initializes a JSONActivityDB
 func NewJSONActivityDB(filePath string) (*JSONActivityDB, error) {
+	return openJSONActivityDB(filePath, false)
+}
+
+// NewJournaledJSONActivityDB initializes a journaled JSONActivityDB.
+func NewJournaledJSONActivityDB(filePath string) (*JSONActivityDB, error) {
+	return openJSONActivityDB(filePath, true)
+}
+
+func openJSONActivityDB(filePath string, journaled bool) (*JSONActivityDB, error) {
 	d := &JSONActivityDB{
-		filePath: filePath,
-		db:       map[string]map[int64]*model.Activity{},
+		filePath:     filePath,
+		Backups:      DefaultJSONBackups,
+		CompactEvery: DefaultJournalCompactEvery,
+		activityMap:  newActivityMap(),
+		f:            jsonFile{path: filePath},
+	}
+	if journaled {
+		d.journal = &journal{path: d.journalPath()}
 	}
 	if isFile(d.filePath) {
 		if err := d.load(); err != nil {
 			return nil, goki.ErrWrap(goki.ErrDBOpen, err)
 		}
-	} else {
-		if err := d.save(); err != nil {
-			return nil, goki.ErrWrap(goki.ErrDBOpen, err)
-		}
+	}
+	// also creates the file
+	if err := d.replay(); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBOpen, err)
 	}
 	return d, nil
 }
 
+// load loads the JSON file, or the newest valid backup if the file is broken.
 func (d *JSONActivityDB) load() error {
-	// If filePath does not exist then create a new file.
-	if _, err := os.Stat(d.filePath); err != nil {
-		f, err := os.Create(d.filePath)
-		if err != nil {
-			return err
-		}
-		if err := f.Close(); err != nil {
-			return err
-		}
-	}
-	// Load JSON.
-	f, err := ioutil.ReadFile(d.filePath)
+	var db map[string]map[int64]*model.Activity
+	recovered, err := d.file().read(func(b []byte) error {
+		db = nil
+		return json.Unmarshal(b, &db)
+	})
 	if err != nil {
 		return err
 	}
-	var db map[string]map[int64]*model.Activity
-	if err := json.Unmarshal(f, &db); err != nil {
+	d.recovered = recovered
+	d.reset(db)
+	return nil
+}
+
+// Recovered returns the *RecoveredError if the file was broken and restored from a backup on open, or nil.
+func (d *JSONActivityDB) Recovered() error {
+	return d.recovered
+}
+
+// replay applies the journal left by the last run and compacts it.
+// The file is saved even without the journal to keep IDs given to legacy activities, which journal entries refer to.
+func (d *JSONActivityDB) replay() error {
+	es, err := readJournal(d.journalPath())
+	if err != nil {
 		return err
 	}
-	d.db = db
-	return nil
+	for _, e := range es {
+		if err := d.activityMap.replay(e); err != nil {
+			return err
+		}
+	}
+	return d.compact()
 }
 
+// save replaces the JSON file atomically and rotates backups.
 func (d *JSONActivityDB) save() error {
 	b, err := json.Marshal(d.db)
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(d.filePath, b, os.ModePerm); err != nil {
+	return d.file().write(b)
+}
+
+// compact saves all data to the JSON file and removes the journal.
+func (d *JSONActivityDB) compact() error {
+	if err := d.save(); err != nil {
+		return err
+	}
+	if d.journal != nil {
+		return d.journal.truncate()
+	}
+	if err := os.Remove(d.journalPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// commit persists a change; appends it to the journal if journaled, or saves all data otherwise.
+func (d *JSONActivityDB) commit(e *journalEntry) error {
+	if d.journal == nil {
+		return d.save()
+	}
+	if err := d.journal.append(e); err != nil {
 		return err
 	}
+	if d.CompactEvery > 0 && d.journal.n >= d.CompactEvery {
+		return d.compact()
+	}
 	return nil
 }
 
+func (d *JSONActivityDB) file() *jsonFile {
+	d.f.backups = d.Backups
+	return &d.f
+}
+
+func (d *JSONActivityDB) journalPath() string {
+	return d.filePath + ".journal"
+}
+
 // Close saves data to the database JSON file.
 func (d *JSONActivityDB) Close() error {
-	if err := d.save(); err != nil {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.compact(); err != nil {
 		return goki.ErrWrap(goki.ErrDBClose, err)
 	}
 	return nil
 }
 
-// Add adds an activity.
+// Get gets an activity of the user or error.
+func (d *JSONActivityDB) Get(ctx context.Context, userID, activityID string) (*model.Activity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.get(userID, activityID)
+}
+
+// Add adds an activity. A new ID is assigned if Activity.ID is empty.
 // This method DOES NOT validate Activity.UserID in the given activity.
-// In this UserDB implementation, if an activity in DB has same timestamp with the given activity, then store the given one with timestamp++.
-// Always returns nil
-func (d *JSONActivityDB) Add(act *model.Activity) error {
-	// init
-	if chk, ok := d.db[act.UserID]; !ok || chk == nil {
-		d.db[act.UserID] = map[int64]*model.Activity{}
+// In this ActivityDB implementation, if an activity in DB has same timestamp with the given activity, then store the given one with timestamp++.
+func (d *JSONActivityDB) Add(ctx context.Context, act *model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	acts := withIDs(act)
+	if err := d.add(acts[0]); err != nil {
+		return err
+	}
+	if err := d.commit(&journalEntry{Op: journalAdd, Activities: d.stored(acts...)}); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
 	}
-	ut := act.TimeUTC.Unix()
+	return nil
+}
+
+// DeleteByUser deletes all activities of the user with one save.
+func (d *JSONActivityDB) DeleteByUser(ctx context.Context, userID string) (int, error) {
 	d.mu.Lock()
-	for {
-		_, ok := d.db[act.UserID][ut]
-		if ok {
-			ut++
-			continue
-		}
-		a := model.NewActivity(act.UserID, time.Unix(ut, 0).In(time.UTC), act.G.S, act.G.M, act.G.L)
-		d.db[act.UserID][ut] = a
-		break
+	defer d.mu.Unlock()
+	n := d.deleteByUser(userID)
+	if n == 0 {
+		return 0, nil
 	}
-	d.mu.Unlock()
-	if err := d.save(); err != nil {
+	if err := d.commit(&journalEntry{Op: journalDeleteByUser, UserID: userID}); err != nil {
+		return 0, goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return n, nil
+}
+
+// Import adds activities with one save, keeping their timestamps.
+// Returns goki.ErrActivityAlreadyExist and adds nothing if an ID or a timestamp of the user is already used.
+func (d *JSONActivityDB) Import(ctx context.Context, acts []*model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	acts = withIDs(acts...)
+	if err := d.importActivities(acts); err != nil {
+		return err
+	}
+	if err := d.commit(&journalEntry{Op: journalAdd, Activities: d.stored(acts...)}); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return nil
+}
+
+// Update updates Activity.G of the activity specified by Activity.UserID and Activity.ID.
+func (d *JSONActivityDB) Update(ctx context.Context, act *model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.update(act); err != nil {
+		return err
+	}
+	if err := d.commit(&journalEntry{Op: journalUpdate, Activities: d.stored(act)}); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return nil
+}
+
+// Delete deletes an activity of the user.
+func (d *JSONActivityDB) Delete(ctx context.Context, userID, activityID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.delete(userID, activityID); err != nil {
+		return err
+	}
+	if err := d.commit(&journalEntry{Op: journalDelete, UserID: userID, ActivityID: activityID}); err != nil {
 		return goki.ErrWrap(goki.ErrDBSave, err)
 	}
 	return nil
@@ -223,22 +383,25 @@ func (d *JSONActivityDB) Add(act *model.Activity) error {
 // This method DOES NOT validate Activity.UserID in the given activity.
 // Just returns an empty slice when the given userID is invalid.
 // Always returns nil
-func (d *JSONActivityDB) Query(userID string, queryFn func(a *model.Activity) bool) ([]*model.Activity, error) {
-	var ret []*model.Activity
+func (d *JSONActivityDB) Query(ctx context.Context, q ActivityQuery) ([]*model.Activity, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	al, ok := d.db[userID]
-	if !ok {
-		return ret, nil
-	}
-	for _, v := range al {
-		if queryFn(v) {
-			var act model.Activity
-			deepCopy(&act, v)
-			ret = append(ret, &act)
-		}
-	}
-	return ret, nil
+	return d.query(q), nil
+}
+
+// Sum sums roaches of the user in [begin, end) using the monthly rollup.
+func (d *JSONActivityDB) Sum(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, _ := d.sum(userID, begin, end)
+	return &g, nil
+}
+
+// SumByUser sums roaches of each user in [begin, end).
+func (d *JSONActivityDB) SumByUser(ctx context.Context, begin, end time.Time) (map[string]*model.Goki, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sumByUser(begin, end), nil
 }
 
 func isFile(filePath string) bool {
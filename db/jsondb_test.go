@@ -1,20 +1,28 @@
 package db_test
 
 import (
-	"os"
+	"context"
+	"io/ioutil"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/dbtest"
 	"github.com/ebiiim/goki/model"
 )
 
 const testdataDir = "./testdata"
 
-func removeFile(t *testing.T, f string) {
+var ctx = context.Background()
+
+func copyFile(t *testing.T, src, dst string) {
 	t.Helper()
-	if err := os.Remove(f); err != nil {
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dst, b, 0644); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -40,8 +48,7 @@ var (
 )
 
 func TestNewJSONUserDB_NewFile(t *testing.T) {
-	var testDBPath = "JSONUserDB_NewFile.json"
-	defer removeFile(t, testDBPath)
+	var testDBPath = filepath.Join(t.TempDir(), "JSONUserDB_NewFile.json")
 	d, err := db.NewJSONUserDB(testDBPath)
 	if err != nil {
 		t.Error(err)
@@ -65,7 +72,9 @@ func TestNewJSONUserDB_OpenFile(t *testing.T) {
 }
 
 func TestJSONUserDB_Get(t *testing.T) {
-	var testDBPath = filepath.Join(testdataDir, "JSONUserDB_Get.json")
+	// copy the legacy golden file as Close writes it in the current format
+	var testDBPath = filepath.Join(t.TempDir(), "JSONUserDB_Get.json")
+	copyFile(t, filepath.Join(testdataDir, "JSONUserDB_Get.json"), testDBPath)
 	d, err := db.NewJSONUserDB(testDBPath)
 	if err != nil {
 		t.Error(t)
@@ -88,7 +97,7 @@ func TestJSONUserDB_Get(t *testing.T) {
 	for _, c := range cases {
 		c := c
 		t.Run(c.name, func(t *testing.T) {
-			u, err := c.d.Get(c.userID)
+			u, err := c.d.Get(ctx, c.userID)
 			if c.isErr {
 				if err == nil {
 					t.Error("expected err")
@@ -109,7 +118,9 @@ func TestJSONUserDB_Get(t *testing.T) {
 }
 
 func TestJSONUserDB_GetByTwitterID(t *testing.T) {
-	var testDBPath = filepath.Join(testdataDir, "JSONUserDB_Get.json")
+	// copy the legacy golden file as Close writes it in the current format
+	var testDBPath = filepath.Join(t.TempDir(), "JSONUserDB_Get.json")
+	copyFile(t, filepath.Join(testdataDir, "JSONUserDB_Get.json"), testDBPath)
 	d, err := db.NewJSONUserDB(testDBPath)
 	if err != nil {
 		t.Error(t)
@@ -125,14 +136,14 @@ func TestJSONUserDB_GetByTwitterID(t *testing.T) {
 		userID    string
 		isErr     bool
 	}{
-		{"alice", d, U1.Twitter.ID, U1.ID, false},
-		{"bob", d, U2.Twitter.ID, U2.ID, false},
+		{"alice", d, U1.Subject(model.ProviderTwitter), U1.ID, false},
+		{"bob", d, U2.Subject(model.ProviderTwitter), U2.ID, false},
 		{"F_taro", d, "00000000", "000", true},
 	}
 	for _, c := range cases {
 		c := c
 		t.Run(c.name, func(t *testing.T) {
-			u, err := c.d.GetByTwitterID(c.twitterID)
+			u, err := c.d.GetByTwitterID(ctx, c.twitterID)
 			if c.isErr {
 				if err == nil {
 					t.Error("expected err")
@@ -153,8 +164,7 @@ func TestJSONUserDB_GetByTwitterID(t *testing.T) {
 }
 
 func TestJSONUserDB_Add(t *testing.T) {
-	var testDBPath = "JSONUserDB_Add.json"
-	defer removeFile(t, testDBPath)
+	var testDBPath = filepath.Join(t.TempDir(), "JSONUserDB_Add.json")
 	d, err := db.NewJSONUserDB(testDBPath)
 	if err != nil {
 		t.Error(err)
@@ -179,7 +189,7 @@ func TestJSONUserDB_Add(t *testing.T) {
 	for _, c := range cases {
 		c := c
 		t.Run(c.name, func(t *testing.T) {
-			err := c.d.Add(c.user)
+			err := c.d.Add(ctx, c.user)
 			if c.isErr {
 				if err == nil {
 					t.Error("expected err")
@@ -196,8 +206,7 @@ func TestJSONUserDB_Add(t *testing.T) {
 }
 
 func TestNewJSONActivityDB_NewFile(t *testing.T) {
-	var testDBPath = "JSONActivityDB_NewFile.json"
-	defer removeFile(t, testDBPath)
+	var testDBPath = filepath.Join(t.TempDir(), "JSONActivityDB_NewFile.json")
 	d, err := db.NewJSONActivityDB(testDBPath)
 	if err != nil {
 		t.Error(err)
@@ -221,8 +230,7 @@ func TestNewJSONActivityDB_OpenFile(t *testing.T) {
 }
 
 func TestJSONActivityDB_Add(t *testing.T) {
-	var testDBPath = "JSONActivityDB_Add.json"
-	defer removeFile(t, testDBPath)
+	var testDBPath = filepath.Join(t.TempDir(), "JSONActivityDB_Add.json")
 	d, err := db.NewJSONActivityDB(testDBPath)
 	if err != nil {
 		t.Error(err)
@@ -247,7 +255,7 @@ func TestJSONActivityDB_Add(t *testing.T) {
 	for _, c := range cases {
 		c := c
 		t.Run(c.name, func(t *testing.T) {
-			err := c.d.Add(c.a)
+			err := c.d.Add(ctx, c.a)
 			if c.isErr {
 				if err == nil {
 					t.Error("expected err")
@@ -264,7 +272,9 @@ func TestJSONActivityDB_Add(t *testing.T) {
 }
 
 func TestJSONActivityDB_Query(t *testing.T) {
-	var testDBPath = filepath.Join(testdataDir, "JSONActivityDB_Query.json")
+	// Copy the file since activities without ID are saved with new IDs.
+	var testDBPath = filepath.Join(t.TempDir(), "JSONActivityDB_Query.json")
+	copyFile(t, filepath.Join(testdataDir, "JSONActivityDB_Query.json"), testDBPath)
 	d, err := db.NewJSONActivityDB(testDBPath)
 	if err != nil {
 		t.Error(err)
@@ -293,7 +303,7 @@ func TestJSONActivityDB_Query(t *testing.T) {
 	for _, c := range cases {
 		c := c
 		t.Run(c.name, func(t *testing.T) {
-			res, err := c.d.Query(c.userID, c.queryFn)
+			res, err := db.QueryFunc(ctx, c.d, c.userID, c.queryFn)
 			if c.isErr {
 				if err == nil {
 					t.Error("expected err")
@@ -312,3 +322,90 @@ func TestJSONActivityDB_Query(t *testing.T) {
 		})
 	}
 }
+
+func TestJSONActivityDB_Query_ActivityQuery(t *testing.T) {
+	// Copy the file since activities without ID are saved with new IDs.
+	var testDBPath = filepath.Join(t.TempDir(), "JSONActivityDB_Query.json")
+	copyFile(t, filepath.Join(testdataDir, "JSONActivityDB_Query.json"), testDBPath)
+	d, err := db.NewJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	dbtest.ActivityQuery(t, d)
+}
+
+func TestJSONActivityDB_CRUD(t *testing.T) {
+	var testDBPath = filepath.Join(t.TempDir(), "JSONActivityDB_CRUD.json")
+	d, err := db.NewJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dbtest.ActivityCRUD(t, d)
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+	// reopen
+	d, err = db.NewJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if a, err := d.Get(ctx, U2.ID, "given-id"); err != nil || a.G.L != 1 {
+		t.Errorf("reopen: got %+v %v", a, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJSONUserDB_Update(t *testing.T) {
+	var testDBPath = filepath.Join(t.TempDir(), "JSONUserDB_Update.json")
+	d, err := db.NewJSONUserDB(testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dbtest.UserUpdate(t, d)
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+	// reopen
+	d, err = db.NewJSONUserDB(testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if u, err := d.Get(ctx, U1.ID); err != nil || len(u.Tokens) != 1 {
+		t.Errorf("reopen: got %+v %v", u, err)
+	}
+	if err := d.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJSONUserDB_Conformance(t *testing.T) {
+	dbtest.TestUserDB(t, func(t *testing.T) db.UserDB {
+		d, err := db.NewJSONUserDB(filepath.Join(t.TempDir(), "JSONUserDB.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
+
+func TestJSONActivityDB_Conformance(t *testing.T) {
+	dbtest.TestActivityDB(t, func(t *testing.T) db.ActivityDB {
+		d, err := db.NewJSONActivityDB(filepath.Join(t.TempDir(), "JSONActivityDB.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
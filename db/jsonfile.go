@@ -0,0 +1,161 @@
+package db
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DefaultJSONBackups is the default number of backups of JSON databases.
+const DefaultJSONBackups = 3
+
+// jsonFileMode is the permission of JSON database files, which contain password and token hashes.
+const jsonFileMode os.FileMode = 0600
+
+// jsonFile is a JSON database file replaced atomically on every save.
+// Backups are {path}.1 (the newest) to {path}.{backups}, rotated on every save.
+type jsonFile struct {
+	path    string
+	backups int
+	// sum is the hash of the content last read or written if known.
+	sum      [sha256.Size]byte
+	knownSum bool
+}
+
+func (f *jsonFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// RecoveredError reports that a broken JSON database file was restored from a backup on open.
+// The database works with the data of the backup, so it is a warning for the caller to log rather than a failure.
+type RecoveredError struct {
+	// Path is the broken file, kept as {Path}.broken.
+	Path string
+	// Backup is the backup restored to Path.
+	Backup string
+	// Err is the error reading or decoding Path.
+	Err error
+}
+
+func (e *RecoveredError) Error() string {
+	return fmt.Sprintf("JSON database %s is broken (%v), recovered from %s", e.Path, e.Err, e.Backup)
+}
+
+func (e *RecoveredError) Unwrap() error {
+	return e.Err
+}
+
+// read reads the file and decodes it with decode.
+// If the file cannot be read or decoded, the newest backup decoded without error is used,
+// the broken file is kept as {path}.broken and replaced with the backup, and recovered reports it.
+// decode must reset its destination on every call.
+func (f *jsonFile) read(decode func(b []byte) error) (recovered error, err error) {
+	b, err := ioutil.ReadFile(f.path)
+	if err == nil {
+		if err = decode(b); err == nil {
+			f.setSum(b)
+			return nil, nil
+		}
+	}
+	for i := 1; i <= f.backups; i++ {
+		bb, berr := ioutil.ReadFile(f.backup(i))
+		if berr != nil || decode(bb) != nil {
+			continue
+		}
+		if rerr := os.Rename(f.path, f.path+".broken"); rerr != nil && !os.IsNotExist(rerr) {
+			return nil, rerr
+		}
+		if werr := writeFileAtomic(f.path, bb, jsonFileMode); werr != nil {
+			return nil, werr
+		}
+		f.setSum(bb)
+		return &RecoveredError{Path: f.path, Backup: f.backup(i), Err: err}, nil
+	}
+	return nil, err
+}
+
+// write rotates backups and replaces the file with b.
+// Nothing is done if b is what was last read or written, e.g. on closing without changes, to keep older backups.
+// The file is not read to compare as only this jsonFile writes it.
+func (f *jsonFile) write(b []byte) error {
+	sum := sha256.Sum256(b)
+	if f.knownSum && sum == f.sum {
+		return nil
+	}
+	if err := f.rotate(); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(f.path, b, jsonFileMode); err != nil {
+		return err
+	}
+	f.sum, f.knownSum = sum, true
+	return nil
+}
+
+func (f *jsonFile) setSum(b []byte) {
+	f.sum, f.knownSum = sha256.Sum256(b), true
+}
+
+// rotate shifts backups and keeps the current file as the newest backup.
+func (f *jsonFile) rotate() error {
+	if f.backups <= 0 || !isFile(f.path) {
+		return nil
+	}
+	if err := os.Remove(f.backup(f.backups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.backups - 1; i >= 1; i-- {
+		if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// A hard link is enough as the file is replaced by rename, not modified in place.
+	if err := os.Link(f.path, f.backup(1)); err != nil {
+		// e.g. file systems without hard links
+		b, err := ioutil.ReadFile(f.path)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(f.backup(1), b, jsonFileMode)
+	}
+	return os.Chmod(f.backup(1), jsonFileMode)
+}
+
+// writeFileAtomic replaces the file with b so that the file is either old or new after a crash.
+// b is written to a temporary file in the same directory, synced and renamed to the file.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if _, err = tmp.Write(b); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// sync the directory to persist the rename; not supported on some platforms
+	if d, derr := os.Open(dir); derr == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package db_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+func TestJSONUserDB_AtomicSave(t *testing.T) {
+	dir := t.TempDir()
+	testDBPath := filepath.Join(dir, "JSONUserDB_AtomicSave.json")
+	d, err := db.NewJSONUserDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if err := d.Add(ctx, model.NewUser(id, "user"+id, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// no changes
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("perm got %v want 0600", perm)
+	}
+	// the empty file and 5 saves
+	wantUsers := map[string]int{"": 5, ".1": 4, ".2": 3, ".3": 2}
+	for suffix, want := range wantUsers {
+		d, err := db.NewJSONUserDB(testDBPath + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		us, _ := d.List(ctx)
+		if len(us) != want {
+			t.Errorf("%s got %d users want %d", suffix, len(us), want)
+		}
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") || strings.HasSuffix(e.Name(), ".4") {
+			t.Errorf("unexpected file %s", e.Name())
+		}
+	}
+}
+
+func TestJSONUserDB_NoBackups(t *testing.T) {
+	testDBPath := filepath.Join(t.TempDir(), "JSONUserDB_NoBackups.json")
+	d, err := db.NewJSONUserDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Backups = 0
+	if err := d.Add(ctx, U1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(testDBPath + ".1"); !os.IsNotExist(err) {
+		t.Errorf("backup exists: %v", err)
+	}
+}
+
+func TestJSONActivityDB_Recover(t *testing.T) {
+	testDBPath := filepath.Join(t.TempDir(), "JSONActivityDB_Recover.json")
+	copyFile(t, filepath.Join(testdataDir, "JSONActivityDB_Query.json"), testDBPath)
+	d, err := db.NewJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Add(ctx, model.NewActivity(U1.ID, UTC202109Begin, 1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	// crash in the middle of writing without atomic saves
+	b, err := ioutil.ReadFile(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := b[:len(b)/2]
+	if err := ioutil.WriteFile(testDBPath, broken, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err = db.NewJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acts, err := d.Query(ctx, db.ActivityQuery{UserID: U1.ID, Begin: UTC202109Begin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the backup before adding
+	if len(acts) != 0 {
+		t.Errorf("got %d activities want 0", len(acts))
+	}
+	var recovered *db.RecoveredError
+	if !errors.As(d.Recovered(), &recovered) || recovered.Backup != testDBPath+".1" {
+		t.Errorf("recovered got %v", d.Recovered())
+	}
+	got, err := ioutil.ReadFile(testDBPath + ".broken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(broken) {
+		t.Error("the broken file is not kept")
+	}
+	// the recovered file is valid
+	d, err = db.NewJSONActivityDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Recovered(); err != nil {
+		t.Errorf("recovered again: %v", err)
+	}
+}
+
+func TestJSONUserDB_Recover_NoValidBackup(t *testing.T) {
+	testDBPath := filepath.Join(t.TempDir(), "JSONUserDB_Recover_NoValidBackup.json")
+	for _, f := range []string{testDBPath, testDBPath + ".1", testDBPath + ".2"} {
+		if err := ioutil.WriteFile(f, []byte(`{"123":`), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.NewJSONUserDB(testDBPath); err == nil {
+		t.Error("no error")
+	}
+}
@@ -0,0 +1,263 @@
+package memdb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// AnyMethod is the method name of a Fault injected into methods without their own Fault, including Close.
+const AnyMethod = "*"
+
+// Fault is an error and latency injected into calls of a method.
+type Fault struct {
+	// Delay is waited before calling the method, or until the context is done.
+	Delay time.Duration
+	// Err is returned instead of calling the method if not nil.
+	Err error
+	// After is the number of calls passed through before Err is returned.
+	After int
+	// Times is the number of calls returning Err. 0 means all calls after After.
+	Times int
+}
+
+// Faults holds Faults by method name, e.g. "Add", and counts calls of each method.
+// It is safe for concurrent use. The zero value has no faults.
+type Faults struct {
+	mu     sync.Mutex
+	faults map[string]Fault
+	calls  map[string]int
+}
+
+// Set sets the Fault of the method, or AnyMethod.
+func (f *Faults) Set(method string, fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.faults == nil {
+		f.faults = map[string]Fault{}
+	}
+	f.faults[method] = fault
+}
+
+// Reset removes all Faults and resets the counts of calls.
+func (f *Faults) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+	f.calls = nil
+}
+
+// Calls returns the number of calls of the method including failed ones.
+func (f *Faults) Calls(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[method]
+}
+
+// inject counts the call, waits for the delay and returns the error of the Fault of the method.
+func (f *Faults) inject(ctx context.Context, method string) error {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = map[string]int{}
+	}
+	n := f.calls[method]
+	f.calls[method]++
+	fault, ok := f.faults[method]
+	if !ok {
+		fault, ok = f.faults[AnyMethod]
+	}
+	f.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if fault.Err == nil || n < fault.After {
+		return nil
+	}
+	if fault.Times > 0 && n >= fault.After+fault.Times {
+		return nil
+	}
+	return fault.Err
+}
+
+// FaultyUserDB wraps an UserDB and injects Faults into calls of it.
+type FaultyUserDB struct {
+	db.UserDB
+	Faults Faults
+}
+
+var _ db.UserDB = (*FaultyUserDB)(nil)
+
+// NewFaultyUserDB wraps the UserDB without faults.
+func NewFaultyUserDB(d db.UserDB) *FaultyUserDB {
+	return &FaultyUserDB{UserDB: d}
+}
+
+// Close closes the wrapped UserDB.
+func (d *FaultyUserDB) Close() error {
+	if err := d.Faults.inject(context.Background(), "Close"); err != nil {
+		return err
+	}
+	return d.UserDB.Close()
+}
+
+// Get calls Get of the wrapped UserDB.
+func (d *FaultyUserDB) Get(ctx context.Context, userID string) (*model.User, error) {
+	if err := d.Faults.inject(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return d.UserDB.Get(ctx, userID)
+}
+
+// GetByIdentity calls GetByIdentity of the wrapped UserDB.
+func (d *FaultyUserDB) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	if err := d.Faults.inject(ctx, "GetByIdentity"); err != nil {
+		return nil, err
+	}
+	return d.UserDB.GetByIdentity(ctx, provider, subject)
+}
+
+// GetByTwitterID calls GetByTwitterID of the wrapped UserDB.
+func (d *FaultyUserDB) GetByTwitterID(ctx context.Context, twitterID string) (*model.User, error) {
+	if err := d.Faults.inject(ctx, "GetByTwitterID"); err != nil {
+		return nil, err
+	}
+	return d.UserDB.GetByTwitterID(ctx, twitterID)
+}
+
+// Add calls Add of the wrapped UserDB.
+func (d *FaultyUserDB) Add(ctx context.Context, user *model.User) error {
+	if err := d.Faults.inject(ctx, "Add"); err != nil {
+		return err
+	}
+	return d.UserDB.Add(ctx, user)
+}
+
+// Update calls Update of the wrapped UserDB.
+func (d *FaultyUserDB) Update(ctx context.Context, user *model.User) error {
+	if err := d.Faults.inject(ctx, "Update"); err != nil {
+		return err
+	}
+	return d.UserDB.Update(ctx, user)
+}
+
+// List calls List of the wrapped UserDB.
+func (d *FaultyUserDB) List(ctx context.Context) ([]*model.User, error) {
+	if err := d.Faults.inject(ctx, "List"); err != nil {
+		return nil, err
+	}
+	return d.UserDB.List(ctx)
+}
+
+// Delete calls Delete of the wrapped UserDB.
+func (d *FaultyUserDB) Delete(ctx context.Context, userID string) error {
+	if err := d.Faults.inject(ctx, "Delete"); err != nil {
+		return err
+	}
+	return d.UserDB.Delete(ctx, userID)
+}
+
+// FaultyActivityDB wraps an ActivityDB and injects Faults into calls of it.
+type FaultyActivityDB struct {
+	db.ActivityDB
+	Faults Faults
+}
+
+var _ db.ActivityDB = (*FaultyActivityDB)(nil)
+
+// NewFaultyActivityDB wraps the ActivityDB without faults.
+func NewFaultyActivityDB(d db.ActivityDB) *FaultyActivityDB {
+	return &FaultyActivityDB{ActivityDB: d}
+}
+
+// Close closes the wrapped ActivityDB.
+func (d *FaultyActivityDB) Close() error {
+	if err := d.Faults.inject(context.Background(), "Close"); err != nil {
+		return err
+	}
+	return d.ActivityDB.Close()
+}
+
+// Get calls Get of the wrapped ActivityDB.
+func (d *FaultyActivityDB) Get(ctx context.Context, userID, activityID string) (*model.Activity, error) {
+	if err := d.Faults.inject(ctx, "Get"); err != nil {
+		return nil, err
+	}
+	return d.ActivityDB.Get(ctx, userID, activityID)
+}
+
+// Add calls Add of the wrapped ActivityDB.
+func (d *FaultyActivityDB) Add(ctx context.Context, activity *model.Activity) error {
+	if err := d.Faults.inject(ctx, "Add"); err != nil {
+		return err
+	}
+	return d.ActivityDB.Add(ctx, activity)
+}
+
+// Import calls Import of the wrapped ActivityDB.
+func (d *FaultyActivityDB) Import(ctx context.Context, activities []*model.Activity) error {
+	if err := d.Faults.inject(ctx, "Import"); err != nil {
+		return err
+	}
+	return d.ActivityDB.Import(ctx, activities)
+}
+
+// Update calls Update of the wrapped ActivityDB.
+func (d *FaultyActivityDB) Update(ctx context.Context, activity *model.Activity) error {
+	if err := d.Faults.inject(ctx, "Update"); err != nil {
+		return err
+	}
+	return d.ActivityDB.Update(ctx, activity)
+}
+
+// Delete calls Delete of the wrapped ActivityDB.
+func (d *FaultyActivityDB) Delete(ctx context.Context, userID, activityID string) error {
+	if err := d.Faults.inject(ctx, "Delete"); err != nil {
+		return err
+	}
+	return d.ActivityDB.Delete(ctx, userID, activityID)
+}
+
+// DeleteByUser calls DeleteByUser of the wrapped ActivityDB.
+func (d *FaultyActivityDB) DeleteByUser(ctx context.Context, userID string) (int, error) {
+	if err := d.Faults.inject(ctx, "DeleteByUser"); err != nil {
+		return 0, err
+	}
+	return d.ActivityDB.DeleteByUser(ctx, userID)
+}
+
+// Query calls Query of the wrapped ActivityDB.
+func (d *FaultyActivityDB) Query(ctx context.Context, q db.ActivityQuery) ([]*model.Activity, error) {
+	if err := d.Faults.inject(ctx, "Query"); err != nil {
+		return nil, err
+	}
+	return d.ActivityDB.Query(ctx, q)
+}
+
+// Sum calls Sum of the wrapped ActivityDB.
+func (d *FaultyActivityDB) Sum(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	if err := d.Faults.inject(ctx, "Sum"); err != nil {
+		return nil, err
+	}
+	return d.ActivityDB.Sum(ctx, userID, begin, end)
+}
+
+// SumByUser calls SumByUser of the wrapped ActivityDB.
+func (d *FaultyActivityDB) SumByUser(ctx context.Context, begin, end time.Time) (map[string]*model.Goki, error) {
+	if err := d.Faults.inject(ctx, "SumByUser"); err != nil {
+		return nil, err
+	}
+	return d.ActivityDB.SumByUser(ctx, begin, end)
+}
@@ -0,0 +1,66 @@
+package memdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/memdb"
+	"github.com/ebiiim/goki/model"
+)
+
+var ctx = context.Background()
+
+func TestFaults_Err(t *testing.T) {
+	errTest := errors.New("test")
+	d := memdb.NewFaultyActivityDB(memdb.NewActivityDB())
+	d.Faults.Set("Add", memdb.Fault{Err: errTest, After: 1, Times: 2})
+	add := func() error {
+		return d.Add(ctx, model.NewActivity("123", time.Date(2020, 8, 1, 9, 0, 0, 0, time.UTC), 1, 0, 0))
+	}
+	for i, want := range []error{nil, errTest, errTest, nil} {
+		if err := add(); !errors.Is(err, want) {
+			t.Errorf("call %d: want %v but got %v", i, want, err)
+		}
+	}
+	if n := d.Faults.Calls("Add"); n != 4 {
+		t.Errorf("calls got %d want 4", n)
+	}
+	// failed calls are not passed to the wrapped ActivityDB
+	if acts, err := d.Query(ctx, db.ActivityQuery{UserID: "123"}); err != nil || len(acts) != 2 {
+		t.Errorf("Query: got %v %v", acts, err)
+	}
+
+	d.Faults.Set(memdb.AnyMethod, memdb.Fault{Err: errTest})
+	if _, err := d.Query(ctx, db.ActivityQuery{UserID: "123"}); !errors.Is(err, errTest) {
+		t.Errorf("AnyMethod: want %v but got %v", errTest, err)
+	}
+	if err := add(); err != nil {
+		t.Errorf("the fault of the method is prior to AnyMethod: got %v", err)
+	}
+	d.Faults.Reset()
+	if _, err := d.Query(ctx, db.ActivityQuery{UserID: "123"}); err != nil || d.Faults.Calls("Query") != 1 {
+		t.Errorf("Reset: got %v and %d calls", err, d.Faults.Calls("Query"))
+	}
+}
+
+func TestFaults_Delay(t *testing.T) {
+	d := memdb.NewFaultyUserDB(memdb.NewUserDB())
+	d.Faults.Set("Add", memdb.Fault{Delay: 50 * time.Millisecond})
+	start := time.Now()
+	if err := d.Add(ctx, model.NewUser("123", "alice", "")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("not delayed: %v", elapsed)
+	}
+
+	d.Faults.Set("Get", memdb.Fault{Delay: time.Hour})
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.Get(timeout, "123"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded but got %v", err)
+	}
+}
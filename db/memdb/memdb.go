@@ -0,0 +1,374 @@
+// Package memdb provides in-memory implementations of db.UserDB and db.ActivityDB,
+// and wrappers injecting errors and latency into them or any other backend.
+//
+// Nothing is persisted, so they are intended for tests of packages using the db interfaces.
+package memdb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// UserDB is an in-memory db.UserDB. The zero value is not usable; use NewUserDB.
+type UserDB struct {
+	mu sync.Mutex
+	// UserID -> User
+	users map[string]*model.User
+}
+
+var _ db.UserDB = (*UserDB)(nil)
+
+// NewUserDB initializes an empty UserDB.
+func NewUserDB() *UserDB {
+	return &UserDB{users: map[string]*model.User{}}
+}
+
+// Close does nothing.
+func (d *UserDB) Close() error {
+	return nil
+}
+
+// Get gets an user or error.
+func (d *UserDB) Get(_ context.Context, userID string) (*model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u, ok := d.users[userID]
+	if !ok {
+		return nil, goki.ErrUserNotFound
+	}
+	return copyUser(u), nil
+}
+
+// GetByIdentity gets an user by an account of an identity provider or error.
+func (d *UserDB) GetByIdentity(_ context.Context, provider, subject string) (*model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if u := d.owner(provider, subject); u != nil {
+		return copyUser(u), nil
+	}
+	return nil, goki.ErrUserNotFound
+}
+
+// GetByTwitterID gets an user by Twitter ID or error.
+func (d *UserDB) GetByTwitterID(ctx context.Context, twitterID string) (*model.User, error) {
+	return d.GetByIdentity(ctx, model.ProviderTwitter, twitterID)
+}
+
+// Add adds an user.
+func (d *UserDB) Add(_ context.Context, user *model.User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.users[user.ID]; ok {
+		return goki.ErrUserAlreadyExist
+	}
+	if d.identityUsed(user) {
+		return goki.ErrUserAlreadyExist
+	}
+	d.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// Update replaces an user.
+func (d *UserDB) Update(_ context.Context, user *model.User) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.users[user.ID]; !ok {
+		return goki.ErrUserNotFound
+	}
+	if d.identityUsed(user) {
+		return goki.ErrUserAlreadyExist
+	}
+	d.users[user.ID] = copyUser(user)
+	return nil
+}
+
+// List returns all users ordered by ID.
+func (d *UserDB) List(_ context.Context) ([]*model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ret := make([]*model.User, 0, len(d.users))
+	for _, u := range d.users {
+		ret = append(ret, copyUser(u))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret, nil
+}
+
+// Delete deletes an user.
+func (d *UserDB) Delete(_ context.Context, userID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.users[userID]; !ok {
+		return goki.ErrUserNotFound
+	}
+	delete(d.users, userID)
+	return nil
+}
+
+// owner returns the user linked to the identity or nil.
+func (d *UserDB) owner(provider, subject string) *model.User {
+	if subject == "" {
+		return nil
+	}
+	for _, u := range d.users {
+		if u.Subject(provider) == subject {
+			return u
+		}
+	}
+	return nil
+}
+
+// identityUsed reports whether any identity of the user is linked to another user.
+func (d *UserDB) identityUsed(user *model.User) bool {
+	for _, id := range user.Identities {
+		if u := d.owner(id.Provider, id.Subject); u != nil && u.ID != user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func copyUser(u *model.User) *model.User {
+	uu := *u
+	uu.Identities = nil
+	for _, id := range u.Identities {
+		i := *id
+		uu.Identities = append(uu.Identities, &i)
+	}
+	uu.Tokens = nil
+	for _, tk := range u.Tokens {
+		t := *tk
+		uu.Tokens = append(uu.Tokens, &t)
+	}
+	return &uu
+}
+
+// ActivityDB is an in-memory db.ActivityDB. The zero value is not usable; use NewActivityDB.
+//
+// Timestamps are stored in seconds like the other backends, and activities of an user are kept sorted by them.
+type ActivityDB struct {
+	mu sync.Mutex
+	// UserID -> activities sorted by TimeUTC
+	acts map[string][]*model.Activity
+}
+
+var _ db.ActivityDB = (*ActivityDB)(nil)
+
+// NewActivityDB initializes an empty ActivityDB.
+func NewActivityDB() *ActivityDB {
+	return &ActivityDB{acts: map[string][]*model.Activity{}}
+}
+
+// Close does nothing.
+func (d *ActivityDB) Close() error {
+	return nil
+}
+
+// Get gets an activity of the user or error.
+func (d *ActivityDB) Get(_ context.Context, userID, activityID string) (*model.Activity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := d.index(userID, activityID)
+	if i < 0 {
+		return nil, goki.ErrActivityNotFound
+	}
+	return copyActivity(d.acts[userID][i]), nil
+}
+
+// Add adds an activity.
+// If the user has an activity at the same second, the given one is stored with timestamp++.
+// A new ID is assigned if Activity.ID is empty.
+func (d *ActivityDB) Add(_ context.Context, activity *model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if activity.ID != "" && d.index(activity.UserID, activity.ID) >= 0 {
+		return goki.ErrActivityAlreadyExist
+	}
+	ts := activity.TimeUTC.Truncate(time.Second)
+	for d.at(activity.UserID, ts) {
+		ts = ts.Add(time.Second)
+	}
+	d.insert(activity, ts)
+	return nil
+}
+
+// Import adds activities at once, keeping their timestamps.
+// Checks all activities first so that nothing is added on error.
+func (d *ActivityDB) Import(_ context.Context, activities []*model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	type idKey struct{ userID, id string }
+	type timeKey struct {
+		userID string
+		unix   int64
+	}
+	ids := map[idKey]bool{}
+	times := map[timeKey]bool{}
+	for _, act := range activities {
+		ts := act.TimeUTC.Truncate(time.Second)
+		tk := timeKey{act.UserID, ts.Unix()}
+		if d.at(act.UserID, ts) || times[tk] {
+			return goki.ErrActivityAlreadyExist
+		}
+		times[tk] = true
+		if act.ID == "" {
+			continue
+		}
+		ik := idKey{act.UserID, act.ID}
+		if d.index(act.UserID, act.ID) >= 0 || ids[ik] {
+			return goki.ErrActivityAlreadyExist
+		}
+		ids[ik] = true
+	}
+	for _, act := range activities {
+		d.insert(act, act.TimeUTC.Truncate(time.Second))
+	}
+	return nil
+}
+
+// Update updates Activity.G of the activity.
+func (d *ActivityDB) Update(_ context.Context, activity *model.Activity) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := d.index(activity.UserID, activity.ID)
+	if i < 0 {
+		return goki.ErrActivityNotFound
+	}
+	d.acts[activity.UserID][i].G = model.NewGoki(activity.G.S, activity.G.M, activity.G.L)
+	return nil
+}
+
+// Delete deletes an activity of the user.
+func (d *ActivityDB) Delete(_ context.Context, userID, activityID string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := d.index(userID, activityID)
+	if i < 0 {
+		return goki.ErrActivityNotFound
+	}
+	al := d.acts[userID]
+	d.acts[userID] = append(al[:i], al[i+1:]...)
+	return nil
+}
+
+// DeleteByUser deletes all activities of the user.
+func (d *ActivityDB) DeleteByUser(_ context.Context, userID string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.acts[userID])
+	delete(d.acts, userID)
+	return n, nil
+}
+
+// Query returns copies of the activities matching the query.
+func (d *ActivityDB) Query(_ context.Context, q db.ActivityQuery) ([]*model.Activity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	al := d.between(q.UserID, q.Begin, q.End)
+	if q.Offset < 0 {
+		q.Offset = 0
+	}
+	ret := []*model.Activity{}
+	for i := q.Offset; i < len(al); i++ {
+		if q.Limit > 0 && len(ret) == q.Limit {
+			break
+		}
+		a := al[i]
+		if q.Order == db.OrderDesc {
+			a = al[len(al)-1-i]
+		}
+		ret = append(ret, copyActivity(a))
+	}
+	return ret, nil
+}
+
+// Sum sums roaches of the user in [begin, end).
+func (d *ActivityDB) Sum(_ context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g := model.NewGoki(0, 0, 0)
+	for _, a := range d.between(userID, begin, end) {
+		g = model.GokiSum(g, a.G)
+	}
+	return g, nil
+}
+
+// SumByUser sums roaches of each user in [begin, end).
+func (d *ActivityDB) SumByUser(_ context.Context, begin, end time.Time) (map[string]*model.Goki, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ret := map[string]*model.Goki{}
+	for userID := range d.acts {
+		al := d.between(userID, begin, end)
+		if len(al) == 0 {
+			continue
+		}
+		g := model.NewGoki(0, 0, 0)
+		for _, a := range al {
+			g = model.GokiSum(g, a.G)
+		}
+		ret[userID] = g
+	}
+	return ret, nil
+}
+
+// index returns the index of the activity in d.acts[userID] or -1.
+func (d *ActivityDB) index(userID, activityID string) int {
+	for i, a := range d.acts[userID] {
+		if a.ID == activityID {
+			return i
+		}
+	}
+	return -1
+}
+
+// at reports whether the user has an activity at ts.
+func (d *ActivityDB) at(userID string, ts time.Time) bool {
+	al := d.acts[userID]
+	i := sort.Search(len(al), func(i int) bool { return !al[i].TimeUTC.Before(ts) })
+	return i < len(al) && al[i].TimeUTC.Equal(ts)
+}
+
+// insert stores a copy of the activity at ts keeping the order. A new ID is assigned if Activity.ID is empty.
+func (d *ActivityDB) insert(activity *model.Activity, ts time.Time) {
+	a := model.NewActivity(activity.UserID, ts.In(time.UTC), activity.G.S, activity.G.M, activity.G.L)
+	a.ID = activity.ID
+	if a.ID == "" {
+		a.ID = goki.NewID()
+	}
+	al := d.acts[a.UserID]
+	i := sort.Search(len(al), func(i int) bool { return al[i].TimeUTC.After(ts) })
+	al = append(al, nil)
+	copy(al[i+1:], al[i:])
+	al[i] = a
+	d.acts[a.UserID] = al
+}
+
+// between returns activities of the user in [begin, end).
+// The zero value of begin or end means unbounded.
+func (d *ActivityDB) between(userID string, begin, end time.Time) []*model.Activity {
+	al := d.acts[userID]
+	lo, hi := 0, len(al)
+	if !begin.IsZero() {
+		lo = sort.Search(len(al), func(i int) bool { return !al[i].TimeUTC.Before(begin) })
+	}
+	if !end.IsZero() {
+		hi = sort.Search(len(al), func(i int) bool { return !al[i].TimeUTC.Before(end) })
+	}
+	if lo >= hi {
+		return nil
+	}
+	return al[lo:hi]
+}
+
+func copyActivity(a *model.Activity) *model.Activity {
+	ret := model.NewActivity(a.UserID, a.TimeUTC, a.G.S, a.G.M, a.G.L)
+	ret.ID = a.ID
+	return ret
+}
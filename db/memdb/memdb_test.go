@@ -0,0 +1,33 @@
+package memdb_test
+
+import (
+	"testing"
+
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/dbtest"
+	"github.com/ebiiim/goki/db/memdb"
+)
+
+func TestUserDB(t *testing.T) {
+	dbtest.TestUserDB(t, func(t *testing.T) db.UserDB {
+		return memdb.NewUserDB()
+	})
+}
+
+func TestActivityDB(t *testing.T) {
+	dbtest.TestActivityDB(t, func(t *testing.T) db.ActivityDB {
+		return memdb.NewActivityDB()
+	})
+}
+
+func TestFaultyUserDB(t *testing.T) {
+	dbtest.TestUserDB(t, func(t *testing.T) db.UserDB {
+		return memdb.NewFaultyUserDB(memdb.NewUserDB())
+	})
+}
+
+func TestFaultyActivityDB(t *testing.T) {
+	dbtest.TestActivityDB(t, func(t *testing.T) db.ActivityDB {
+		return memdb.NewFaultyActivityDB(memdb.NewActivityDB())
+	})
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"math"
+	"time"
+
+	"github.com/ebiiim/goki/model"
+)
+
+// monthRollup holds the sums of roaches per user per UTC month
+// so that in-memory ActivityDB implementations can sum activities without visiting each of them.
+// UserID -> month (see monthOf) -> monthSum
+type monthRollup map[string]map[int]*monthSum
+
+// monthSum is the sum of roaches and the number of activities in a month.
+type monthSum struct {
+	G model.Goki
+	N int
+}
+
+// newMonthRollup builds a monthRollup from UserID -> time.Unix -> Activity.
+func newMonthRollup(db map[string]map[int64]*model.Activity) monthRollup {
+	r := monthRollup{}
+	for userID, al := range db {
+		for k, a := range al {
+			r.add(userID, k, a.G)
+		}
+	}
+	return r
+}
+
+// monthOf returns the UTC month of the time.Unix key as the number of months since year 0.
+func monthOf(key int64) int {
+	t := time.Unix(key, 0).UTC()
+	return t.Year()*12 + int(t.Month()) - 1
+}
+
+// monthRange returns the time.Unix keys of the month in [begin, end).
+func monthRange(month int) (begin, end int64) {
+	b := time.Date(month/12, time.Month(month%12+1), 1, 0, 0, 0, 0, time.UTC)
+	return b.Unix(), b.AddDate(0, 1, 0).Unix()
+}
+
+// add adds g of an activity of the user at the key.
+func (r monthRollup) add(userID string, key int64, g *model.Goki) {
+	if r[userID] == nil {
+		r[userID] = map[int]*monthSum{}
+	}
+	m := monthOf(key)
+	s := r[userID][m]
+	if s == nil {
+		s = &monthSum{}
+		r[userID][m] = s
+	}
+	s.G.S += g.S
+	s.G.M += g.M
+	s.G.L += g.L
+	s.N++
+}
+
+// sub subtracts g of a removed activity of the user at the key.
+func (r monthRollup) sub(userID string, key int64, g *model.Goki) {
+	m := monthOf(key)
+	s := r[userID][m]
+	if s == nil {
+		return
+	}
+	s.G.S -= g.S
+	s.G.M -= g.M
+	s.G.L -= g.L
+	s.N--
+	if s.N <= 0 {
+		delete(r[userID], m)
+	}
+}
+
+// sum sums roaches of the user in [begin, end) and returns the number of the activities.
+// The zero value of begin or end means unbounded.
+// Months entirely in the range are taken from the rollup, and only activities in the other months are visited.
+func (d *activityMap) sum(userID string, begin, end time.Time) (model.Goki, int) {
+	b, e := unixRange(begin, end)
+	var (
+		g model.Goki
+		n int
+	)
+	al := d.db[userID]
+	for m, s := range d.rollup[userID] {
+		mb, me := monthRange(m)
+		switch {
+		case me <= b || e <= mb:
+			// out of the range
+		case b <= mb && me <= e:
+			g.S += s.G.S
+			g.M += s.G.M
+			g.L += s.G.L
+			n += s.N
+		default:
+			for _, k := range d.idx.keysIn(userID, maxInt64(b, mb), minInt64(e, me)) {
+				g.S += al[k].G.S
+				g.M += al[k].G.M
+				g.L += al[k].G.L
+				n++
+			}
+		}
+	}
+	return g, n
+}
+
+// unixRange returns the time.Unix keys of [begin, end).
+// The zero value of begin or end means unbounded.
+func unixRange(begin, end time.Time) (b, e int64) {
+	b, e = math.MinInt64, math.MaxInt64
+	if !begin.IsZero() {
+		b = ceilUnix(begin)
+	}
+	if !end.IsZero() {
+		e = ceilUnix(end)
+	}
+	return b, e
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ebiiim/goki/model"
+)
+
+// newBenchActivityMap returns an activityMap of an user with an activity every hour for years.
+func newBenchActivityMap(years int) *activityMap {
+	d := newActivityMap()
+	begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for t := begin; t.Before(begin.AddDate(years, 0, 0)); t = t.Add(time.Hour) {
+		d.add(model.NewActivity("123", t, 1, 2, 3))
+	}
+	return &d
+}
+
+func TestActivityMap_Sum(t *testing.T) {
+	d := newBenchActivityMap(2)
+	jst := time.FixedZone("JST", 9*60*60)
+	for _, r := range [][2]time.Time{
+		{{}, {}},
+		{time.Date(2020, 1, 1, 0, 0, 0, 0, jst), time.Date(2021, 1, 1, 0, 0, 0, 0, jst)},
+		{time.Date(2020, 2, 10, 12, 30, 0, 0, time.UTC), time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2020, 3, 1, 0, 0, 0, 1, time.UTC), {}},
+	} {
+		var want model.Goki
+		for _, a := range d.query(ActivityQuery{UserID: "123", Begin: r[0], End: r[1]}) {
+			want = *model.GokiSum(&want, a.G)
+		}
+		if got, _ := d.sum("123", r[0], r[1]); got != want {
+			t.Errorf("%v: got %+v want %+v", r, got, want)
+		}
+	}
+}
+
+func BenchmarkActivityMap_Sum(b *testing.B) {
+	d := newBenchActivityMap(3)
+	jst := time.FixedZone("JST", 9*60*60)
+	begin, end := time.Date(2021, 1, 1, 0, 0, 0, 0, jst), time.Date(2022, 1, 1, 0, 0, 0, 0, jst)
+	b.Run("rollup", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			d.sum("123", begin, end)
+		}
+	})
+	b.Run("query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var g model.Goki
+			for _, a := range d.query(ActivityQuery{UserID: "123", Begin: begin, End: end}) {
+				g = *model.GokiSum(&g, a.G)
+			}
+		}
+	})
+}
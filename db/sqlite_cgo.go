@@ -0,0 +1,19 @@
+//go:build cgo
+// +build cgo
+
+package db
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteEnabled reports whether the SQLite driver works, which needs cgo.
+const sqliteEnabled = true
+
+// isSQLiteConstraint reports whether err is a constraint violation e.g. UNIQUE.
+func isSQLiteConstraint(err error) bool {
+	var serr sqlite3.Error
+	return errors.As(err, &serr) && serr.Code == sqlite3.ErrConstraint
+}
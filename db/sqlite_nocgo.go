@@ -0,0 +1,13 @@
+//go:build !cgo
+// +build !cgo
+
+package db
+
+// sqliteEnabled reports whether the SQLite driver works, which needs cgo.
+// Without cgo, NewSQLiteUserDB and NewSQLiteActivityDB return goki.ErrDBOpen.
+const sqliteEnabled = false
+
+// isSQLiteConstraint reports whether err is a constraint violation e.g. UNIQUE.
+func isSQLiteConstraint(err error) bool {
+	return false
+}
@@ -0,0 +1,20 @@
+//go:build !cgo
+// +build !cgo
+
+package db_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ebiiim/goki/db"
+)
+
+func TestOpen_SQLiteWithoutCgo(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "goki.db")
+	_, _, err := db.Open(db.DriverSQLite, dbPath, dbPath)
+	if err == nil || !strings.Contains(err.Error(), "cgo") {
+		t.Errorf("want an error about cgo but got %v", err)
+	}
+}
@@ -0,0 +1,666 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// sqliteMigration updates the schema by one version.
+type sqliteMigration func(tx *sql.Tx) error
+
+// sqliteExec returns a sqliteMigration that executes the given statements in order.
+func sqliteExec(stmts ...string) sqliteMigration {
+	return func(tx *sql.Tx) error {
+		for _, s := range stmts {
+			if _, err := tx.Exec(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// sqliteUserMigrations holds the schema history of SQLiteUserDB.
+// NEVER modify released migrations; append a new one instead.
+var sqliteUserMigrations = []sqliteMigration{
+	// version 1
+	sqliteExec(
+		`CREATE TABLE users (
+			id         TEXT PRIMARY KEY,
+			name       TEXT NOT NULL,
+			twitter_id TEXT NOT NULL
+		)`,
+		`CREATE UNIQUE INDEX users_twitter_id ON users (twitter_id)`,
+	),
+	// version 2: API tokens
+	sqliteExec(
+		`CREATE TABLE api_tokens (
+			id          TEXT PRIMARY KEY,
+			user_id     TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name        TEXT NOT NULL,
+			hash        TEXT NOT NULL,
+			created_utc INTEGER NOT NULL
+		)`,
+		`CREATE INDEX api_tokens_user_id ON api_tokens (user_id)`,
+	),
+	// version 3: identities; users.twitter_id is no longer used
+	sqliteExec(
+		`CREATE TABLE identities (
+			provider TEXT NOT NULL,
+			subject  TEXT NOT NULL,
+			user_id  TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			PRIMARY KEY (provider, subject)
+		)`,
+		`CREATE INDEX identities_user_id ON identities (user_id)`,
+		`INSERT INTO identities (provider, subject, user_id)
+			SELECT 'twitter', twitter_id, id FROM users WHERE twitter_id != ''`,
+		`DROP INDEX users_twitter_id`,
+	),
+	// version 4: local accounts
+	sqliteExec(
+		`ALTER TABLE users ADD COLUMN password_hash TEXT NOT NULL DEFAULT ''`,
+	),
+	// version 5: leaderboard opt-in
+	sqliteExec(
+		`ALTER TABLE users ADD COLUMN public INTEGER NOT NULL DEFAULT 0`,
+	),
+	// version 6: time zone
+	sqliteExec(
+		`ALTER TABLE users ADD COLUMN time_zone TEXT NOT NULL DEFAULT ''`,
+	),
+}
+
+// sqliteActivityMigrations holds the schema history of SQLiteActivityDB.
+// NEVER modify released migrations; append a new one instead.
+var sqliteActivityMigrations = []sqliteMigration{
+	// version 1
+	sqliteExec(
+		`CREATE TABLE activities (
+			id       INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id  TEXT NOT NULL,
+			time_utc INTEGER NOT NULL,
+			s        INTEGER NOT NULL,
+			m        INTEGER NOT NULL,
+			l        INTEGER NOT NULL
+		)`,
+		`CREATE INDEX activities_user_id_time_utc ON activities (user_id, time_utc)`,
+		`CREATE INDEX activities_time_utc ON activities (time_utc)`,
+	),
+	// version 2: activity IDs
+	func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`ALTER TABLE activities ADD COLUMN activity_id TEXT`); err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT id FROM activities`)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := tx.Exec(`UPDATE activities SET activity_id = ? WHERE id = ?`, goki.NewID(), id); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(`CREATE UNIQUE INDEX activities_activity_id ON activities (activity_id)`)
+		return err
+	},
+}
+
+// openSQLite opens a SQLite database file and applies migrations for the schema named name.
+// The version of each schema is stored in the schema_versions table,
+// so SQLiteUserDB and SQLiteActivityDB can share the same file.
+func openSQLite(filePath, name string, migrations []sqliteMigration) (*sql.DB, error) {
+	if !sqliteEnabled {
+		return nil, errors.New("SQLite requires a binary built with cgo")
+	}
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on", filePath)
+	sdb, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := sqliteMigrate(sdb, name, migrations); err != nil {
+		sdb.Close()
+		return nil, err
+	}
+	return sdb, nil
+}
+
+func sqliteMigrate(sdb *sql.DB, name string, migrations []sqliteMigration) error {
+	tx, err := sdb.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_versions (
+		name    TEXT PRIMARY KEY,
+		version INTEGER NOT NULL
+	)`); err != nil {
+		return err
+	}
+	var version int
+	err = tx.QueryRow(`SELECT version FROM schema_versions WHERE name = ?`, name).Scan(&version)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema %s version %d is newer than this application supports (%d)", name, version, len(migrations))
+	}
+	for ; version < len(migrations); version++ {
+		if err := migrations[version](tx); err != nil {
+			return fmt.Errorf("could not migrate schema %s to version %d: %w", name, version+1, err)
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_versions (name, version) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET version = excluded.version`, name, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqliteAffected converts the result of an UPDATE or DELETE statement to an error.
+// Returns notFound if no rows are affected.
+func sqliteAffected(res sql.Result, err error, notFound error) error {
+	if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// SQLiteUserDB is an UserDB stores data in a SQLite database file.
+// Cannot be read from multiple app instances.
+type SQLiteUserDB struct {
+	db *sql.DB
+}
+
+var _ UserDB = (*SQLiteUserDB)(nil)
+
+// NewSQLiteUserDB initializes a SQLiteUserDB.
+// The file and tables are created if needed.
+func NewSQLiteUserDB(filePath string) (*SQLiteUserDB, error) {
+	sdb, err := openSQLite(filePath, "users", sqliteUserMigrations)
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBOpen, err)
+	}
+	return &SQLiteUserDB{db: sdb}, nil
+}
+
+// Close closes the database.
+func (d *SQLiteUserDB) Close() error {
+	if err := d.db.Close(); err != nil {
+		return goki.ErrWrap(goki.ErrDBClose, err)
+	}
+	return nil
+}
+
+func (d *SQLiteUserDB) get(ctx context.Context, query string, args ...interface{}) (*model.User, error) {
+	var u model.User
+	err := d.db.QueryRowContext(ctx, query, args...).Scan(&u.ID, &u.Name, &u.PasswordHash, &u.Public, &u.TimeZone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, goki.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	if u.Identities, err = d.getIdentities(ctx, u.ID); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	if u.Tokens, err = d.getTokens(ctx, u.ID); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	return &u, nil
+}
+
+func (d *SQLiteUserDB) getIdentities(ctx context.Context, userID string) ([]*model.Identity, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT provider, subject FROM identities WHERE user_id = ? ORDER BY provider`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []*model.Identity
+	for rows.Next() {
+		var id model.Identity
+		if err := rows.Scan(&id.Provider, &id.Subject); err != nil {
+			return nil, err
+		}
+		ids = append(ids, &id)
+	}
+	return ids, rows.Err()
+}
+
+// putIdentities replaces identities of the user.
+func (d *SQLiteUserDB) putIdentities(ctx context.Context, tx *sql.Tx, user *model.User) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM identities WHERE user_id = ?`, user.ID); err != nil {
+		return err
+	}
+	for _, id := range user.Identities {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO identities (provider, subject, user_id) VALUES (?, ?, ?)`,
+			id.Provider, id.Subject, user.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *SQLiteUserDB) getTokens(ctx context.Context, userID string) ([]*model.APIToken, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT id, name, hash, created_utc FROM api_tokens WHERE user_id = ? ORDER BY created_utc, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ts []*model.APIToken
+	for rows.Next() {
+		var t model.APIToken
+		var created int64
+		if err := rows.Scan(&t.ID, &t.Name, &t.Hash, &created); err != nil {
+			return nil, err
+		}
+		t.CreatedUTC = time.Unix(created, 0).In(time.UTC)
+		ts = append(ts, &t)
+	}
+	return ts, rows.Err()
+}
+
+// putTokens replaces API tokens of the user.
+func (d *SQLiteUserDB) putTokens(ctx context.Context, tx *sql.Tx, user *model.User) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM api_tokens WHERE user_id = ?`, user.ID); err != nil {
+		return err
+	}
+	for _, t := range user.Tokens {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO api_tokens (id, user_id, name, hash, created_utc) VALUES (?, ?, ?, ?, ?)`,
+			t.ID, user.ID, t.Name, t.Hash, t.CreatedUTC.Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get gets an user or error.
+func (d *SQLiteUserDB) Get(ctx context.Context, userID string) (*model.User, error) {
+	return d.get(ctx, `SELECT id, name, password_hash, public, time_zone FROM users WHERE id = ?`, userID)
+}
+
+// GetByIdentity gets an user by an account of an identity provider or error.
+func (d *SQLiteUserDB) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	return d.get(ctx, `SELECT u.id, u.name, u.password_hash, u.public, u.time_zone FROM users u JOIN identities i ON i.user_id = u.id
+		WHERE i.provider = ? AND i.subject = ?`, provider, subject)
+}
+
+// GetByTwitterID gets an user by Twitter ID or error.
+func (d *SQLiteUserDB) GetByTwitterID(ctx context.Context, twitterID string) (*model.User, error) {
+	return d.GetByIdentity(ctx, model.ProviderTwitter, twitterID)
+}
+
+// Add adds an user.
+func (d *SQLiteUserDB) Add(ctx context.Context, user *model.User) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `INSERT INTO users (id, name, twitter_id, password_hash, public, time_zone) VALUES (?, ?, '', ?, ?, ?)`,
+		user.ID, user.Name, user.PasswordHash, user.Public, user.TimeZone)
+	if isSQLiteConstraint(err) {
+		return goki.ErrUserAlreadyExist
+	}
+	if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	if err := d.putIdentities(ctx, tx, user); isSQLiteConstraint(err) {
+		return goki.ErrUserAlreadyExist
+	} else if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	if err := d.putTokens(ctx, tx, user); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return nil
+}
+
+// Update replaces an user.
+func (d *SQLiteUserDB) Update(ctx context.Context, user *model.User) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	defer tx.Rollback()
+	res, err := tx.ExecContext(ctx, `UPDATE users SET name = ?, password_hash = ?, public = ?, time_zone = ? WHERE id = ?`,
+		user.Name, user.PasswordHash, user.Public, user.TimeZone, user.ID)
+	if err := sqliteAffected(res, err, goki.ErrUserNotFound); err != nil {
+		return err
+	}
+	if err := d.putIdentities(ctx, tx, user); isSQLiteConstraint(err) {
+		return goki.ErrUserAlreadyExist
+	} else if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	if err := d.putTokens(ctx, tx, user); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return nil
+}
+
+// Delete deletes an user. Identities and API tokens are deleted by ON DELETE CASCADE.
+func (d *SQLiteUserDB) Delete(ctx context.Context, userID string) error {
+	res, err := d.db.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, userID)
+	return sqliteAffected(res, err, goki.ErrUserNotFound)
+}
+
+// List returns all users ordered by ID.
+func (d *SQLiteUserDB) List(ctx context.Context) ([]*model.User, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT id, name, password_hash, public, time_zone FROM users ORDER BY id`)
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	defer rows.Close()
+	ret := []*model.User{}
+	byID := map[string]*model.User{}
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.ID, &u.Name, &u.PasswordHash, &u.Public, &u.TimeZone); err != nil {
+			return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+		}
+		ret = append(ret, &u)
+		byID[u.ID] = &u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	// fill identities and tokens with one query each
+	irows, err := d.db.QueryContext(ctx, `SELECT user_id, provider, subject FROM identities ORDER BY user_id, provider`)
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	defer irows.Close()
+	for irows.Next() {
+		var userID string
+		var id model.Identity
+		if err := irows.Scan(&userID, &id.Provider, &id.Subject); err != nil {
+			return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+		}
+		if u, ok := byID[userID]; ok {
+			u.Identities = append(u.Identities, &id)
+		}
+	}
+	if err := irows.Err(); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	trows, err := d.db.QueryContext(ctx, `SELECT user_id, id, name, hash, created_utc FROM api_tokens ORDER BY user_id, created_utc, id`)
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	defer trows.Close()
+	for trows.Next() {
+		var userID string
+		var t model.APIToken
+		var created int64
+		if err := trows.Scan(&userID, &t.ID, &t.Name, &t.Hash, &created); err != nil {
+			return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+		}
+		t.CreatedUTC = time.Unix(created, 0).In(time.UTC)
+		if u, ok := byID[userID]; ok {
+			u.Tokens = append(u.Tokens, &t)
+		}
+	}
+	if err := trows.Err(); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	return ret, nil
+}
+
+// SQLiteActivityDB is an ActivityDB stores data in a SQLite database file.
+// Cannot be read from multiple app instances.
+type SQLiteActivityDB struct {
+	db *sql.DB
+}
+
+var _ ActivityDB = (*SQLiteActivityDB)(nil)
+
+// NewSQLiteActivityDB initializes a SQLiteActivityDB.
+// The file and tables are created if needed.
+func NewSQLiteActivityDB(filePath string) (*SQLiteActivityDB, error) {
+	sdb, err := openSQLite(filePath, "activities", sqliteActivityMigrations)
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBOpen, err)
+	}
+	return &SQLiteActivityDB{db: sdb}, nil
+}
+
+// Close closes the database.
+func (d *SQLiteActivityDB) Close() error {
+	if err := d.db.Close(); err != nil {
+		return goki.ErrWrap(goki.ErrDBClose, err)
+	}
+	return nil
+}
+
+// Get gets an activity of the user or error.
+func (d *SQLiteActivityDB) Get(ctx context.Context, userID, activityID string) (*model.Activity, error) {
+	var ut int64
+	var sn, mn, ln int
+	err := d.db.QueryRowContext(ctx, `SELECT time_utc, s, m, l FROM activities WHERE user_id = ? AND activity_id = ?`, userID, activityID).Scan(&ut, &sn, &mn, &ln)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, goki.ErrActivityNotFound
+	}
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	a := model.NewActivity(userID, time.Unix(ut, 0).In(time.UTC), sn, mn, ln)
+	a.ID = activityID
+	return a, nil
+}
+
+// Add adds an activity. A new ID is assigned if Activity.ID is empty.
+// This method DOES NOT validate Activity.UserID in the given activity.
+// Timestamps are stored in seconds like other ActivityDB implementations,
+// but activities with the same timestamp are stored as is.
+func (d *SQLiteActivityDB) Add(ctx context.Context, act *model.Activity) error {
+	id := act.ID
+	if id == "" {
+		id = goki.NewID()
+	}
+	_, err := d.db.ExecContext(ctx, `INSERT INTO activities (activity_id, user_id, time_utc, s, m, l) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, act.UserID, act.TimeUTC.Unix(), act.G.S, act.G.M, act.G.L)
+	if isSQLiteConstraint(err) {
+		return goki.ErrActivityAlreadyExist
+	}
+	if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return nil
+}
+
+// DeleteByUser deletes all activities of the user.
+func (d *SQLiteActivityDB) DeleteByUser(ctx context.Context, userID string) (int, error) {
+	res, err := d.db.ExecContext(ctx, `DELETE FROM activities WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return int(n), nil
+}
+
+// Import adds activities in a transaction, keeping their timestamps.
+// Returns goki.ErrActivityAlreadyExist and adds nothing if an ID is already used,
+// or if the user already has an activity at the same second, as JSONActivityDB does.
+func (d *SQLiteActivityDB) Import(ctx context.Context, acts []*model.Activity) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	defer tx.Rollback()
+	for _, act := range acts {
+		ut := act.TimeUTC.Unix()
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM activities WHERE user_id = ? AND time_utc = ?`, act.UserID, ut).Scan(&n); err != nil {
+			return goki.ErrWrap(goki.ErrDBInternal, err)
+		}
+		if n > 0 {
+			return goki.ErrActivityAlreadyExist
+		}
+		id := act.ID
+		if id == "" {
+			id = goki.NewID()
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO activities (activity_id, user_id, time_utc, s, m, l) VALUES (?, ?, ?, ?, ?, ?)`,
+			id, act.UserID, ut, act.G.S, act.G.M, act.G.L)
+		if isSQLiteConstraint(err) {
+			return goki.ErrActivityAlreadyExist
+		}
+		if err != nil {
+			return goki.ErrWrap(goki.ErrDBSave, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return goki.ErrWrap(goki.ErrDBSave, err)
+	}
+	return nil
+}
+
+// Update updates Activity.G of the activity specified by Activity.UserID and Activity.ID.
+func (d *SQLiteActivityDB) Update(ctx context.Context, act *model.Activity) error {
+	res, err := d.db.ExecContext(ctx, `UPDATE activities SET s = ?, m = ?, l = ? WHERE user_id = ? AND activity_id = ?`,
+		act.G.S, act.G.M, act.G.L, act.UserID, act.ID)
+	return sqliteAffected(res, err, goki.ErrActivityNotFound)
+}
+
+// Delete deletes an activity of the user.
+func (d *SQLiteActivityDB) Delete(ctx context.Context, userID, activityID string) error {
+	res, err := d.db.ExecContext(ctx, `DELETE FROM activities WHERE user_id = ? AND activity_id = ?`, userID, activityID)
+	return sqliteAffected(res, err, goki.ErrActivityNotFound)
+}
+
+// Query returns a slice of Activity (may be empty).
+// This method DOES NOT validate Activity.UserID in the given activity.
+// Just returns an empty slice when the given userID is invalid.
+func (d *SQLiteActivityDB) Query(ctx context.Context, q ActivityQuery) ([]*model.Activity, error) {
+	stmt := `SELECT activity_id, time_utc, s, m, l FROM activities WHERE user_id = ?`
+	args := []interface{}{q.UserID}
+	if !q.Begin.IsZero() {
+		stmt += ` AND time_utc >= ?`
+		args = append(args, ceilUnix(q.Begin))
+	}
+	if !q.End.IsZero() {
+		stmt += ` AND time_utc < ?`
+		args = append(args, ceilUnix(q.End))
+	}
+	if q.Order == OrderDesc {
+		stmt += ` ORDER BY time_utc DESC, id DESC`
+	} else {
+		stmt += ` ORDER BY time_utc, id`
+	}
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1 // no limit
+		}
+		stmt += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, q.Offset)
+	}
+	rows, err := d.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	defer rows.Close()
+	ret := []*model.Activity{}
+	for rows.Next() {
+		var id string
+		var ut int64
+		var s, m, l int
+		if err := rows.Scan(&id, &ut, &s, &m, &l); err != nil {
+			return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+		}
+		a := model.NewActivity(q.UserID, time.Unix(ut, 0).In(time.UTC), s, m, l)
+		a.ID = id
+		ret = append(ret, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	return ret, nil
+}
+
+// Sum sums roaches of the user in [begin, end) with one query.
+func (d *SQLiteActivityDB) Sum(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	stmt := `SELECT COALESCE(SUM(s), 0), COALESCE(SUM(m), 0), COALESCE(SUM(l), 0) FROM activities WHERE user_id = ?`
+	args := []interface{}{userID}
+	if !begin.IsZero() {
+		stmt += ` AND time_utc >= ?`
+		args = append(args, ceilUnix(begin))
+	}
+	if !end.IsZero() {
+		stmt += ` AND time_utc < ?`
+		args = append(args, ceilUnix(end))
+	}
+	var g model.Goki
+	if err := d.db.QueryRowContext(ctx, stmt, args...).Scan(&g.S, &g.M, &g.L); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	return &g, nil
+}
+
+// SumByUser sums roaches of each user in [begin, end) with one query.
+func (d *SQLiteActivityDB) SumByUser(ctx context.Context, begin, end time.Time) (map[string]*model.Goki, error) {
+	stmt := `SELECT user_id, SUM(s), SUM(m), SUM(l) FROM activities WHERE 1 = 1`
+	var args []interface{}
+	if !begin.IsZero() {
+		stmt += ` AND time_utc >= ?`
+		args = append(args, ceilUnix(begin))
+	}
+	if !end.IsZero() {
+		stmt += ` AND time_utc < ?`
+		args = append(args, ceilUnix(end))
+	}
+	stmt += ` GROUP BY user_id`
+	rows, err := d.db.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	defer rows.Close()
+	ret := map[string]*model.Goki{}
+	for rows.Next() {
+		var userID string
+		var g model.Goki
+		if err := rows.Scan(&userID, &g.S, &g.M, &g.L); err != nil {
+			return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+		}
+		ret[userID] = &g
+	}
+	if err := rows.Err(); err != nil {
+		return nil, goki.ErrWrap(goki.ErrDBInternal, err)
+	}
+	return ret, nil
+}
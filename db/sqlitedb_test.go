@@ -0,0 +1,257 @@
+//go:build cgo
+// +build cgo
+
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/dbtest"
+	"github.com/ebiiim/goki/model"
+)
+
+func TestNewSQLiteUserDB_Reopen(t *testing.T) {
+	var testDBPath = filepath.Join(t.TempDir(), "goki.db")
+	for i := 0; i < 2; i++ {
+		d, err := db.NewSQLiteUserDB(testDBPath)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNewSQLiteDB_SameFile(t *testing.T) {
+	var testDBPath = filepath.Join(t.TempDir(), "goki.db")
+	udb, adb, err := db.Open(db.DriverSQLite, testDBPath, testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err := udb.Add(ctx, U1); err != nil {
+		t.Error(err)
+	}
+	if err := adb.Add(ctx, A1); err != nil {
+		t.Error(err)
+	}
+	if err := adb.Close(); err != nil {
+		t.Error(err)
+	}
+	if err := udb.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteUserDB(t *testing.T) {
+	var testDBPath = filepath.Join(t.TempDir(), "goki.db")
+	d, err := db.NewSQLiteUserDB(testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	addCases := []struct {
+		name  string
+		user  *model.User
+		isErr bool
+	}{
+		{"alice", U1, false},
+		{"bob", U2, false},
+		{"F_alice2", U1, true},
+		{"F_taro_Twitter_duplicated", model.NewUser("000", "taro", "12345678"), true},
+	}
+	for _, c := range addCases {
+		c := c
+		t.Run("Add_"+c.name, func(t *testing.T) {
+			err := d.Add(ctx, c.user)
+			if c.isErr {
+				if err == nil {
+					t.Error("expected err")
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	getCases := []struct {
+		name      string
+		userID    string
+		twitterID string
+		userName  string
+		isErr     bool
+	}{
+		{"alice", U1.ID, U1.Subject(model.ProviderTwitter), U1.Name, false},
+		{"bob", U2.ID, U2.Subject(model.ProviderTwitter), U2.Name, false},
+		{"F_taro", "000", "00000000", "taro", true},
+	}
+	for _, c := range getCases {
+		c := c
+		t.Run("Get_"+c.name, func(t *testing.T) {
+			u, err := d.Get(ctx, c.userID)
+			if c.isErr {
+				if err == nil {
+					t.Error("expected err")
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if u.ID != c.userID || u.Name != c.userName || u.Subject(model.ProviderTwitter) != c.twitterID {
+				t.Error("data")
+			}
+		})
+		t.Run("GetByTwitterID_"+c.name, func(t *testing.T) {
+			u, err := d.GetByTwitterID(ctx, c.twitterID)
+			if c.isErr {
+				if err == nil {
+					t.Error("expected err")
+				}
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if u.ID != c.userID {
+				t.Error("data")
+			}
+		})
+	}
+}
+
+func TestNewSQLiteUserDB_MigrateTwitterID(t *testing.T) {
+	var testDBPath = filepath.Join(t.TempDir(), "goki.db")
+	// users schema version 2
+	sdb, err := sql.Open("sqlite3", testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE schema_versions (name TEXT PRIMARY KEY, version INTEGER NOT NULL)`,
+		`INSERT INTO schema_versions (name, version) VALUES ('users', 2)`,
+		`CREATE TABLE users (id TEXT PRIMARY KEY, name TEXT NOT NULL, twitter_id TEXT NOT NULL)`,
+		`CREATE UNIQUE INDEX users_twitter_id ON users (twitter_id)`,
+		`CREATE TABLE api_tokens (id TEXT PRIMARY KEY, user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			name TEXT NOT NULL, hash TEXT NOT NULL, created_utc INTEGER NOT NULL)`,
+		`INSERT INTO users (id, name, twitter_id) VALUES ('123', 'alice', '12345678'), ('456', 'bob', '')`,
+	} {
+		if _, err := sdb.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sdb.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.NewSQLiteUserDB(testDBPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	if u, err := d.GetByTwitterID(ctx, "12345678"); err != nil || u.ID != "123" || len(u.Identities) != 1 {
+		t.Errorf("alice: got %+v %v", u, err)
+	}
+	if u, err := d.Get(ctx, "456"); err != nil || len(u.Identities) != 0 {
+		t.Errorf("bob: got %+v %v", u, err)
+	}
+	// users without identities no longer conflict
+	if err := d.Add(ctx, model.NewUser("789", "carol", "")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSQLiteActivityDB(t *testing.T) {
+	var testDBPath = filepath.Join(t.TempDir(), "goki.db")
+	d, err := db.NewSQLiteActivityDB(testDBPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	dbtest.AddQueryData(t, d)
+	cases := []struct {
+		name    string
+		userID  string
+		queryFn func(a *model.Activity) bool
+		expNum  int
+	}{
+		{"taro_invalid_user", "000", db.QueryFuncTime(UTC202008Begin, UTC202009Begin), 0},
+		{"alice_UTC202008", U1.ID, db.QueryFuncTime(UTC202008Begin, UTC202009Begin), 3},
+		{"alice_JST202008", U1.ID, db.QueryFuncTime(JST202008Begin, JST202009Begin), 2},
+		{"alice_UTC202109", U1.ID, db.QueryFuncTime(UTC202109Begin, UTC202110Begin), 0},
+		{"alice_JST202109", U1.ID, db.QueryFuncTime(JST202109Begin, JST202110Begin), 1},
+		{"bob_UTC202008", U2.ID, db.QueryFuncTime(UTC202008Begin, UTC202009Begin), 1},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			res, err := db.QueryFunc(ctx, d, c.userID, c.queryFn)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(res) != c.expNum {
+				t.Errorf("want %v but got %v", c.expNum, len(res))
+			}
+		})
+	}
+}
+
+func TestSQLiteActivityDB_Canceled(t *testing.T) {
+	d, err := db.NewSQLiteActivityDB(filepath.Join(t.TempDir(), "goki.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := d.Add(canceled, A1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Add: want context.Canceled but got %v", err)
+	}
+	if _, err := d.Query(canceled, db.ActivityQuery{UserID: U1.ID}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Query: want context.Canceled but got %v", err)
+	}
+}
+
+func TestSQLiteUserDB_Conformance(t *testing.T) {
+	dbtest.TestUserDB(t, func(t *testing.T) db.UserDB {
+		d, err := db.NewSQLiteUserDB(filepath.Join(t.TempDir(), "goki.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
+
+func TestSQLiteActivityDB_Conformance(t *testing.T) {
+	dbtest.TestActivityDB(t, func(t *testing.T) db.ActivityDB {
+		d, err := db.NewSQLiteActivityDB(filepath.Join(t.TempDir(), "goki.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	})
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// userMap is the in-memory store shared by JSONUserDB and GCSUserDB.
+// Methods DO NOT lock mu; callers lock it while using and saving the data.
+type userMap struct {
+	// UserID -> User
+	db  map[string]*model.User
+	ids identityIndex
+	mu  sync.Mutex
+}
+
+func newUserMap() userMap {
+	return userMap{
+		db:  map[string]*model.User{},
+		ids: identityIndex{},
+	}
+}
+
+// reset replaces the data and rebuilds the index.
+func (d *userMap) reset(db map[string]*model.User) {
+	if db == nil {
+		db = map[string]*model.User{}
+	}
+	d.db = db
+	d.ids = newIdentityIndex(db)
+}
+
+func (d *userMap) get(userID string) (*model.User, error) {
+	u, ok := d.db[userID]
+	if !ok {
+		return nil, goki.ErrUserNotFound
+	}
+	var uu model.User
+	deepCopy(&uu, u)
+	return &uu, nil
+}
+
+func (d *userMap) getByIdentity(provider, subject string) (*model.User, error) {
+	if u := d.owner(provider, subject); u != nil {
+		var uu model.User
+		deepCopy(&uu, u)
+		return &uu, nil
+	}
+	return nil, goki.ErrUserNotFound
+}
+
+// owner returns the user linked to the identity or nil.
+func (d *userMap) owner(provider, subject string) *model.User {
+	if subject == "" {
+		return nil
+	}
+	if userID, ok := d.ids.get(provider, subject); ok {
+		return d.db[userID]
+	}
+	return nil
+}
+
+// identityUsed reports whether any identity of the user is linked to another user.
+func (d *userMap) identityUsed(user *model.User) bool {
+	for _, id := range user.Identities {
+		if u := d.owner(id.Provider, id.Subject); u != nil && u.ID != user.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *userMap) add(user *model.User) error {
+	if _, ok := d.db[user.ID]; ok {
+		return goki.ErrUserAlreadyExist
+	}
+	if d.identityUsed(user) {
+		return goki.ErrUserAlreadyExist
+	}
+	var u model.User
+	deepCopy(&u, user)
+	d.db[user.ID] = &u
+	d.ids.add(&u)
+	return nil
+}
+
+// update replaces the user.
+// Returns goki.ErrUserAlreadyExist if any identity is used by another user.
+func (d *userMap) update(user *model.User) error {
+	old, ok := d.db[user.ID]
+	if !ok {
+		return goki.ErrUserNotFound
+	}
+	if d.identityUsed(user) {
+		return goki.ErrUserAlreadyExist
+	}
+	var u model.User
+	deepCopy(&u, user)
+	d.db[user.ID] = &u
+	d.ids.remove(old)
+	d.ids.add(&u)
+	return nil
+}
+
+func (d *userMap) delete(userID string) error {
+	u, ok := d.db[userID]
+	if !ok {
+		return goki.ErrUserNotFound
+	}
+	delete(d.db, userID)
+	d.ids.remove(u)
+	return nil
+}
+
+func (d *userMap) list() []*model.User {
+	ret := make([]*model.User, 0, len(d.db))
+	for _, u := range d.db {
+		var uu model.User
+		deepCopy(&uu, u)
+		ret = append(ret, &uu)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
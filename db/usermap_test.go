@@ -0,0 +1,103 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/ebiiim/goki/model"
+)
+
+const benchUsers = 100000
+
+// newBenchUserMap returns an userMap of n users with Twitter IDs "tw{i}".
+func newBenchUserMap(n int) *userMap {
+	db := make(map[string]*model.User, n)
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		db[id] = model.NewUser(id, "user"+id, "tw"+id)
+	}
+	d := newUserMap()
+	d.reset(db)
+	return &d
+}
+
+// scanOwner is owner without the index, as before the index was added.
+func (d *userMap) scanOwner(provider, subject string) *model.User {
+	for _, u := range d.db {
+		if u.Subject(provider) == subject {
+			return u
+		}
+	}
+	return nil
+}
+
+func TestUserMap_Index(t *testing.T) {
+	d := newBenchUserMap(10)
+	u, err := d.getByIdentity(model.ProviderTwitter, "tw3")
+	if err != nil || u.ID != "3" {
+		t.Fatalf("got %+v %v", u, err)
+	}
+	u.Link(model.ProviderTwitter, "new")
+	if err := d.update(u); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.getByIdentity(model.ProviderTwitter, "tw3"); err == nil {
+		t.Error("the old identity is found")
+	}
+	if err := d.add(model.NewUser("10", "user10", "tw3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.delete("3"); err != nil {
+		t.Fatal(err)
+	}
+	for subject, want := range map[string]string{"new": "", "tw3": "10", "tw4": "4"} {
+		got := ""
+		if u := d.owner(model.ProviderTwitter, subject); u != nil {
+			got = u.ID
+		}
+		if got != want {
+			t.Errorf("%s: got %q want %q", subject, got, want)
+		}
+	}
+}
+
+func BenchmarkUserMap_GetByTwitterID(b *testing.B) {
+	d := newBenchUserMap(benchUsers)
+	subject := "tw" + strconv.Itoa(benchUsers/2)
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if d.owner(model.ProviderTwitter, subject) == nil {
+				b.Fatal("not found")
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if d.scanOwner(model.ProviderTwitter, subject) == nil {
+				b.Fatal("not found")
+			}
+		}
+	})
+}
+
+// BenchmarkUserMap_IdentityUsed measures the check of used identities on every Add and Update.
+func BenchmarkUserMap_IdentityUsed(b *testing.B) {
+	d := newBenchUserMap(benchUsers)
+	u := model.NewUser("new", "new", "tw-new")
+	b.Run("index", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if d.identityUsed(u) {
+				b.Fatal("used")
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, id := range u.Identities {
+				if d.scanOwner(id.Provider, id.Subject) != nil {
+					b.Fatal("used")
+				}
+			}
+		}
+	})
+}
@@ -13,13 +13,14 @@ docker build -t gcr.io/$GCP_ID/$APP_NAME:$VERSION .
 docker push gcr.io/$GCP_ID/$APP_NAME:$VERSION
 
 # deploy
-# --max-instances=1 avoids potential GCS read/write race condition
+# --max-instances=1 as reads lag behind other instances by up to 5 seconds
+# and failed logins are limited per instance, though the GCS databases never lose changes
 gcloud run deploy $APP_NAME \
   --image gcr.io/$GCP_ID/$APP_NAME:$VERSION \
   --platform managed \
   --memory=128Mi --cpu=1000m \
   --max-instances=1 \
-  --set-env-vars=GCP_ID=$GCP_ID,GCS_DB_BUCKET=$GCS_DB_BUCKET,TWITTER_CONSUMER_KEY=$TWITTER_CONSUMER_KEY,TWITTER_CONSUMER_SECRET=$TWITTER_CONSUMER_SECRET,TWITTER_CALLBACK_SERVER_NAME=$TWITTER_CALLBACK_SERVER_NAME \
+  --set-env-vars=GCP_ID=$GCP_ID,GCS_DB_BUCKET=$GCS_DB_BUCKET,TWITTER_CONSUMER_KEY=$TWITTER_CONSUMER_KEY,TWITTER_CONSUMER_SECRET=$TWITTER_CONSUMER_SECRET,TWITTER_CALLBACK_SERVER_NAME=$TWITTER_CALLBACK_SERVER_NAME,METRICS_TOKEN=$METRICS_TOKEN \
   --region=asia-northeast1 \
   --service-account=$GCP_RUN_SERVICE_ACCOUNT \
   --allow-unauthenticated
@@ -20,6 +20,20 @@ var (
 	ErrUserNotFound = errors.New("user not found")
 	// ErrUserAlreadyExist represents user already exist error.
 	ErrUserAlreadyExist = errors.New("user already exist")
+	// ErrInvalidArgument represents invalid argument error.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrInvalidCredentials represents wrong username or password error.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrTooManyAttempts represents too many failed login attempts error.
+	ErrTooManyAttempts = errors.New("too many attempts")
+	// ErrInvalidToken represents invalid API token error.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenNotFound represents API token not found error.
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrActivityNotFound represents activity not found error.
+	ErrActivityNotFound = errors.New("activity not found")
+	// ErrActivityAlreadyExist represents activity already exist error.
+	ErrActivityAlreadyExist = errors.New("activity already exist")
 )
 
 // ErrWrap returns a new error.
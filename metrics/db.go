@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/model"
+)
+
+// store label values
+const (
+	storeUser     = "user"
+	storeActivity = "activity"
+)
+
+// observeDB observes the latency of an operation started at start and counts it if it failed.
+// Not found and already exist errors are results of operations rather than failures of the backend.
+func observeDB(backend, store, method string, start time.Time, err error) {
+	DBOperationDuration.WithLabelValues(backend, store, method).Observe(time.Since(start).Seconds())
+	if err == nil ||
+		errors.Is(err, goki.ErrUserNotFound) || errors.Is(err, goki.ErrUserAlreadyExist) ||
+		errors.Is(err, goki.ErrActivityNotFound) || errors.Is(err, goki.ErrActivityAlreadyExist) {
+		return
+	}
+	DBOperationErrors.WithLabelValues(backend, store, method).Inc()
+}
+
+// InstrumentedUserDB wraps an UserDB and observes calls of it.
+type InstrumentedUserDB struct {
+	db.UserDB
+	// Backend is the label of the backend e.g. db.DriverJSON.
+	Backend string
+}
+
+var _ db.UserDB = (*InstrumentedUserDB)(nil)
+
+// InstrumentUserDB wraps the UserDB of the backend.
+func InstrumentUserDB(d db.UserDB, backend string) *InstrumentedUserDB {
+	return &InstrumentedUserDB{UserDB: d, Backend: backend}
+}
+
+// Close closes the wrapped UserDB.
+func (d *InstrumentedUserDB) Close() error {
+	start := time.Now()
+	err := d.UserDB.Close()
+	observeDB(d.Backend, storeUser, "Close", start, err)
+	return err
+}
+
+// Get calls Get of the wrapped UserDB.
+func (d *InstrumentedUserDB) Get(ctx context.Context, userID string) (*model.User, error) {
+	start := time.Now()
+	u, err := d.UserDB.Get(ctx, userID)
+	observeDB(d.Backend, storeUser, "Get", start, err)
+	return u, err
+}
+
+// GetByIdentity calls GetByIdentity of the wrapped UserDB.
+func (d *InstrumentedUserDB) GetByIdentity(ctx context.Context, provider, subject string) (*model.User, error) {
+	start := time.Now()
+	u, err := d.UserDB.GetByIdentity(ctx, provider, subject)
+	observeDB(d.Backend, storeUser, "GetByIdentity", start, err)
+	return u, err
+}
+
+// GetByTwitterID calls GetByTwitterID of the wrapped UserDB.
+func (d *InstrumentedUserDB) GetByTwitterID(ctx context.Context, twitterID string) (*model.User, error) {
+	start := time.Now()
+	u, err := d.UserDB.GetByTwitterID(ctx, twitterID)
+	observeDB(d.Backend, storeUser, "GetByTwitterID", start, err)
+	return u, err
+}
+
+// Add calls Add of the wrapped UserDB.
+func (d *InstrumentedUserDB) Add(ctx context.Context, user *model.User) error {
+	start := time.Now()
+	err := d.UserDB.Add(ctx, user)
+	observeDB(d.Backend, storeUser, "Add", start, err)
+	return err
+}
+
+// Update calls Update of the wrapped UserDB.
+func (d *InstrumentedUserDB) Update(ctx context.Context, user *model.User) error {
+	start := time.Now()
+	err := d.UserDB.Update(ctx, user)
+	observeDB(d.Backend, storeUser, "Update", start, err)
+	return err
+}
+
+// List calls List of the wrapped UserDB.
+func (d *InstrumentedUserDB) List(ctx context.Context) ([]*model.User, error) {
+	start := time.Now()
+	us, err := d.UserDB.List(ctx)
+	observeDB(d.Backend, storeUser, "List", start, err)
+	return us, err
+}
+
+// Delete calls Delete of the wrapped UserDB.
+func (d *InstrumentedUserDB) Delete(ctx context.Context, userID string) error {
+	start := time.Now()
+	err := d.UserDB.Delete(ctx, userID)
+	observeDB(d.Backend, storeUser, "Delete", start, err)
+	return err
+}
+
+// InstrumentedActivityDB wraps an ActivityDB and observes calls of it.
+type InstrumentedActivityDB struct {
+	db.ActivityDB
+	// Backend is the label of the backend e.g. db.DriverJSON.
+	Backend string
+}
+
+var _ db.ActivityDB = (*InstrumentedActivityDB)(nil)
+
+// InstrumentActivityDB wraps the ActivityDB of the backend.
+func InstrumentActivityDB(d db.ActivityDB, backend string) *InstrumentedActivityDB {
+	return &InstrumentedActivityDB{ActivityDB: d, Backend: backend}
+}
+
+// Close closes the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) Close() error {
+	start := time.Now()
+	err := d.ActivityDB.Close()
+	observeDB(d.Backend, storeActivity, "Close", start, err)
+	return err
+}
+
+// Get calls Get of the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) Get(ctx context.Context, userID, activityID string) (*model.Activity, error) {
+	start := time.Now()
+	a, err := d.ActivityDB.Get(ctx, userID, activityID)
+	observeDB(d.Backend, storeActivity, "Get", start, err)
+	return a, err
+}
+
+// Add calls Add of the wrapped ActivityDB and counts the activity if added.
+func (d *InstrumentedActivityDB) Add(ctx context.Context, activity *model.Activity) error {
+	start := time.Now()
+	err := d.ActivityDB.Add(ctx, activity)
+	observeDB(d.Backend, storeActivity, "Add", start, err)
+	if err == nil {
+		recordActivities(activity)
+	}
+	return err
+}
+
+// Import calls Import of the wrapped ActivityDB and counts the activities if imported.
+func (d *InstrumentedActivityDB) Import(ctx context.Context, activities []*model.Activity) error {
+	start := time.Now()
+	err := d.ActivityDB.Import(ctx, activities)
+	observeDB(d.Backend, storeActivity, "Import", start, err)
+	if err == nil {
+		recordActivities(activities...)
+	}
+	return err
+}
+
+// Update calls Update of the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) Update(ctx context.Context, activity *model.Activity) error {
+	start := time.Now()
+	err := d.ActivityDB.Update(ctx, activity)
+	observeDB(d.Backend, storeActivity, "Update", start, err)
+	return err
+}
+
+// Delete calls Delete of the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) Delete(ctx context.Context, userID, activityID string) error {
+	start := time.Now()
+	err := d.ActivityDB.Delete(ctx, userID, activityID)
+	observeDB(d.Backend, storeActivity, "Delete", start, err)
+	return err
+}
+
+// DeleteByUser calls DeleteByUser of the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) DeleteByUser(ctx context.Context, userID string) (int, error) {
+	start := time.Now()
+	n, err := d.ActivityDB.DeleteByUser(ctx, userID)
+	observeDB(d.Backend, storeActivity, "DeleteByUser", start, err)
+	return n, err
+}
+
+// Query calls Query of the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) Query(ctx context.Context, q db.ActivityQuery) ([]*model.Activity, error) {
+	start := time.Now()
+	acts, err := d.ActivityDB.Query(ctx, q)
+	observeDB(d.Backend, storeActivity, "Query", start, err)
+	return acts, err
+}
+
+// Sum calls Sum of the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) Sum(ctx context.Context, userID string, begin, end time.Time) (*model.Goki, error) {
+	start := time.Now()
+	g, err := d.ActivityDB.Sum(ctx, userID, begin, end)
+	observeDB(d.Backend, storeActivity, "Sum", start, err)
+	return g, err
+}
+
+// SumByUser calls SumByUser of the wrapped ActivityDB.
+func (d *InstrumentedActivityDB) SumByUser(ctx context.Context, begin, end time.Time) (map[string]*model.Goki, error) {
+	start := time.Now()
+	m, err := d.ActivityDB.SumByUser(ctx, begin, end)
+	observeDB(d.Backend, storeActivity, "SumByUser", start, err)
+	return m, err
+}
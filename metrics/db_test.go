@@ -0,0 +1,114 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/db"
+	"github.com/ebiiim/goki/db/dbtest"
+	"github.com/ebiiim/goki/db/memdb"
+	"github.com/ebiiim/goki/metrics"
+	"github.com/ebiiim/goki/model"
+)
+
+var ctx = context.Background()
+
+func TestInstrumentedUserDB(t *testing.T) {
+	dbtest.TestUserDB(t, func(t *testing.T) db.UserDB {
+		return metrics.InstrumentUserDB(memdb.NewUserDB(), "test-conformance")
+	})
+}
+
+func TestInstrumentedActivityDB(t *testing.T) {
+	dbtest.TestActivityDB(t, func(t *testing.T) db.ActivityDB {
+		return metrics.InstrumentActivityDB(memdb.NewActivityDB(), "test-conformance")
+	})
+}
+
+func TestInstrumentedUserDB_Errors(t *testing.T) {
+	const backend = "test-user-errors"
+	faulty := memdb.NewFaultyUserDB(memdb.NewUserDB())
+	d := metrics.InstrumentUserDB(faulty, backend)
+	if err := d.Add(ctx, model.NewUser("1", "user1", "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, "404"); !errors.Is(err, goki.ErrUserNotFound) {
+		t.Fatalf("got %v", err)
+	}
+	if err := d.Add(ctx, model.NewUser("1", "user1", "")); !errors.Is(err, goki.ErrUserAlreadyExist) {
+		t.Fatalf("got %v", err)
+	}
+	faulty.Faults.Set("Get", memdb.Fault{Err: errors.New("broken")})
+	for i := 0; i < 2; i++ {
+		if _, err := d.Get(ctx, "1"); err == nil {
+			t.Fatal("no error")
+		}
+	}
+
+	for method, want := range map[string]float64{"Add": 0, "Get": 2} {
+		if got := testutil.ToFloat64(metrics.DBOperationErrors.WithLabelValues(backend, "user", method)); got != want {
+			t.Errorf("errors of %s got %v want %v", method, got, want)
+		}
+	}
+	for method, want := range map[string]uint64{"Add": 2, "Get": 3} {
+		if got := sampleCount(t, metrics.DBOperationDuration.WithLabelValues(backend, "user", method)); got != want {
+			t.Errorf("calls of %s got %v want %v", method, got, want)
+		}
+	}
+}
+
+func TestInstrumentedActivityDB_Errors(t *testing.T) {
+	const backend = "test-activity-errors"
+	faulty := memdb.NewFaultyActivityDB(memdb.NewActivityDB())
+	d := metrics.InstrumentActivityDB(faulty, backend)
+	if err := d.Delete(ctx, "1", "404"); !errors.Is(err, goki.ErrActivityNotFound) {
+		t.Fatalf("got %v", err)
+	}
+	faulty.Faults.Set("Sum", memdb.Fault{Err: errors.New("broken")})
+	if _, err := d.Sum(ctx, "1", time.Time{}, time.Time{}); err == nil {
+		t.Fatal("no error")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	faulty.Faults.Set("Query", memdb.Fault{Delay: time.Hour})
+	if _, err := d.Query(ctx, db.ActivityQuery{UserID: "1"}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v", err)
+	}
+
+	for method, want := range map[string]float64{"Delete": 0, "Sum": 1, "Query": 1} {
+		if got := testutil.ToFloat64(metrics.DBOperationErrors.WithLabelValues(backend, "activity", method)); got != want {
+			t.Errorf("errors of %s got %v want %v", method, got, want)
+		}
+	}
+}
+
+func TestInstrumentedActivityDB_Activities(t *testing.T) {
+	d := metrics.InstrumentActivityDB(memdb.NewActivityDB(), "test-activities")
+	acts := testutil.ToFloat64(metrics.ActivitiesRecorded)
+	s, m, l := testutil.ToFloat64(metrics.Roaches.WithLabelValues("s")), testutil.ToFloat64(metrics.Roaches.WithLabelValues("m")), testutil.ToFloat64(metrics.Roaches.WithLabelValues("l"))
+	a1 := model.NewActivity("1", time.Now(), 1, 2, 3)
+	a1.ID = "a1"
+	if err := d.Add(ctx, a1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Add(ctx, a1); !errors.Is(err, goki.ErrActivityAlreadyExist) {
+		t.Fatalf("got %v", err)
+	}
+	if err := d.Import(ctx, []*model.Activity{model.NewActivity("2", time.Now(), 0, 0, 4)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(metrics.ActivitiesRecorded) - acts; got != 2 {
+		t.Errorf("activities got %v want 2", got)
+	}
+	for size, want := range map[string]float64{"s": s + 1, "m": m + 2, "l": l + 7} {
+		if got := testutil.ToFloat64(metrics.Roaches.WithLabelValues(size)); got != want {
+			t.Errorf("%s got %v want %v", size, got, want)
+		}
+	}
+}
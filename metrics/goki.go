@@ -0,0 +1,70 @@
+// Package metrics defines Prometheus metrics of Goki on the default registry of client_golang.
+//
+// Serve them with Handler, instrument HTTP handlers with Middleware and databases with InstrumentUserDB and InstrumentActivityDB.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ebiiim/goki/model"
+)
+
+// HTTP metrics. See Middleware.
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goki_http_requests_total",
+		Help: "Number of HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "goki_http_request_duration_seconds",
+		Help: "Latency of HTTP requests by route template and method.",
+	}, []string{"route", "method"})
+)
+
+// Database metrics. See InstrumentUserDB and InstrumentActivityDB.
+var (
+	DBOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "goki_db_operation_duration_seconds",
+		Help: "Latency of database operations by backend, store and method.",
+	}, []string{"backend", "store", "method"})
+	DBOperationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goki_db_operation_errors_total",
+		Help: "Number of failed database operations by backend, store and method. Not found and already exist are not failures.",
+	}, []string{"backend", "store", "method"})
+)
+
+// SessionErrors counts errors of the session store by operation ("get", "new" or "save").
+var SessionErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "goki_session_errors_total",
+	Help: "Number of session store errors by operation.",
+}, []string{"op"})
+
+// Business metrics counted by InstrumentedActivityDB.Add and Import.
+var (
+	ActivitiesRecorded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "goki_activities_recorded_total",
+		Help: "Number of activities recorded or imported.",
+	})
+	Roaches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "goki_roaches_total",
+		Help: "Number of roaches in recorded or imported activities by size.",
+	}, []string{"size"})
+)
+
+func init() {
+	// expose all sizes from the beginning
+	for _, size := range []string{"s", "m", "l"} {
+		Roaches.WithLabelValues(size)
+	}
+}
+
+// recordActivities counts the activities and their roaches by size.
+func recordActivities(acts ...*model.Activity) {
+	for _, a := range acts {
+		ActivitiesRecorded.Inc()
+		Roaches.WithLabelValues("s").Add(float64(a.G.S))
+		Roaches.WithLabelValues("m").Add(float64(a.G.M))
+		Roaches.WithLabelValues("l").Add(float64(a.G.L))
+	}
+}
@@ -0,0 +1,34 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/ebiiim/goki/metrics"
+)
+
+// sampleCount returns the number of observations of the histogram.
+func sampleCount(t *testing.T, o prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, name := range []string{"goki_activities_recorded_total ", `goki_roaches_total{size="s"}`, `goki_roaches_total{size="m"}`, `goki_roaches_total{size="l"}`, "go_goroutines "} {
+		if !strings.Contains(body, name) {
+			t.Errorf("%s is not exposed", name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/ebiiim/goki/metrics"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/test/activity/{id}/edit", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["id"] == "bad" {
+			http.Error(w, "bad", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	r.Handle("/metrics", metrics.Handler()).Methods(http.MethodGet)
+	r.Use(metrics.Middleware)
+
+	const route = "/test/activity/{id}/edit"
+	ok := metrics.HTTPRequests.WithLabelValues(route, http.MethodGet, "200")
+	bad := metrics.HTTPRequests.WithLabelValues(route, http.MethodGet, "400")
+	duration := metrics.HTTPRequestDuration.WithLabelValues(route, http.MethodGet)
+	okN, badN, durationN := testutil.ToFloat64(ok), testutil.ToFloat64(bad), sampleCount(t, duration)
+
+	for _, id := range []string{"1", "2", "bad"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/test/activity/"+id+"/edit", nil))
+	}
+	if got := testutil.ToFloat64(ok) - okN; got != 2 {
+		t.Errorf("200 got %v want 2", got)
+	}
+	if got := testutil.ToFloat64(bad) - badN; got != 1 {
+		t.Errorf("400 got %v want 1", got)
+	}
+	if got := sampleCount(t, duration) - durationN; got != 3 {
+		t.Errorf("duration got %v want 3", got)
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	if !strings.Contains(body, `goki_http_requests_total{code="400",method="GET",route="/test/activity/{id}/edit"} `) {
+		t.Error("the route is not exposed")
+	}
+	if !strings.Contains(body, `goki_http_request_duration_seconds_bucket{method="GET",route="/test/activity/{id}/edit",le="+Inf"} `) {
+		t.Error("the latency of the route is not exposed")
+	}
+}
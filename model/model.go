@@ -1,27 +1,122 @@
 package model
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// Identity provider names.
+const (
+	// ProviderTwitter is the identity provider name of Twitter.
+	ProviderTwitter = "twitter"
+	// ProviderLocal is the identity provider name of local accounts. The subject is the username.
+	ProviderLocal = "local"
+)
 
 // User contains user information.
 type User struct {
-	ID      string
-	Name    string
-	Twitter struct {
-		ID string
-	}
+	ID   string
+	Name string
+	// Identities are accounts of external identity providers linked to the user.
+	// At most one identity per provider.
+	Identities []*Identity `json:",omitempty"`
+	// PasswordHash is the bcrypt hash of the local account password.
+	PasswordHash string `json:",omitempty"`
+	// Public opts in to leaderboards.
+	Public bool `json:",omitempty"`
+	// TimeZone is an IANA time zone name e.g. "Asia/Tokyo". Empty means time.Local.
+	TimeZone string `json:",omitempty"`
+	// Tokens are personal API tokens.
+	Tokens []*APIToken `json:",omitempty"`
 }
 
 // NewUser initializes an User.
+// The Twitter account is linked if twitterID is not empty.
 func NewUser(id, name, twitterID string) *User {
-	return &User{
+	u := &User{
 		ID:   id,
 		Name: name,
-		Twitter: struct {
+	}
+	u.Link(ProviderTwitter, twitterID)
+	return u
+}
+
+// Subject returns the subject of the identity provider linked to the user, or "" if not linked.
+func (u *User) Subject(provider string) string {
+	for _, id := range u.Identities {
+		if id.Provider == provider {
+			return id.Subject
+		}
+	}
+	return ""
+}
+
+// Link links the account of the identity provider to the user.
+// The existing identity of the provider is replaced, or removed if subject is empty.
+func (u *User) Link(provider, subject string) {
+	for i, id := range u.Identities {
+		if id.Provider == provider {
+			if subject == "" {
+				u.Identities = append(u.Identities[:i], u.Identities[i+1:]...)
+			} else {
+				id.Subject = subject
+			}
+			return
+		}
+	}
+	if subject != "" {
+		u.Identities = append(u.Identities, &Identity{Provider: provider, Subject: subject})
+	}
+}
+
+// Location returns the time zone of the user.
+// Returns time.Local if TimeZone is empty or unknown.
+func (u *User) Location() *time.Location {
+	if u.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(u.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// UnmarshalJSON decodes an User.
+// The legacy `Twitter.ID` field is converted into an identity.
+func (u *User) UnmarshalJSON(b []byte) error {
+	type user User // no methods
+	var v struct {
+		user
+		Twitter *struct {
 			ID string
-		}{
-			ID: twitterID,
-		},
+		} `json:",omitempty"`
 	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*u = User(v.user)
+	if v.Twitter != nil && u.Subject(ProviderTwitter) == "" {
+		u.Link(ProviderTwitter, v.Twitter.ID)
+	}
+	return nil
+}
+
+// Identity is an account of an external identity provider.
+type Identity struct {
+	// Provider is the name of the identity provider, e.g. ProviderTwitter.
+	Provider string
+	// Subject identifies the account in the provider.
+	Subject string
+}
+
+// APIToken is a personal API token of an user.
+// Only the hash of the token is stored.
+type APIToken struct {
+	ID         string
+	Name       string
+	Hash       string
+	CreatedUTC time.Time
 }
 
 // Goki contains roaches.
@@ -54,8 +149,30 @@ func GokiSum(g ...*Goki) *Goki {
 	return ret
 }
 
+// Weights are points of each roach size used to score roaches.
+type Weights struct {
+	S int
+	M int
+	L int
+}
+
+// DefaultWeights scores every roach as one point, i.e. the score is the total number of roaches.
+var DefaultWeights = Weights{S: 1, M: 1, L: 1}
+
+// Valid reports whether all weights are non-negative and at least one is positive.
+func (w Weights) Valid() bool {
+	return w.S >= 0 && w.M >= 0 && w.L >= 0 && w.S+w.M+w.L > 0
+}
+
+// Score returns the weighted sum of roaches.
+func (g *Goki) Score(w Weights) int {
+	return g.S*w.S + g.M*w.M + g.L*w.L
+}
+
 // Activity contains an activity.
 type Activity struct {
+	// ID is assigned by ActivityDB if empty.
+	ID      string
 	UserID  string
 	TimeUTC time.Time
 	// The number of roaches eliminated by this activity.
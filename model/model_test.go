@@ -1,7 +1,9 @@
 package model_test
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/ebiiim/goki/model"
 )
@@ -42,3 +44,105 @@ func TestGokiSum_3(t *testing.T) {
 		t.Error("err")
 	}
 }
+
+func TestGoki_Score(t *testing.T) {
+	cases := []struct {
+		name string
+		g    *model.Goki
+		w    model.Weights
+		exp  int
+	}{
+		{"zero", model.NewGoki(0, 0, 0), model.Weights{S: 1, M: 2, L: 5}, 0},
+		{"default", model.NewGoki(1, 2, 3), model.DefaultWeights, 6},
+		{"weighted", model.NewGoki(1, 2, 3), model.Weights{S: 1, M: 2, L: 5}, 20},
+		{"ignore_s", model.NewGoki(10, 2, 3), model.Weights{S: 0, M: 2, L: 5}, 19},
+		{"sum", model.GokiSum(model.NewGoki(1, 0, 0), model.NewGoki(0, 1, 1)), model.Weights{S: 1, M: 2, L: 5}, 8},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.g.Score(c.w); got != c.exp {
+				t.Errorf("want %v but got %v", c.exp, got)
+			}
+		})
+	}
+}
+
+func TestWeights_Valid(t *testing.T) {
+	cases := []struct {
+		w   model.Weights
+		exp bool
+	}{
+		{model.DefaultWeights, true},
+		{model.Weights{S: 1, M: 2, L: 5}, true},
+		{model.Weights{L: 1}, true},
+		{model.Weights{}, false},
+		{model.Weights{S: -1, M: 2, L: 5}, false},
+	}
+	for _, c := range cases {
+		if got := c.w.Valid(); got != c.exp {
+			t.Errorf("%+v: want %v but got %v", c.w, c.exp, got)
+		}
+	}
+}
+
+func TestUser_Link(t *testing.T) {
+	u := model.NewUser("123", "alice", "")
+	if len(u.Identities) != 0 {
+		t.Error("empty twitterID must not be linked")
+	}
+	u.Link("corp", "a1")
+	u.Link(model.ProviderTwitter, "12345678")
+	u.Link("corp", "a2")
+	if len(u.Identities) != 2 || u.Subject("corp") != "a2" || u.Subject(model.ProviderTwitter) != "12345678" {
+		t.Errorf("got %+v", u.Identities)
+	}
+	u.Link("corp", "")
+	if len(u.Identities) != 1 || u.Subject("corp") != "" {
+		t.Errorf("unlink: got %+v", u.Identities)
+	}
+}
+
+func TestUser_UnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		name    string
+		in      string
+		twitter string
+		num     int
+	}{
+		{"legacy", `{"ID":"123","Name":"alice","Twitter":{"ID":"12345678"}}`, "12345678", 1},
+		{"legacy_empty", `{"ID":"123","Name":"alice","Twitter":{"ID":""}}`, "", 0},
+		{"identities", `{"ID":"123","Name":"alice","Identities":[{"Provider":"twitter","Subject":"12345678"},{"Provider":"corp","Subject":"a1"}]}`, "12345678", 2},
+		{"both", `{"ID":"123","Name":"alice","Twitter":{"ID":"1"},"Identities":[{"Provider":"twitter","Subject":"12345678"}]}`, "12345678", 1},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			var u model.User
+			if err := json.Unmarshal([]byte(c.in), &u); err != nil {
+				t.Fatal(err)
+			}
+			if u.ID != "123" || u.Name != "alice" || u.Subject(model.ProviderTwitter) != c.twitter || len(u.Identities) != c.num {
+				t.Errorf("got %+v", u)
+			}
+		})
+	}
+}
+
+func TestUser_Location(t *testing.T) {
+	cases := []struct {
+		tz  string
+		exp string
+	}{
+		{"", time.Local.String()},
+		{"Asia/Tokyo", "Asia/Tokyo"},
+		{"UTC", "UTC"},
+		{"Mars/Olympus", time.Local.String()},
+	}
+	for _, c := range cases {
+		u := &model.User{TimeZone: c.tz}
+		if got := u.Location().String(); got != c.exp {
+			t.Errorf("%q: want %v but got %v", c.tz, c.exp, got)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+// Package oidc implements the OpenID Connect authorization code flow for login.
+//
+// The ID token is received directly from the token endpoint over TLS, so its
+// signature is not verified and TLS server validation is used instead
+// (OpenID Connect Core 1.0, section 3.1.3.7). Therefore the token endpoint must be HTTPS.
+// PKCE and nonce protect the flow against code injection and replay.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/ebiiim/goki"
+)
+
+// ErrInvalidResponse represents an unexpected response from the provider or the user agent.
+var ErrInvalidResponse = errors.New("invalid OpenID Connect response")
+
+// Config configures a Provider.
+type Config struct {
+	// Issuer is the issuer URL. `{Issuer}/.well-known/openid-configuration` must exist.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback URL registered to the provider.
+	RedirectURL string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+	// HTTPClient is used to access the provider. (default http.DefaultClient)
+	HTTPClient *http.Client
+}
+
+// Provider is an OpenID Connect identity provider.
+// Endpoints are discovered on first use.
+type Provider struct {
+	cfg Config
+
+	mu     sync.Mutex
+	oauth2 *oauth2.Config // nil until discovered
+}
+
+// NewProvider initializes a Provider.
+func NewProvider(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("%w: issuer, client ID and redirect URL are required", goki.ErrInvalidArgument)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+	return &Provider{cfg: cfg}, nil
+}
+
+// discovery is the subset of the provider metadata.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+// config returns the OAuth2 config, fetching the provider metadata if needed.
+func (p *Provider) config(ctx context.Context) (*oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth2 != nil {
+		return p.oauth2, nil
+	}
+	u := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not get provider metadata: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: provider metadata: %s", ErrInvalidResponse, res.Status)
+	}
+	var d discovery
+	if err := json.NewDecoder(res.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("%w: provider metadata: %v", ErrInvalidResponse, err)
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("%w: issuer %q does not match %q", ErrInvalidResponse, d.Issuer, p.cfg.Issuer)
+	}
+	if tu, err := url.Parse(d.TokenEndpoint); err != nil || tu.Scheme != "https" || d.AuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("%w: endpoints must be set and token endpoint must be https", ErrInvalidResponse)
+	}
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  d.AuthorizationEndpoint,
+			TokenURL: d.TokenEndpoint,
+		},
+		RedirectURL: p.cfg.RedirectURL,
+		Scopes:      append([]string{"openid"}, p.cfg.Scopes...),
+	}
+	return p.oauth2, nil
+}
+
+// AuthRequest holds secrets of a login attempt.
+// Keep it in the session between AuthCodeURL and Exchange.
+type AuthRequest struct {
+	State    string
+	Nonce    string
+	Verifier string // PKCE code verifier
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewAuthRequest generates a new AuthRequest.
+func NewAuthRequest() (*AuthRequest, error) {
+	var ar AuthRequest
+	for _, p := range []*string{&ar.State, &ar.Nonce, &ar.Verifier} {
+		s, err := randomString()
+		if err != nil {
+			return nil, err
+		}
+		*p = s
+	}
+	return &ar, nil
+}
+
+// AuthCodeURL returns the URL of the provider's login page.
+func (p *Provider) AuthCodeURL(ctx context.Context, ar *AuthRequest) (string, error) {
+	c, err := p.config(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(ar.Verifier))
+	return c.AuthCodeURL(ar.State,
+		oauth2.SetAuthURLParam("nonce", ar.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+// Claims are claims of an ID token.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+	Email             string   `json:"email"`
+}
+
+// DisplayName returns the first non-empty one of name, preferred_username and email.
+func (c *Claims) DisplayName() string {
+	for _, s := range []string{c.Name, c.PreferredUsername, c.Email} {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// audience is a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Exchange verifies the callback and exchanges the code for the ID token claims.
+// state and code are the query parameters of the callback.
+func (p *Provider) Exchange(ctx context.Context, ar *AuthRequest, state, code string) (*Claims, error) {
+	if ar == nil || state == "" || state != ar.State {
+		return nil, fmt.Errorf("%w: state mismatch", ErrInvalidResponse)
+	}
+	if code == "" {
+		return nil, fmt.Errorf("%w: no code", ErrInvalidResponse)
+	}
+	c, err := p.config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.cfg.HTTPClient)
+	tok, err := c.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", ar.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange code: %w", err)
+	}
+	raw, _ := tok.Extra("id_token").(string)
+	claims, err := parseIDToken(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case claims.Issuer != p.cfg.Issuer:
+		return nil, fmt.Errorf("%w: ID token issuer %q", ErrInvalidResponse, claims.Issuer)
+	case !claims.Audience.contains(p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: ID token audience %v", ErrInvalidResponse, claims.Audience)
+	case claims.Expiry <= goki.TimeNow().Unix():
+		return nil, fmt.Errorf("%w: ID token expired at %v", ErrInvalidResponse, time.Unix(claims.Expiry, 0))
+	case claims.Nonce != ar.Nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidResponse)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidResponse)
+	}
+	return claims, nil
+}
+
+// parseIDToken decodes the payload of a JWT without verifying the signature.
+func parseIDToken(raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed ID token", ErrInvalidResponse)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: ID token payload: %v", ErrInvalidResponse, err)
+	}
+	var c Claims
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%w: ID token payload: %v", ErrInvalidResponse, err)
+	}
+	return &c, nil
+}
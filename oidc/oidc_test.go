@@ -0,0 +1,205 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ebiiim/goki/oidc"
+)
+
+const (
+	testClientID = "goki"
+	testCode     = "the-code"
+)
+
+// fakeIdP is a minimal OpenID Connect provider.
+type fakeIdP struct {
+	*httptest.Server
+	// claims returns ID token claims for the nonce.
+	claims func(nonce string) map[string]interface{}
+	// nonce and PKCE challenge of the last auth request
+	nonce     string
+	challenge string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+	f := &fakeIdP{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/auth",
+			"token_endpoint":         f.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		v := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != testCode || base64.RawURLEncoding.EncodeToString(v[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		payload, _ := json.Marshal(f.claims(f.nonce))
+		idToken := "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	f.Server = httptest.NewTLSServer(mux)
+	f.claims = func(nonce string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   f.URL,
+			"sub":   "alice@corp",
+			"aud":   testClientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": nonce,
+			"name":  "alice",
+		}
+	}
+	return f
+}
+
+// authorize simulates the user agent visiting the login page.
+func (f *fakeIdP) authorize(t *testing.T, p *oidc.Provider, ar *oidc.AuthRequest) {
+	t.Helper()
+	u, err := p.AuthCodeURL(context.Background(), ar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pu, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := pu.Query()
+	if pu.Path != "/auth" || q.Get("state") != ar.State || q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("AuthCodeURL: %s", u)
+	}
+	f.nonce = q.Get("nonce")
+	f.challenge = q.Get("code_challenge")
+}
+
+func newTestProvider(t *testing.T, f *fakeIdP) *oidc.Provider {
+	t.Helper()
+	p, err := oidc.NewProvider(oidc.Config{
+		Issuer:      f.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://goki.example.com/login/corp/callback",
+		HTTPClient:  f.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	f := newFakeIdP(t)
+	defer f.Close()
+	p := newTestProvider(t, f)
+	ar, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.authorize(t, p, ar)
+	c, err := p.Exchange(context.Background(), ar, ar.State, testCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Subject != "alice@corp" || c.DisplayName() != "alice" {
+		t.Errorf("got %+v", c)
+	}
+}
+
+func TestProvider_Exchange_Invalid(t *testing.T) {
+	cases := []struct {
+		name   string
+		state  string // "" uses the valid state
+		code   string
+		modify func(m map[string]interface{})
+	}{
+		{"state_mismatch", "other", testCode, nil},
+		{"code_invalid", "", "wrong", nil},
+		{"issuer", "", testCode, func(m map[string]interface{}) { m["iss"] = "https://evil.example.com" }},
+		{"audience", "", testCode, func(m map[string]interface{}) { m["aud"] = []string{"other"} }},
+		{"expired", "", testCode, func(m map[string]interface{}) { m["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"nonce", "", testCode, func(m map[string]interface{}) { m["nonce"] = "replayed" }},
+		{"no_subject", "", testCode, func(m map[string]interface{}) { delete(m, "sub") }},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			f := newFakeIdP(t)
+			defer f.Close()
+			valid := f.claims
+			f.claims = func(nonce string) map[string]interface{} {
+				m := valid(nonce)
+				if c.modify != nil {
+					c.modify(m)
+				}
+				return m
+			}
+			p := newTestProvider(t, f)
+			ar, _ := oidc.NewAuthRequest()
+			f.authorize(t, p, ar)
+			state := c.state
+			if state == "" {
+				state = ar.State
+			}
+			claims, err := p.Exchange(context.Background(), ar, state, c.code)
+			if err == nil {
+				t.Errorf("expected err but got %+v", claims)
+			}
+			if c.code == testCode && !errors.Is(err, oidc.ErrInvalidResponse) {
+				t.Errorf("expected ErrInvalidResponse but got %v", err)
+			}
+		})
+	}
+}
+
+func TestProvider_AudienceArray(t *testing.T) {
+	f := newFakeIdP(t)
+	defer f.Close()
+	valid := f.claims
+	f.claims = func(nonce string) map[string]interface{} {
+		m := valid(nonce)
+		m["aud"] = []string{"other", testClientID}
+		return m
+	}
+	p := newTestProvider(t, f)
+	ar, _ := oidc.NewAuthRequest()
+	f.authorize(t, p, ar)
+	if _, err := p.Exchange(context.Background(), ar, ar.State, testCode); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProvider_Discovery_Invalid(t *testing.T) {
+	f := newFakeIdP(t)
+	defer f.Close()
+	p, err := oidc.NewProvider(oidc.Config{
+		Issuer:      f.URL + "/other", // no provider metadata
+		ClientID:    testClientID,
+		RedirectURL: "https://goki.example.com/login/corp/callback",
+		HTTPClient:  f.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ar, _ := oidc.NewAuthRequest()
+	if _, err := p.AuthCodeURL(context.Background(), ar); err == nil {
+		t.Error("expected err")
+	}
+}
@@ -0,0 +1,382 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/model"
+)
+
+var pathAPI = path.Join(pathBase, "api/v1")
+
+// API defaults and limits.
+const (
+	apiDefaultPerPage = 20
+	apiMaxPerPage     = 100
+)
+
+// apiGoki is the JSON representation of model.Goki.
+type apiGoki struct {
+	S int `json:"s"`
+	M int `json:"m"`
+	L int `json:"l"`
+}
+
+func newAPIGoki(g *model.Goki) apiGoki {
+	return apiGoki{S: g.S, M: g.M, L: g.L}
+}
+
+// apiActivity is the JSON representation of model.Activity.
+type apiActivity struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	G    apiGoki   `json:"g"`
+}
+
+func newAPIActivity(a *model.Activity, loc *time.Location) apiActivity {
+	return apiActivity{ID: a.ID, Time: a.TimeUTC.In(loc), G: newAPIGoki(a.G)}
+}
+
+// apiUser is the JSON representation of model.User.
+type apiUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// apiCount is the response of count endpoints.
+type apiCount struct {
+	Begin time.Time `json:"begin"`
+	End   time.Time `json:"end"`
+	G     apiGoki   `json:"g"`
+}
+
+// apiBucket is the JSON representation of app.Bucket.
+type apiBucket struct {
+	// Label is "2006-01-02" for days, "2006-W01" for ISO weeks and "2006-01" for months.
+	Label string    `json:"label"`
+	Begin time.Time `json:"begin"`
+	End   time.Time `json:"end"`
+	G     apiGoki   `json:"g"`
+}
+
+func newAPIBucket(b *app.Bucket, gran app.Granularity) apiBucket {
+	var label string
+	switch gran {
+	case app.Week:
+		y, w := b.Begin.ISOWeek()
+		label = fmt.Sprintf("%04d-W%02d", y, w)
+	case app.Month:
+		label = b.Begin.Format("2006-01")
+	default:
+		label = b.Begin.Format("2006-01-02")
+	}
+	return apiBucket{Label: label, Begin: b.Begin, End: b.End, G: newAPIGoki(b.G)}
+}
+
+// apiError is the response on errors.
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// registerAPI registers /api/v1 routes.
+func (s *Server) registerAPI(r *mux.Router) {
+	api := r.PathPrefix(pathAPI).Subrouter()
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	})
+	auth := func(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+		return s.checkToken(s.checkLogin(s.apiLoginRequired(next)))
+	}
+	api.HandleFunc("/me", auth(s.apiGetMe)).Methods(http.MethodGet)
+	api.HandleFunc("/activities", auth(s.apiListActivities)).Methods(http.MethodGet)
+	api.HandleFunc("/activities", auth(s.apiPostActivity)).Methods(http.MethodPost)
+	api.HandleFunc("/counts/year/{year:[0-9]+}", auth(s.apiCountByYear)).Methods(http.MethodGet)
+	api.HandleFunc("/counts/month/{year:[0-9]+}/{month:[0-9]+}", auth(s.apiCountByMonth)).Methods(http.MethodGet)
+	api.HandleFunc("/counts/range", auth(s.apiCountByRange)).Methods(http.MethodGet)
+	api.HandleFunc("/series/{granularity}", auth(s.apiSeries)).Methods(http.MethodGet)
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		Log.E("writeAPIJSON: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	var e apiError
+	e.Error.Status = status
+	e.Error.Message = err.Error()
+	writeAPIJSON(w, status, e)
+}
+
+// apiErrorStatus maps sentinel errors to HTTP status codes.
+func apiErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, goki.ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, goki.ErrInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, goki.ErrUserNotFound), errors.Is(err, goki.ErrTokenNotFound), errors.Is(err, goki.ErrActivityNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, goki.ErrUserAlreadyExist), errors.Is(err, goki.ErrActivityAlreadyExist):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// apiLoginRequired middleware returns 401 to unauthenticated users.
+func (s *Server) apiLoginRequired(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := r.Context().Value(ctxLoginUser).(*model.User)
+		if !ok || u == nil {
+			writeAPIError(w, http.StatusUnauthorized, errors.New("login required"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// apiLocation returns the location specified by the `tz` query parameter or the time zone of the user.
+func apiLocation(r *http.Request, u *model.User) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return u.Location(), nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("%w: tz: %v", goki.ErrInvalidArgument, err)
+	}
+	return loc, nil
+}
+
+// apiTimeParam parses an RFC 3339 query parameter. Returns the zero value if empty.
+func apiTimeParam(r *http.Request, key string) (time.Time, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s: %v", goki.ErrInvalidArgument, key, err)
+	}
+	return t, nil
+}
+
+// apiIntParam parses an integer query parameter. Returns def if empty.
+func apiIntParam(r *http.Request, key string, def int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s: %v", goki.ErrInvalidArgument, key, err)
+	}
+	return n, nil
+}
+
+// apiGetMe returns the current user.
+// GET /api/v1/me
+func (s *Server) apiGetMe(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	writeAPIJSON(w, http.StatusOK, apiUser{ID: u.ID, Name: u.Name, TimeZone: u.TimeZone})
+}
+
+// apiPostActivity records an activity.
+// POST /api/v1/activities {"s": 1, "m": 0, "l": 0}
+func (s *Server) apiPostActivity(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc, err := apiLocation(r, u)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	var req apiGoki
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		err = fmt.Errorf("%w: %v", goki.ErrInvalidArgument, err)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	if err := app.ValidateGoki(req.S, req.M, req.L); err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	act, err := s.A.Action(r.Context(), u, req.S, req.M, req.L)
+	if err != nil {
+		Log.I("apiPostActivity: could not Action: %v", err)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	writeAPIJSON(w, http.StatusCreated, newAPIActivity(act, loc))
+}
+
+// apiListActivities lists activities newest first.
+// GET /api/v1/activities?begin=RFC3339&end=RFC3339&page=1&per_page=20&tz=Asia/Tokyo
+func (s *Server) apiListActivities(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc, err := apiLocation(r, u)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	begin, errB := apiTimeParam(r, "begin")
+	end, errE := apiTimeParam(r, "end")
+	page, errP := apiIntParam(r, "page", 1)
+	perPage, errPP := apiIntParam(r, "per_page", apiDefaultPerPage)
+	for _, err := range []error{errB, errE, errP, errPP} {
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+	}
+	if page < 1 || perPage < 1 || perPage > apiMaxPerPage {
+		err := fmt.Errorf("%w: page must be >= 1 and per_page must be in [1, %d]", goki.ErrInvalidArgument, apiMaxPerPage)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	acts, hasNext, err := s.A.History(r.Context(), u.ID, begin, end, page, perPage)
+	if err != nil {
+		Log.I("apiListActivities: could not History: %v", err)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	res := struct {
+		Activities []apiActivity `json:"activities"`
+		Page       int           `json:"page"`
+		HasNext    bool          `json:"has_next"`
+	}{
+		Activities: make([]apiActivity, len(acts)),
+		Page:       page,
+		HasNext:    hasNext,
+	}
+	for i, a := range acts {
+		res.Activities[i] = newAPIActivity(a, loc)
+	}
+	writeAPIJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) apiWriteCount(w http.ResponseWriter, r *http.Request, userID string, begin, end time.Time) {
+	g, err := s.A.CountByRange(r.Context(), userID, begin, end)
+	if err != nil {
+		Log.I("apiWriteCount: could not CountByRange: %v", err)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, apiCount{Begin: begin, End: end, G: newAPIGoki(g)})
+}
+
+// apiCountByYear counts roaches in the year.
+// GET /api/v1/counts/year/{year}?tz=Asia/Tokyo
+func (s *Server) apiCountByYear(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc, err := apiLocation(r, u)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	year, _ := strconv.Atoi(mux.Vars(r)["year"]) // validated by the route
+	begin, end := app.YearRange(year, loc)
+	s.apiWriteCount(w, r, u.ID, begin, end)
+}
+
+// apiCountByMonth counts roaches in the month.
+// GET /api/v1/counts/month/{year}/{month}?tz=Asia/Tokyo
+func (s *Server) apiCountByMonth(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc, err := apiLocation(r, u)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	year, _ := strconv.Atoi(mux.Vars(r)["year"]) // validated by the route
+	month, _ := strconv.Atoi(mux.Vars(r)["month"])
+	if month < 1 || month > 12 {
+		err := fmt.Errorf("%w: month must be in [1, 12]", goki.ErrInvalidArgument)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	begin, end := app.MonthRange(year, time.Month(month), loc)
+	s.apiWriteCount(w, r, u.ID, begin, end)
+}
+
+// apiCountByRange counts roaches in [begin, end).
+// GET /api/v1/counts/range?begin=RFC3339&end=RFC3339
+func (s *Server) apiCountByRange(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	begin, errB := apiTimeParam(r, "begin")
+	end, errE := apiTimeParam(r, "end")
+	for _, err := range []error{errB, errE} {
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+	}
+	if begin.IsZero() || end.IsZero() || !begin.Before(end) {
+		err := fmt.Errorf("%w: begin and end are required and begin must be before end", goki.ErrInvalidArgument)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	s.apiWriteCount(w, r, u.ID, begin, end)
+}
+
+// apiSeries returns counts per day, ISO week or month including empty buckets.
+// GET /api/v1/series/{day|week|month}?begin=RFC3339&end=RFC3339&tz=Asia/Tokyo
+func (s *Server) apiSeries(w http.ResponseWriter, r *http.Request) {
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc, err := apiLocation(r, u)
+	if err != nil {
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	gran := app.Granularity(mux.Vars(r)["granularity"])
+	if !gran.Valid() {
+		writeAPIError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	begin, errB := apiTimeParam(r, "begin")
+	end, errE := apiTimeParam(r, "end")
+	for _, err := range []error{errB, errE} {
+		if err != nil {
+			writeAPIError(w, apiErrorStatus(err), err)
+			return
+		}
+	}
+	bs, err := s.A.Series(r.Context(), u.ID, begin, end, gran, loc)
+	if err != nil {
+		Log.I("apiSeries: could not Series: %v", err)
+		writeAPIError(w, apiErrorStatus(err), err)
+		return
+	}
+	res := struct {
+		Granularity app.Granularity `json:"granularity"`
+		Buckets     []apiBucket     `json:"buckets"`
+	}{
+		Granularity: gran,
+		Buckets:     make([]apiBucket, len(bs)),
+	}
+	for i, b := range bs {
+		res.Buckets[i] = newAPIBucket(b, gran)
+	}
+	writeAPIJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/chart"
+	"github.com/ebiiim/goki/model"
+)
+
+// Year over year charts compare chartDefaultYears years up to chartMaxYears.
+const (
+	chartDefaultYears = 3
+	chartMaxYears     = 5
+)
+
+// chart colors of roach sizes, and of years from the latest
+var (
+	chartColorS     = "#20c997"
+	chartColorM     = "#fd7e14"
+	chartColorL     = "#dc3545"
+	chartColorYears = []string{"#007bff", "#6f42c1", "#6c757d", "#adb5bd", "#ced4da"}
+)
+
+var chartMonthLabels = []string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"}
+
+// chartUser returns the login user or writes 401.
+func chartUser(w http.ResponseWriter, r *http.Request) (*model.User, bool) {
+	u, ok := r.Context().Value(ctxLoginUser).(*model.User)
+	if !ok || u == nil {
+		http.Error(w, "login required", http.StatusUnauthorized)
+		return nil, false
+	}
+	return u, true
+}
+
+// chartYear returns the `year` query parameter or this year in the location.
+func chartYear(r *http.Request, loc *time.Location) (int, error) {
+	v := r.URL.Query().Get("year")
+	if v == "" {
+		return goki.TimeNow().In(loc).Year(), nil
+	}
+	year, err := strconv.Atoi(v)
+	if err != nil || year < 1 || year > 9999 {
+		return 0, fmt.Errorf("%w: year", goki.ErrInvalidArgument)
+	}
+	return year, nil
+}
+
+// writeSVG writes the chart. Charts are cached by the browser for a minute.
+func writeSVG(w http.ResponseWriter, c interface{ WriteSVG(w io.Writer) error }) {
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if err := c.WriteSVG(w); err != nil {
+		Log.E("writeSVG: %v", err)
+	}
+}
+
+// monthlySeries counts roaches of the user per month of the year.
+func (s *Server) monthlySeries(ctx context.Context, userID string, year int, loc *time.Location) ([]*app.Bucket, error) {
+	begin, end := app.YearRange(year, loc)
+	return s.A.Series(ctx, userID, begin, end, app.Month, loc)
+}
+
+// serveChartMonthly draws roaches per month of the year by size as a stacked bar chart.
+// - Query parameter `year` defaults to this year.
+// - Login with the session or an API token is required: 401
+func (s *Server) serveChartMonthly(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveChartMonthly")
+
+	u, ok := chartUser(w, r)
+	if !ok {
+		return
+	}
+	loc := u.Location()
+	year, err := chartYear(r, loc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bs, err := s.monthlySeries(r.Context(), u.ID, year, loc)
+	if err != nil {
+		Log.I("serveChartMonthly: could not Series")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sS := chart.Series{Name: "小型", Color: chartColorS}
+	sM := chart.Series{Name: "中型", Color: chartColorM}
+	sL := chart.Series{Name: "大型", Color: chartColorL}
+	for _, b := range bs {
+		sS.Values = append(sS.Values, b.G.S)
+		sM.Values = append(sM.Values, b.G.M)
+		sL.Values = append(sL.Values, b.G.L)
+	}
+	writeSVG(w, &chart.BarChart{
+		Chart: chart.Chart{
+			Title:  fmt.Sprintf("%d 年の月別の戦果", year),
+			Labels: chartMonthLabels,
+			Series: []chart.Series{sS, sM, sL},
+		},
+		Stacked: true,
+	})
+}
+
+// serveChartYears compares roaches per month of recent years as a line chart.
+// - Query parameter `year` is the latest year and defaults to this year.
+// - Query parameter `years` is the number of years to compare. (default 3, max 5)
+// - Login with the session or an API token is required: 401
+func (s *Server) serveChartYears(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveChartYears")
+
+	u, ok := chartUser(w, r)
+	if !ok {
+		return
+	}
+	loc := u.Location()
+	year, err := chartYear(r, loc)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	years := chartDefaultYears
+	if v := r.URL.Query().Get("years"); v != "" {
+		years, err = strconv.Atoi(v)
+		if err != nil || years < 1 || years > chartMaxYears {
+			http.Error(w, fmt.Sprintf("years must be in [1, %d]", chartMaxYears), http.StatusBadRequest)
+			return
+		}
+	}
+	var ss []chart.Series
+	for i := years - 1; i >= 0; i-- {
+		bs, err := s.monthlySeries(r.Context(), u.ID, year-i, loc)
+		if err != nil {
+			Log.I("serveChartYears: could not Series")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sr := chart.Series{Name: fmt.Sprintf("%d 年", year-i), Color: chartColorYears[i]}
+		for _, b := range bs {
+			sr.Values = append(sr.Values, b.G.S+b.G.M+b.G.L)
+		}
+		ss = append(ss, sr)
+	}
+	writeSVG(w, &chart.LineChart{
+		Chart: chart.Chart{
+			Title:  "月別の戦果の比較",
+			Labels: chartMonthLabels,
+			Series: ss,
+		},
+	})
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/model"
+)
+
+// serveExport downloads all activities of the user.
+// - Query parameter `format` is "csv" (default) or "json".
+// - Times are in the time zone of the user.
+func (s *Server) serveExport(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveExport")
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	format := app.Format(r.URL.Query().Get("format"))
+	if format == "" {
+		format = app.FormatCSV
+	}
+	if !format.Valid() {
+		http.Error(w, "format must be csv or json", http.StatusBadRequest)
+		return
+	}
+	filename := fmt.Sprintf("goki-%s.%s", goki.TimeNow().In(u.Location()).Format("20060102"), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	if err := s.A.ExportActivities(r.Context(), w, u.ID, format, u.Location()); err != nil {
+		// the status may be already sent
+		Log.E("serveExport: could not ExportActivities: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/model"
+)
+
+// names used in import.html
+const (
+	formImportFile   = "file"
+	formImportDryRun = "dry_run"
+)
+
+// Uploaded files are up to importMaxBytes and up to importMaxErrors row errors are shown.
+const (
+	importMaxBytes  = 2 << 20
+	importMaxErrors = 100
+)
+
+// serveImport handles the import page.
+// - GET: show the form.
+// - POST: import the uploaded file. Files named *.json are JSON and others are CSV.
+//   - (A) Success: show the result including row errors
+//   - (B) Broken or too large file: 400 with the form
+//   - (X) Unexpected error: 500
+func (s *Server) serveImport(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveImport")
+
+	tmplStruct := struct {
+		UserName         string
+		TimeZone         string
+		MaxRows          int
+		FormImportFile   string
+		FormImportDryRun string
+		DryRun           bool
+		Result           *app.ImportResult
+		Errors           []*app.ImportError // first importMaxErrors of Result.Errors
+		Error            string
+	}{
+		MaxRows:          app.MaxImportRows,
+		FormImportFile:   formImportFile,
+		FormImportDryRun: formImportDryRun,
+	}
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	tmplStruct.UserName = u.Name
+	tmplStruct.TimeZone = u.Location().String()
+
+	if r.Method == http.MethodPost {
+		res, err := s.importFile(w, r, u)
+		switch {
+		case err == nil:
+			tmplStruct.DryRun = r.PostFormValue(formImportDryRun) != ""
+			tmplStruct.Result = res
+			tmplStruct.Errors = res.Errors
+			if len(res.Errors) > importMaxErrors {
+				tmplStruct.Errors = res.Errors[:importMaxErrors]
+			}
+			// (A)
+		case errors.Is(err, goki.ErrInvalidArgument):
+			w.WriteHeader(http.StatusBadRequest)
+			tmplStruct.Error = err.Error()
+			// (B)
+		default:
+			Log.I("serveImport: could not ImportActivities")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+	}
+
+	if err := s.T[tmplImport].Execute(w, tmplStruct); err != nil {
+		Log.I("serveImport: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// importFile imports the uploaded file to the user.
+// Returns an error wrapping goki.ErrInvalidArgument if the upload is invalid.
+func (s *Server) importFile(w http.ResponseWriter, r *http.Request, u *model.User) (*app.ImportResult, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+	if err := r.ParseMultipartForm(importMaxBytes); err != nil {
+		return nil, fmt.Errorf("%w: the file must be up to %d MiB", goki.ErrInvalidArgument, importMaxBytes>>20)
+	}
+	f, fh, err := r.FormFile(formImportFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no file", goki.ErrInvalidArgument)
+	}
+	defer f.Close()
+	format := app.FormatCSV
+	if strings.EqualFold(filepath.Ext(fh.Filename), ".json") {
+		format = app.FormatJSON
+	}
+	dryRun := r.PostFormValue(formImportDryRun) != ""
+	return s.A.ImportActivities(r.Context(), f, u.ID, format, u.Location(), dryRun)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/config"
+	"github.com/ebiiim/goki/model"
+)
+
+var (
+	pathLocalLogin  = path.Join(pathBase, "login")
+	pathLocalSignup = path.Join(pathBase, "signup")
+)
+
+// form names of login.html and signup.html
+const (
+	formUsername = "username"
+	formPassword = "password"
+	formName     = "name"
+)
+
+// registerLocal registers login and signup pages of local accounts if enabled.
+func (s *Server) registerLocal(r *mux.Router) {
+	if !config.Params.Local.Enabled {
+		return
+	}
+	r.HandleFunc(pathLocalLogin, s.checkLogin(s.serveLocalLogin)).Methods(http.MethodGet, http.MethodPost)
+	s.mustTmpl(tmplLogin, filepath.Join(dirTmpl, "login.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+	if config.Params.Local.Signup {
+		r.HandleFunc(pathLocalSignup, s.checkLogin(s.serveSignup)).Methods(http.MethodGet, http.MethodPost)
+		s.mustTmpl(tmplSignup, filepath.Join(dirTmpl, "signup.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+	}
+}
+
+// localFormView is the template data of login.html and signup.html.
+type localFormView struct {
+	Error                            string
+	Username, Name                   string
+	FormUsername, FormPassword       string
+	FormName                         string
+	MinPasswordLen, MaxPasswordLen   int
+	SignupURL, LoginURL, FormPOSTURL string
+}
+
+func newLocalFormView(r *http.Request) localFormView {
+	v := localFormView{
+		FormUsername:   formUsername,
+		FormPassword:   formPassword,
+		FormName:       formName,
+		MinPasswordLen: app.MinPasswordLen,
+		MaxPasswordLen: app.MaxPasswordLen,
+		LoginURL:       pathLocalLogin,
+		FormPOSTURL:    r.URL.Path,
+	}
+	if config.Params.Local.Signup {
+		v.SignupURL = pathLocalSignup
+	}
+	return v
+}
+
+// mustParseProxies parses IP addresses and CIDRs of trusted proxies. Panics if invalid.
+func mustParseProxies(proxies []string) []*net.IPNet {
+	var ret []*net.IPNet
+	for _, p := range proxies {
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			panic(fmt.Sprintf("invalid trusted proxy %q: %v", p, err))
+		}
+		ret = append(ret, n)
+	}
+	return ret
+}
+
+func (s *Server) trustedProxy(ip net.IP) bool {
+	for _, n := range s.proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the IP address of the client for rate limiting, or "" if unknown.
+// Without trusted proxies, the remote address may be a proxy shared by all clients, so it is unknown.
+// For requests from trusted proxies, X-Forwarded-For is read from the right skipping trusted proxies,
+// as each proxy appends the address it received the request from and the left part is up to the client.
+func (s *Server) clientAddr(r *http.Request) string {
+	if len(s.proxies) == 0 {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !s.trustedProxy(ip) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return "" // missing or broken
+		}
+		if !s.trustedProxy(ip) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// serveLocalLogin handles the login page of local accounts.
+// - GET: show the form. Logged in users go to /me.
+// - POST: check the password.
+//   - (A) Success: login and redirect to /me
+//   - (B) Wrong username or password: 401 with the form
+//   - (C) Too many failures: 429 with the form
+//   - (X) Unexpected error: 500
+func (s *Server) serveLocalLogin(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveLocalLogin")
+	if u, ok := r.Context().Value(ctxLoginUser).(*model.User); ok && u != nil {
+		http.Redirect(w, r, pathMe, http.StatusFound)
+		return
+	}
+	v := newLocalFormView(r)
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form value", http.StatusBadRequest)
+			return
+		}
+		v.Username = r.PostFormValue(formUsername)
+		client := s.clientAddr(r)
+		user, err := s.A.LoginWithPassword(r.Context(), v.Username, r.PostFormValue(formPassword), client)
+		switch {
+		case err == nil:
+			if err := s.login(w, r, user); err != nil {
+				Log.E("serveLocalLogin: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return // (X)
+			}
+			http.Redirect(w, r, pathMe, http.StatusFound)
+			return // (A)
+		case errors.Is(err, goki.ErrInvalidCredentials):
+			Log.I("serveLocalLogin: login failed: %s from %q via %s", app.NormalizeUsername(v.Username), client, r.RemoteAddr)
+			w.WriteHeader(http.StatusUnauthorized)
+			v.Error = "ユーザー名またはパスワードが違います"
+			// (B)
+		case errors.Is(err, goki.ErrTooManyAttempts):
+			Log.I("serveLocalLogin: too many attempts: %s from %q via %s", app.NormalizeUsername(v.Username), client, r.RemoteAddr)
+			w.WriteHeader(http.StatusTooManyRequests)
+			v.Error = "ログインの失敗が多すぎます。しばらくしてからお試しください"
+			// (C)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+	}
+	if err := s.T[tmplLogin].Execute(w, v); err != nil {
+		Log.I("serveLocalLogin: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// serveSignup handles the signup page of local accounts.
+// - GET: show the form. Logged in users go to /me.
+// - POST: create a local account.
+//   - (A) Success: login and redirect to /me
+//   - (B) Invalid or taken username, or invalid password: 400 or 409 with the form
+//   - (X) Unexpected error: 500
+func (s *Server) serveSignup(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveSignup")
+	if u, ok := r.Context().Value(ctxLoginUser).(*model.User); ok && u != nil {
+		http.Redirect(w, r, pathMe, http.StatusFound)
+		return
+	}
+	v := newLocalFormView(r)
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "invalid form value", http.StatusBadRequest)
+			return
+		}
+		v.Username = r.PostFormValue(formUsername)
+		v.Name = r.PostFormValue(formName)
+		user, err := s.A.SignUp(r.Context(), v.Username, v.Name, r.PostFormValue(formPassword))
+		switch {
+		case err == nil:
+			if err := s.login(w, r, user); err != nil {
+				Log.E("serveSignup: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return // (X)
+			}
+			http.Redirect(w, r, pathMe, http.StatusFound)
+			return // (A)
+		case errors.Is(err, goki.ErrInvalidArgument):
+			w.WriteHeader(http.StatusBadRequest)
+			v.Error = fmt.Sprintf("ユーザー名は英小文字・数字・_ の3〜32文字、パスワードは%d〜%d文字で入力してください", app.MinPasswordLen, app.MaxPasswordLen)
+			// (B)
+		case errors.Is(err, goki.ErrUserAlreadyExist):
+			w.WriteHeader(http.StatusConflict)
+			v.Error = "このユーザー名は使われています"
+			// (B)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+	}
+	if err := s.T[tmplSignup].Execute(w, v); err != nil {
+		Log.I("serveSignup: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+
+	"github.com/gorilla/mux"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/config"
+	"github.com/ebiiim/goki/metrics"
+	"github.com/ebiiim/goki/model"
+	"github.com/ebiiim/goki/oidc"
+)
+
+// session keys of oidc.AuthRequest
+const (
+	sessOIDCProvider = "provider"
+	sessOIDCState    = "state"
+	sessOIDCNonce    = "nonce"
+	sessOIDCVerifier = "verifier"
+)
+
+// oidcLoginTimeout is the max age of the session between login and callback.
+const oidcLoginTimeout = 600
+
+var oidcNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// loginView is a login button on the top page.
+type loginView struct {
+	Label string
+	URL   string
+}
+
+// registerOIDC registers login and callback routes of OpenID Connect providers in the config.
+// Panics if the config is invalid.
+func (s *Server) registerOIDC(r *mux.Router) {
+	for _, c := range config.Params.OIDC {
+		if !oidcNameRe.MatchString(c.Name) || c.Name == model.ProviderTwitter || c.Name == model.ProviderLocal {
+			panic(fmt.Sprintf("invalid OIDC provider name %q", c.Name))
+		}
+		callback, err := url.Parse(c.RedirectURL)
+		if err != nil {
+			panic(fmt.Sprintf("invalid OIDC redirect URL %q: %v", c.RedirectURL, err))
+		}
+		p, err := oidc.NewProvider(oidc.Config{
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  c.RedirectURL,
+			Scopes:       c.Scopes,
+		})
+		if err != nil {
+			panic(fmt.Sprintf("invalid OIDC provider %q: %v", c.Name, err))
+		}
+		loginPath := path.Join(pathBase, "login", c.Name)
+		r.HandleFunc(loginPath, s.oidcLogin(c.Name, p)).Methods(http.MethodGet)
+		r.HandleFunc(callback.Path, s.oidcCallback(c.Name, p)).Methods(http.MethodGet)
+		label := c.Label
+		if label == "" {
+			label = c.Name
+		}
+		s.logins = append(s.logins, loginView{Label: label, URL: loginPath})
+	}
+}
+
+// oidcLogin redirects to the login page of the provider.
+// - Keep oidc.AuthRequest in the session until the callback.
+//   - (A) Success: redirect to the provider
+//   - (X) Unexpected error: 500
+func (s *Server) oidcLogin(name string, p *oidc.Provider) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Log.D("oidcLogin: %s", name)
+		ar, err := oidc.NewAuthRequest()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+		u, err := p.AuthCodeURL(r.Context(), ar)
+		if err != nil {
+			Log.E("oidcLogin: %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+		sess, err := s.S.New(r, config.SessionOIDCName)
+		if err != nil {
+			metrics.SessionErrors.WithLabelValues("new").Inc()
+			Log.D("oidcLogin: failed to make session")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+		sess.Values[sessOIDCProvider] = name
+		sess.Values[sessOIDCState] = ar.State
+		sess.Values[sessOIDCNonce] = ar.Nonce
+		sess.Values[sessOIDCVerifier] = ar.Verifier
+		sess.Options.MaxAge = oidcLoginTimeout
+		sess.Options.Path = "/"
+		sess.Options.HttpOnly = true
+		sess.Options.SameSite = http.SameSiteLaxMode // sent on the redirect from the provider
+		if err := sess.Save(r, w); err != nil {
+			metrics.SessionErrors.WithLabelValues("save").Inc()
+			Log.E("oidcLogin: failed to save session")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+		http.Redirect(w, r, u, http.StatusFound) // (A)
+	}
+}
+
+// oidcCallback handles the OpenID Connect callback.
+// - Check the user of the provider.
+//   - (A) Error: redirect to the top page.
+//   - (B) New user: create a new Goki user and login.
+//   - (C) Known user: login with the associated Goki user.
+//   - (X) Unexpected error: 500
+func (s *Server) oidcCallback(name string, p *oidc.Provider) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Log.D("oidcCallback: %s", name)
+		sess, err := s.S.Get(r, config.SessionOIDCName)
+		if err != nil {
+			metrics.SessionErrors.WithLabelValues("get").Inc()
+		}
+		if err != nil || !valuesExist(sess, sessOIDCProvider, sessOIDCState, sessOIDCNonce, sessOIDCVerifier) {
+			Log.D("oidcCallback: no login session")
+			http.Redirect(w, r, pathTop, http.StatusFound)
+			return // (A)
+		}
+		provider, _ := sess.Values[sessOIDCProvider].(string) // already validated
+		ar := &oidc.AuthRequest{}
+		ar.State, _ = sess.Values[sessOIDCState].(string)
+		ar.Nonce, _ = sess.Values[sessOIDCNonce].(string)
+		ar.Verifier, _ = sess.Values[sessOIDCVerifier].(string)
+		// the AuthRequest is single use
+		sess.Options.MaxAge = -1
+		if err := sess.Save(r, w); err != nil {
+			metrics.SessionErrors.WithLabelValues("save").Inc()
+			Log.E("oidcCallback: error while saving session")
+		}
+		if provider != name {
+			Log.D("oidcCallback: provider mismatch")
+			http.Redirect(w, r, pathTop, http.StatusFound)
+			return // (A)
+		}
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			Log.I("oidcCallback: %s: %s: %s", name, e, q.Get("error_description"))
+			http.Redirect(w, r, pathTop, http.StatusFound)
+			return // (A)
+		}
+		claims, err := p.Exchange(r.Context(), ar, q.Get("state"), q.Get("code"))
+		if err != nil {
+			Log.I("oidcCallback: %s: %v", name, err)
+			http.Redirect(w, r, pathTop, http.StatusFound)
+			return // (A)
+		}
+		user, err := s.A.GetUserByIdentity(r.Context(), name, claims.Subject)
+		if err != nil {
+			if !errors.Is(err, goki.ErrUserNotFound) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return // (X)
+			}
+			Log.D("oidcCallback: create a new Goki user for %s user %v", name, claims.Subject)
+			user, err = s.A.AddUserWithIdentity(r.Context(), goki.NewID(), claims.DisplayName(), name, claims.Subject)
+			if err != nil {
+				Log.D("oidcCallback: failed to create a new Goki user")
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return // (X)
+			}
+		}
+		if err := s.login(w, r, user); err != nil {
+			Log.E("oidcCallback: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+		http.Redirect(w, r, pathMe, http.StatusFound) // (B) or (C)
+	}
+}
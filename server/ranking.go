@@ -0,0 +1,130 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/model"
+)
+
+// ranking.html shows the top rankingLimit users of the last rankingYears years.
+const (
+	rankingLimit = 50
+	rankingYears = 5
+)
+
+// formPublic is the form name of the leaderboard opt-in.
+const formPublic = "public"
+
+// rankByView is an option of app.RankBy for templates.
+type rankByView struct {
+	Value app.RankBy
+	Label string
+}
+
+var rankByViews = []rankByView{
+	{app.RankByScore, "スコア"},
+	{app.RankByTotal, "合計"},
+	{app.RankByS, "小型"},
+	{app.RankByM, "中型"},
+	{app.RankByL, "大型"},
+}
+
+// rankEntryView is an app.RankEntry formatted for templates.
+type rankEntryView struct {
+	*app.RankEntry
+	Me bool
+}
+
+// serveRanking handles the leaderboard of public users.
+// - Query parameters `year`, `month` and `by` are optional.
+// - `year` defaults to this year, and `month` is ignored if invalid.
+func (s *Server) serveRanking(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveRanking")
+
+	tmplStruct := struct {
+		UserName    string
+		Public      bool
+		Entries     []rankEntryView
+		Years       []int
+		Months      []int
+		Year, Month int
+		By          app.RankBy
+		RankBys     []rankByView
+		FormPublic  string
+		PublicURL   string
+	}{
+		Months:     []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+		RankBys:    rankByViews,
+		FormPublic: formPublic,
+		PublicURL:  pathRankingPublic,
+	}
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc := u.Location()
+	tmplStruct.UserName = u.Name
+	tmplStruct.Public = u.Public
+
+	thisYear := goki.TimeNow().In(loc).Year()
+	for y := thisYear; y > thisYear-rankingYears; y-- {
+		tmplStruct.Years = append(tmplStruct.Years, y)
+	}
+	q := r.URL.Query()
+	year, err := strconv.Atoi(q.Get("year"))
+	if err != nil || year < 1 {
+		year = thisYear
+	}
+	month, _ := strconv.Atoi(q.Get("month"))
+	by := app.RankBy(q.Get("by"))
+	if !by.Valid() {
+		by = app.RankByScore
+	}
+	var begin, end time.Time
+	if month >= 1 && month <= 12 {
+		begin, end = app.MonthRange(year, time.Month(month), loc)
+	} else {
+		month = 0
+		begin, end = app.YearRange(year, loc)
+	}
+
+	es, err := s.A.Leaderboard(r.Context(), begin, end, by, rankingLimit)
+	if err != nil {
+		Log.I("serveRanking: could not Leaderboard")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, e := range es {
+		tmplStruct.Entries = append(tmplStruct.Entries, rankEntryView{RankEntry: e, Me: e.UserID == u.ID})
+	}
+	tmplStruct.Year = year
+	tmplStruct.Month = month
+	tmplStruct.By = by
+
+	if err := s.T[tmplRanking].Execute(w, tmplStruct); err != nil {
+		Log.I("serveRanking: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// serveRankingPublic sets whether the user appears on leaderboards and redirects to /ranking.
+// - The form value `public` is "1" to opt in, and anything else to opt out.
+func (s *Server) serveRankingPublic(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveRankingPublic")
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	if err := r.ParseForm(); err != nil {
+		Log.I("serveRankingPublic: could not ParseForm")
+		http.Error(w, "invalid form value", http.StatusBadRequest)
+		return
+	}
+	if err := s.A.SetPublic(r.Context(), u, r.PostFormValue(formPublic) == "1"); err != nil {
+		Log.I("serveRankingPublic: could not SetPublic")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, pathRanking, http.StatusFound)
+}
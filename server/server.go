@@ -2,13 +2,17 @@ package server
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"html/template"
+	"net"
 	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dghubble/gologin/v2/twitter"
@@ -21,6 +25,7 @@ import (
 	"github.com/ebiiim/goki"
 	"github.com/ebiiim/goki/app"
 	"github.com/ebiiim/goki/config"
+	"github.com/ebiiim/goki/metrics"
 	"github.com/ebiiim/goki/model"
 )
 
@@ -52,6 +57,14 @@ const (
 	tmplMe
 	tmplDo
 	tmplDone
+	tmplEdit
+	tmplHistory
+	tmplTokens
+	tmplLogin
+	tmplSignup
+	tmplRanking
+	tmplSettings
+	tmplImport
 )
 
 // template helper
@@ -68,6 +81,19 @@ var (
 	pathMe              = path.Join(pathBase, "me")
 	pathDo              = path.Join(pathBase, "do")
 	pathDone            = path.Join(pathBase, "done")
+	pathHistory         = path.Join(pathBase, "history")
+	pathActivity        = path.Join(pathBase, "activity")
+	pathActivityEdit    = path.Join(pathActivity, "{id}", "edit")
+	pathActivityDelete  = path.Join(pathActivity, "{id}", "delete")
+	pathTokens          = path.Join(pathBase, "tokens")
+	pathTokenRevoke     = path.Join(pathTokens, "{id}", "revoke")
+	pathRanking         = path.Join(pathBase, "ranking")
+	pathRankingPublic   = path.Join(pathRanking, "public")
+	pathSettings        = path.Join(pathBase, "settings")
+	pathChartMonthly    = path.Join(pathBase, "charts/monthly.svg")
+	pathChartYears      = path.Join(pathBase, "charts/years.svg")
+	pathExport          = path.Join(pathBase, "export")
+	pathImport          = path.Join(pathBase, "import")
 	pathLogout          = path.Join(pathBase, "logout")
 	pathTwitterLogin    = path.Join(pathBase, "login/twitter")
 	pathTwitterCallback = config.Params.Twitter.CallbackPath
@@ -82,6 +108,11 @@ type Server struct {
 	A *app.App
 	S sessions.Store
 	T map[tmplKey]*template.Template
+
+	// logins are login buttons of identity providers other than Twitter.
+	logins []loginView
+	// proxies are config.Params.Server.TrustedProxies.
+	proxies []*net.IPNet
 }
 
 // NewServer initializes a Server.
@@ -97,8 +128,14 @@ func NewServer(addr string, ap *app.App, ss sessions.Store) *Server {
 	s.ReadTimeout = config.ServerReadTimeout
 	s.IdleTimeout = config.ServerIdleTimeout
 	s.Addr = addr
+	s.proxies = mustParseProxies(config.Params.Server.TrustedProxies)
 
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }).Methods(http.MethodGet)
+	// metrics (disabled without the token)
+	if metricsEnabled() {
+		r.Handle("/metrics", checkMetricsToken(metrics.Handler())).Methods(http.MethodGet)
+	}
+	r.Use(metrics.Middleware)
 
 	// Route and Template
 	if config.Params.Web.ServeStatic {
@@ -117,17 +154,57 @@ func NewServer(addr string, ap *app.App, ss sessions.Store) *Server {
 	r.HandleFunc(pathDone, s.checkLogin(s.notLoggedInGoTop(s.serveDone))).Methods(http.MethodPost)
 	s.mustTmpl(tmplDone, filepath.Join(dirTmpl, "done.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
 
+	r.HandleFunc(pathHistory, s.checkLogin(s.notLoggedInGoTop(s.serveHistory))).Methods(http.MethodGet)
+	s.mustTmpl(tmplHistory, filepath.Join(dirTmpl, "history.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+
+	r.HandleFunc(pathActivityEdit, s.checkLogin(s.notLoggedInGoTop(s.serveEdit))).Methods(http.MethodGet, http.MethodPost)
+	s.mustTmpl(tmplEdit, filepath.Join(dirTmpl, "edit.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+
+	r.HandleFunc(pathActivityDelete, s.checkLogin(s.notLoggedInGoTop(s.serveDelete))).Methods(http.MethodPost)
+
+	r.HandleFunc(pathTokens, s.checkLogin(s.notLoggedInGoTop(s.serveTokens))).Methods(http.MethodGet, http.MethodPost)
+	s.mustTmpl(tmplTokens, filepath.Join(dirTmpl, "tokens.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+
+	r.HandleFunc(pathTokenRevoke, s.checkLogin(s.notLoggedInGoTop(s.serveTokenRevoke))).Methods(http.MethodPost)
+
+	r.HandleFunc(pathRanking, s.checkLogin(s.notLoggedInGoTop(s.serveRanking))).Methods(http.MethodGet)
+	s.mustTmpl(tmplRanking, filepath.Join(dirTmpl, "ranking.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+
+	r.HandleFunc(pathRankingPublic, s.checkLogin(s.notLoggedInGoTop(s.serveRankingPublic))).Methods(http.MethodPost)
+
+	r.HandleFunc(pathSettings, s.checkLogin(s.notLoggedInGoTop(s.serveSettings))).Methods(http.MethodGet, http.MethodPost)
+	s.mustTmpl(tmplSettings, filepath.Join(dirTmpl, "settings.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+
+	r.HandleFunc(pathChartMonthly, s.checkToken(s.checkLogin(s.serveChartMonthly))).Methods(http.MethodGet)
+	r.HandleFunc(pathChartYears, s.checkToken(s.checkLogin(s.serveChartYears))).Methods(http.MethodGet)
+
+	r.HandleFunc(pathExport, s.checkLogin(s.notLoggedInGoTop(s.serveExport))).Methods(http.MethodGet)
+
+	r.HandleFunc(pathImport, s.checkLogin(s.notLoggedInGoTop(s.serveImport))).Methods(http.MethodGet, http.MethodPost)
+	s.mustTmpl(tmplImport, filepath.Join(dirTmpl, "import.html"), filepath.Join(dirTmpl, "_head.html"), filepath.Join(dirTmpl, "_header.html"), filepath.Join(dirTmpl, "_footer.html"))
+
 	r.HandleFunc(pathLogout, s.serveLogout)
 
-	// Twitter login
-	oauth1Config := &oauth1.Config{
-		ConsumerKey:    config.Params.Twitter.Key,
-		ConsumerSecret: config.Params.Twitter.Secret,
-		CallbackURL:    UrlTwitterCallback,
-		Endpoint:       twitterOAuth1.AuthorizeEndpoint,
+	// JSON API
+	s.registerAPI(r)
+
+	// Twitter login (disabled without the consumer key)
+	if twitterEnabled() {
+		oauth1Config := &oauth1.Config{
+			ConsumerKey:    config.Params.Twitter.Key,
+			ConsumerSecret: config.Params.Twitter.Secret,
+			CallbackURL:    UrlTwitterCallback,
+			Endpoint:       twitterOAuth1.AuthorizeEndpoint,
+		}
+		r.Handle(pathTwitterLogin, twitter.LoginHandler(oauth1Config, nil))
+		r.Handle(pathTwitterCallback, twitter.CallbackHandler(oauth1Config, s.twitterLogin(), nil))
 	}
-	r.Handle(pathTwitterLogin, twitter.LoginHandler(oauth1Config, nil))
-	r.Handle(pathTwitterCallback, twitter.CallbackHandler(oauth1Config, s.twitterLogin(), nil))
+
+	// local account login
+	s.registerLocal(r)
+
+	// OpenID Connect login
+	s.registerOIDC(r)
 
 	return s
 }
@@ -143,6 +220,7 @@ func (s *Server) Shutdown(ctx context.Context) error {
 }
 
 // checkLogin middleware checks login.
+// - Skip if `ctxLoginUser` is already set (e.g. by checkToken).
 // - Get the Goki user ID from session and verify it.
 //   - (A) Success: put the user into context value `ctxLoginUser` and go next
 //   - (B) Error: just go next
@@ -150,8 +228,14 @@ func (s *Server) Shutdown(ctx context.Context) error {
 func (s *Server) checkLogin(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		Log.D("checkLogin")
+		if u, ok := r.Context().Value(ctxLoginUser).(*model.User); ok && u != nil {
+			Log.D("checkLogin: already logged in so just go next")
+			next(w, r)
+			return
+		}
 		sess, err := s.S.Get(r, config.SessionName)
 		if err != nil {
+			metrics.SessionErrors.WithLabelValues("get").Inc()
 			Log.D("checkLogin: error while getting session")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return // (X)
@@ -163,13 +247,14 @@ func (s *Server) checkLogin(next func(w http.ResponseWriter, r *http.Request)) f
 		}
 		Log.D("checkLogin: check the user id")
 		userID, _ := sess.Values[config.SessionUserID].(string) // already validated
-		u, err := s.A.GetUser(userID)
+		u, err := s.A.GetUser(r.Context(), userID)
 		if err != nil {
 			if errors.Is(err, goki.ErrUserNotFound) {
 				//invalid user: delete the session and go next
 				Log.D("checkLogin: user not found (invalid user id in the session) so delete session and go next")
 				sess.Options.MaxAge = -1
 				if err := sess.Save(r, w); err != nil {
+					metrics.SessionErrors.WithLabelValues("save").Inc()
 					Log.E("checkLogin: error while saving session")
 				}
 				// anyway, go next
@@ -180,7 +265,45 @@ func (s *Server) checkLogin(next func(w http.ResponseWriter, r *http.Request)) f
 			return // (X)
 		}
 		Log.D("checkLogin: ok! set context")
-		ctx := context.WithValue(context.Background(), ctxLoginUser, u)
+		ctx := context.WithValue(r.Context(), ctxLoginUser, u) // keep mux.Vars
+		r = r.WithContext(ctx)
+		next(w, r)
+	}
+}
+
+// checkToken middleware checks the personal API token.
+// - Get the token from `Authorization: Bearer {token}` and verify it.
+//   - (A) Success: put the user into context value `ctxLoginUser` and go next
+//   - (B) No token: just go next
+//   - (C) Invalid token: 401
+//   - (X) Unexpected error: 500
+func (s *Server) checkToken(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Log.D("checkToken")
+		h := r.Header.Get("Authorization")
+		if h == "" {
+			Log.D("checkToken: no token so just go next")
+			next(w, r)
+			return // (B)
+		}
+		const prefix = "Bearer "
+		if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+			Log.D("checkToken: not a bearer token")
+			writeAPIError(w, http.StatusUnauthorized, goki.ErrInvalidToken)
+			return // (C)
+		}
+		u, err := s.A.GetUserByAPIToken(r.Context(), strings.TrimSpace(h[len(prefix):]))
+		if err != nil {
+			if errors.Is(err, goki.ErrInvalidToken) {
+				Log.D("checkToken: invalid token")
+				writeAPIError(w, http.StatusUnauthorized, goki.ErrInvalidToken)
+				return // (C)
+			}
+			writeAPIError(w, http.StatusInternalServerError, err)
+			return // (X)
+		}
+		Log.D("checkToken: ok! set context")
+		ctx := context.WithValue(r.Context(), ctxLoginUser, u)
 		r = r.WithContext(ctx)
 		next(w, r)
 	}
@@ -200,6 +323,29 @@ func (s *Server) notLoggedInGoTop(next func(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// metricsEnabled reports whether the token of /metrics is configured.
+func metricsEnabled() bool {
+	return config.Params.Metrics.Token != ""
+}
+
+// checkMetricsToken serves next only for requests with `Authorization: Bearer {config.Params.Metrics.Token}`, otherwise 401.
+func checkMetricsToken(next http.Handler) http.Handler {
+	want := []byte("Bearer " + config.Params.Metrics.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// twitterEnabled reports whether Twitter login is configured.
+func twitterEnabled() bool {
+	return config.Params.Twitter.Key != ""
+}
+
 // twitterLogin handles Twitter OAuth1 callback.
 // - Check Twitter user.
 //   - (A) Error: redirect to the top page.
@@ -218,11 +364,11 @@ func (s *Server) twitterLogin() http.Handler {
 		}
 		// check Twitter User
 		Log.D("twitterLogin: check twitter user")
-		user, err := s.A.GetUserByTwitterID(twitterUser.IDStr)
+		user, err := s.A.GetUserByTwitterID(r.Context(), twitterUser.IDStr)
 		if err != nil {
 			if errors.Is(err, goki.ErrUserNotFound) {
 				Log.D("twitterLogin: create a new Goki user for twitter user %v", twitterUser.IDStr)
-				iU, iErr := s.A.AddUser(goki.NewID(), twitterUser.Name, twitterUser.IDStr)
+				iU, iErr := s.A.AddUser(r.Context(), goki.NewID(), twitterUser.Name, twitterUser.IDStr)
 				if iErr != nil {
 					// somehow failed to create a new user
 					Log.D("twitterLogin: failed to create a new Goki user")
@@ -238,20 +384,8 @@ func (s *Server) twitterLogin() http.Handler {
 		}
 		// make session
 		Log.D("twitterLogin: make session")
-		sess, err := s.S.New(r, config.SessionName)
-		if err != nil {
-			Log.D("twitterLogin: failed to make session")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return // (X)
-		}
-		sess.Values[config.SessionUserID] = user.ID
-		sess.Options.MaxAge = 86400 * 30
-		sess.Options.Path = "/"
-		sess.Options.HttpOnly = true
-		sess.Options.SameSite = http.SameSiteLaxMode
-		Log.D("twitterLogin: save session")
-		if err := sess.Save(r, w); err != nil {
-			Log.E("twitterLogin: failed to save session")
+		if err := s.login(w, r, user); err != nil {
+			Log.E("twitterLogin: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return // (X)
 		}
@@ -262,6 +396,25 @@ func (s *Server) twitterLogin() http.Handler {
 	return http.HandlerFunc(fn)
 }
 
+// login makes the login session of the user.
+func (s *Server) login(w http.ResponseWriter, r *http.Request, user *model.User) error {
+	sess, err := s.S.New(r, config.SessionName)
+	if err != nil {
+		metrics.SessionErrors.WithLabelValues("new").Inc()
+		return fmt.Errorf("failed to make session: %w", err)
+	}
+	sess.Values[config.SessionUserID] = user.ID
+	sess.Options.MaxAge = 86400 * 30
+	sess.Options.Path = "/"
+	sess.Options.HttpOnly = true
+	sess.Options.SameSite = http.SameSiteLaxMode
+	if err := sess.Save(r, w); err != nil {
+		metrics.SessionErrors.WithLabelValues("save").Inc()
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+	return nil
+}
+
 // serveLogout handles logout page.
 // - Delete the session.
 //   - (A) Success: redirect to the top page
@@ -270,6 +423,7 @@ func (s *Server) serveLogout(w http.ResponseWriter, r *http.Request) {
 	Log.D("serveLogout")
 	sess, err := s.S.Get(r, config.SessionName)
 	if err != nil {
+		metrics.SessionErrors.WithLabelValues("get").Inc()
 		Log.E("serveLogout: error while getting session: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return // (X)
@@ -282,6 +436,7 @@ func (s *Server) serveLogout(w http.ResponseWriter, r *http.Request) {
 	Log.D("serveLogout: delete session")
 	sess.Options.MaxAge = -1 // delete session
 	if err := sess.Save(r, w); err != nil {
+		metrics.SessionErrors.WithLabelValues("save").Inc()
 		Log.E("serveLogout: error while saving session: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return // (X)
@@ -297,7 +452,20 @@ func (s *Server) serveTop(w http.ResponseWriter, r *http.Request) {
 	tmplStruct := struct {
 		IsLoggedIn bool
 		UserName   string
-	}{}
+		Twitter    bool
+		Logins     []loginView
+		LocalLogin string
+		Signup     string
+	}{
+		Twitter: twitterEnabled(),
+		Logins:  s.logins,
+	}
+	if config.Params.Local.Enabled {
+		tmplStruct.LocalLogin = pathLocalLogin
+		if config.Params.Local.Signup {
+			tmplStruct.Signup = pathLocalSignup
+		}
+	}
 
 	u, ok := r.Context().Value(ctxLoginUser).(*model.User)
 	if ok && u != nil {
@@ -317,22 +485,33 @@ func (s *Server) serveMe(w http.ResponseWriter, r *http.Request) {
 	Log.D("serveMe")
 
 	tmplStruct := struct {
-		UserName string
-		G        *model.Goki
-		Year     int
+		UserName   string
+		G          *model.Goki
+		Score      int
+		Year       int
+		Activities []activityView
 	}{}
 
 	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
-	year := goki.TimeNow().Year()
-	g, err := s.A.CountByYear(u.ID, year, time.Local)
+	loc := u.Location()
+	year := goki.TimeNow().In(loc).Year()
+	g, err := s.A.CountByYear(r.Context(), u.ID, year, loc)
 	if err != nil {
 		Log.I("serveMe: could not CountByYear")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	acts, err := s.A.RecentActivities(r.Context(), u.ID, meRecentActivities)
+	if err != nil {
+		Log.I("serveMe: could not RecentActivities")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	tmplStruct.UserName = u.Name
 	tmplStruct.G = g
+	tmplStruct.Score = s.A.Score(g)
 	tmplStruct.Year = year
+	tmplStruct.Activities = newActivityViews(acts, loc)
 
 	if err := s.T[tmplMe].Execute(w, tmplStruct); err != nil {
 		Log.I("serveMe: template.Execute error")
@@ -341,16 +520,158 @@ func (s *Server) serveMe(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// names used in do.html and done.html
+// meRecentActivities is the number of activities shown in me.html.
+const meRecentActivities = 10
+
+// activityView is an Activity formatted for templates.
+type activityView struct {
+	ID        string
+	Time      string
+	G         *model.Goki
+	EditURL   string
+	DeleteURL string
+}
+
+func newActivityViews(acts []*model.Activity, loc *time.Location) []activityView {
+	vs := make([]activityView, len(acts))
+	for i, a := range acts {
+		vs[i] = activityView{
+			ID:        a.ID,
+			Time:      a.TimeUTC.In(loc).Format("2006-01-02 15:04"),
+			G:         a.G,
+			EditURL:   path.Join(pathActivity, a.ID, "edit"),
+			DeleteURL: path.Join(pathActivity, a.ID, "delete"),
+		}
+	}
+	return vs
+}
+
+// historyPerPage is the number of activities per page in history.html.
+const historyPerPage = 20
+
+// historyURL returns the URL of history.html with filters. Zero values are omitted.
+func historyURL(year, month, page int) string {
+	v := url.Values{}
+	if year > 0 {
+		v.Set("year", strconv.Itoa(year))
+	}
+	if month > 0 {
+		v.Set("month", strconv.Itoa(month))
+	}
+	if page > 1 {
+		v.Set("page", strconv.Itoa(page))
+	}
+	if len(v) == 0 {
+		return pathHistory
+	}
+	return pathHistory + "?" + v.Encode()
+}
+
+// serveHistory handles the paginated activity history.
+// - Query parameters `year`, `month` and `page` are optional.
+// - `month` is ignored without `year`.
+func (s *Server) serveHistory(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveHistory")
+
+	tmplStruct := struct {
+		UserName    string
+		Activities  []activityView
+		Years       []int
+		Months      []int
+		Year, Month int
+		Page        int
+		PrevURL     string
+		NextURL     string
+	}{
+		Months: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	}
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc := u.Location()
+	tmplStruct.UserName = u.Name
+
+	q := r.URL.Query()
+	year, _ := strconv.Atoi(q.Get("year"))
+	month, _ := strconv.Atoi(q.Get("month"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	var begin, end time.Time
+	switch {
+	case year > 0 && month >= 1 && month <= 12:
+		begin, end = app.MonthRange(year, time.Month(month), loc)
+	case year > 0:
+		month = 0
+		begin, end = app.YearRange(year, loc)
+	default:
+		year, month = 0, 0
+	}
+
+	acts, hasNext, err := s.A.History(r.Context(), u.ID, begin, end, page, historyPerPage)
+	if err != nil {
+		Log.I("serveHistory: could not History")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// years from the oldest activity to this year
+	thisYear := goki.TimeNow().In(loc).Year()
+	firstYear := thisYear
+	oldest, err := s.A.OldestActivity(r.Context(), u.ID)
+	if err != nil {
+		Log.I("serveHistory: could not OldestActivity")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if oldest != nil && oldest.TimeUTC.In(loc).Year() < firstYear {
+		firstYear = oldest.TimeUTC.In(loc).Year()
+	}
+	for y := thisYear; y >= firstYear; y-- {
+		tmplStruct.Years = append(tmplStruct.Years, y)
+	}
+
+	tmplStruct.Activities = newActivityViews(acts, loc)
+	tmplStruct.Year = year
+	tmplStruct.Month = month
+	tmplStruct.Page = page
+	if page > 1 {
+		tmplStruct.PrevURL = historyURL(year, month, page-1)
+	}
+	if hasNext {
+		tmplStruct.NextURL = historyURL(year, month, page+1)
+	}
+
+	if err := s.T[tmplHistory].Execute(w, tmplStruct); err != nil {
+		Log.I("serveHistory: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// names used in do.html, done.html and edit.html
 var (
 	formDo      = "formDo"
 	formSmall   = "doSmall"
 	formMedium  = "doMedium"
 	formLarge   = "doLarge"
-	formMax     = 21
+	formMax     = app.MaxGokiPerSize
 	formPOSTURL = pathDone
 )
 
+// parseGokiForm parses the number of roaches posted from do.html or edit.html.
+func parseGokiForm(r *http.Request) (numS, numM, numL int, err error) {
+	formS, errS := strconv.Atoi(r.FormValue(formSmall))
+	formM, errM := strconv.Atoi(r.FormValue(formMedium))
+	formL, errL := strconv.Atoi(r.FormValue(formLarge))
+	if errS != nil || errM != nil || errL != nil {
+		return 0, 0, 0, fmt.Errorf("%w: formS=%v formM=%v formL=%v errS=%v errM=%v errL=%v", goki.ErrInvalidArgument, formS, formM, formL, errS, errM, errL)
+	}
+	if err := app.ValidateGoki(formS, formM, formL); err != nil {
+		return 0, 0, 0, err
+	}
+	return formS, formM, formL, nil
+}
+
 func (s *Server) serveDo(w http.ResponseWriter, r *http.Request) {
 	Log.D("serveDo")
 
@@ -383,40 +704,42 @@ func (s *Server) serveDone(w http.ResponseWriter, r *http.Request) {
 	Log.D("serveDone")
 
 	tmplStruct := struct {
-		UserName string
-		AddedG   *model.Goki
-		NowG     *model.Goki
+		UserName   string
+		AddedG     *model.Goki
+		NowG       *model.Goki
+		AddedScore int
+		NowScore   int
 	}{}
 
 	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	loc := u.Location()
 	tmplStruct.UserName = u.Name
 
-	formS, errS := strconv.Atoi(r.FormValue(formSmall))
-	formM, errM := strconv.Atoi(r.FormValue(formMedium))
-	formL, errL := strconv.Atoi(r.FormValue(formLarge))
-
-	if errS != nil || errM != nil || errL != nil || (formS < 0 || formS > formMax) || (formM < 0 || formM > formMax) || (formL < 0 || formL > formMax) {
-		Log.I("serveDone: invalid form value: formS=%v formM=%v formL=%v errS=%v errM=%v errL=%v", formS, formM, formL, errS, errM, errL)
+	formS, formM, formL, err := parseGokiForm(r)
+	if err != nil {
+		Log.I("serveDone: %v", err)
 		http.Error(w, "invalid form value", http.StatusInternalServerError)
 		return
 	}
 
-	act, err := s.A.Action(u, formS, formM, formL)
+	act, err := s.A.Action(r.Context(), u, formS, formM, formL)
 	if err != nil {
 		Log.I("serveDone: could not Action")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	tmplStruct.AddedG = act.G
+	tmplStruct.AddedScore = s.A.Score(act.G)
 
-	year := goki.TimeNow().Year()
-	g, err := s.A.CountByYear(u.ID, year, time.Local)
+	year := goki.TimeNow().In(loc).Year()
+	g, err := s.A.CountByYear(r.Context(), u.ID, year, loc)
 	if err != nil {
 		Log.I("serveDone: could not CountByYear")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	tmplStruct.NowG = g
+	tmplStruct.NowScore = s.A.Score(g)
 
 	if err := s.T[tmplDone].Execute(w, tmplStruct); err != nil {
 		Log.I("serveDone: template.Execute error")
@@ -424,3 +747,183 @@ func (s *Server) serveDone(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// serveEdit handles the edit page of an activity.
+// - GET: show the form.
+// - POST: update the activity and redirect to /me.
+// - Activities of other users are not found: 404
+func (s *Server) serveEdit(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveEdit")
+
+	tmplStruct := struct {
+		UserName                         string
+		Time                             string
+		G                                *model.Goki
+		FormMax                          []struct{}
+		FormPOSTURL                      string
+		FormID                           string
+		FormSmall, FormMedium, FormLarge string
+	}{
+		FormMax:     make([]struct{}, formMax), // HACK: range(0, formMax)
+		FormPOSTURL: r.URL.Path,
+		FormID:      formDo,
+		FormSmall:   formSmall,
+		FormMedium:  formMedium,
+		FormLarge:   formLarge,
+	}
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	tmplStruct.UserName = u.Name
+	activityID := mux.Vars(r)["id"]
+
+	if r.Method == http.MethodPost {
+		formS, formM, formL, err := parseGokiForm(r)
+		if err != nil {
+			Log.I("serveEdit: %v", err)
+			http.Error(w, "invalid form value", http.StatusInternalServerError)
+			return
+		}
+		if _, err := s.A.UpdateActivity(r.Context(), u, activityID, formS, formM, formL); err != nil {
+			Log.I("serveEdit: could not UpdateActivity")
+			http.Error(w, err.Error(), activityErrorStatus(err))
+			return
+		}
+		http.Redirect(w, r, pathMe, http.StatusFound)
+		return
+	}
+
+	act, err := s.A.GetActivity(r.Context(), u, activityID)
+	if err != nil {
+		Log.I("serveEdit: could not GetActivity")
+		http.Error(w, err.Error(), activityErrorStatus(err))
+		return
+	}
+	tmplStruct.Time = act.TimeUTC.In(u.Location()).Format("2006-01-02 15:04")
+	tmplStruct.G = act.G
+
+	if err := s.T[tmplEdit].Execute(w, tmplStruct); err != nil {
+		Log.I("serveEdit: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// serveDelete deletes an activity and redirects to /me.
+// - Activities of other users are not found: 404
+func (s *Server) serveDelete(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveDelete")
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	if err := s.A.DeleteActivity(r.Context(), u, mux.Vars(r)["id"]); err != nil {
+		Log.I("serveDelete: could not DeleteActivity")
+		http.Error(w, err.Error(), activityErrorStatus(err))
+		return
+	}
+	http.Redirect(w, r, pathMe, http.StatusFound)
+}
+
+// activityErrorStatus returns 404 for goki.ErrActivityNotFound and 500 for others.
+func activityErrorStatus(err error) int {
+	if errors.Is(err, goki.ErrActivityNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// serveTokens handles the personal API token page.
+// - GET: list tokens.
+// - POST: create a token and show it once.
+func (s *Server) serveTokens(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveTokens")
+
+	tmplStruct := struct {
+		UserName     string
+		Tokens       []tokenView
+		NewToken     string
+		NewTokenName string
+		Error        string
+		FormName     string
+		NameMaxLen   int
+	}{
+		FormName:   formTokenName,
+		NameMaxLen: app.MaxAPITokenNameLen,
+	}
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	tmplStruct.UserName = u.Name
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			Log.I("serveTokens: could not ParseForm")
+			http.Error(w, "invalid form value", http.StatusBadRequest)
+			return
+		}
+		token, t, err := s.A.CreateAPIToken(r.Context(), u, r.PostFormValue(formTokenName))
+		switch {
+		case errors.Is(err, goki.ErrInvalidArgument):
+			w.WriteHeader(http.StatusBadRequest)
+			tmplStruct.Error = fmt.Sprintf("名前は1〜%d文字で入力してください", app.MaxAPITokenNameLen)
+		case err != nil:
+			Log.I("serveTokens: could not CreateAPIToken")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		default:
+			tmplStruct.NewToken = token
+			tmplStruct.NewTokenName = t.Name
+		}
+	}
+
+	tokens, err := s.A.ListAPITokens(r.Context(), u)
+	if err != nil {
+		Log.I("serveTokens: could not ListAPITokens")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmplStruct.Tokens = newTokenViews(tokens, u.Location())
+
+	if err := s.T[tmplTokens].Execute(w, tmplStruct); err != nil {
+		Log.I("serveTokens: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// serveTokenRevoke revokes a personal API token and redirects to /tokens.
+// - Tokens of other users are not found: 404
+func (s *Server) serveTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveTokenRevoke")
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	if err := s.A.RevokeAPIToken(r.Context(), u, mux.Vars(r)["id"]); err != nil {
+		Log.I("serveTokenRevoke: could not RevokeAPIToken")
+		status := http.StatusInternalServerError
+		if errors.Is(err, goki.ErrTokenNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	http.Redirect(w, r, pathTokens, http.StatusFound)
+}
+
+// formTokenName is the form name of the token name.
+const formTokenName = "name"
+
+// tokenView is an APIToken formatted for templates.
+type tokenView struct {
+	Name      string
+	Created   string
+	RevokeURL string
+}
+
+func newTokenViews(tokens []*model.APIToken, loc *time.Location) []tokenView {
+	vs := make([]tokenView, len(tokens))
+	for i, t := range tokens {
+		vs[i] = tokenView{
+			Name:      t.Name,
+			Created:   t.CreatedUTC.In(loc).Format("2006-01-02 15:04"),
+			RevokeURL: path.Join(pathTokens, t.ID, "revoke"),
+		}
+	}
+	return vs
+}
@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/app"
+	"github.com/ebiiim/goki/config"
+	"github.com/ebiiim/goki/db/memdb"
+	"github.com/ebiiim/goki/model"
+)
+
+// Tests need GOKI_CONFIG=testdata/config.json (see Makefile) as config is loaded on init.
+
+var ctx = context.Background()
+
+// setupServer returns a Server with in-memory databases of alice and a cookie session store.
+func setupServer(t *testing.T) (*Server, *model.User) {
+	t.Helper()
+	udb := memdb.NewUserDB()
+	alice := model.NewUser("123", "alice", "12345678")
+	if err := udb.Add(ctx, alice); err != nil {
+		t.Fatal(err)
+	}
+	ap := app.NewApp(udb, memdb.NewActivityDB())
+	s := NewServer("", ap, sessions.NewCookieStore([]byte("goki-test")))
+	t.Cleanup(func() {
+		if err := ap.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return s, alice
+}
+
+// withUser returns the request as logged in by the user.
+func withUser(r *http.Request, u *model.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), ctxLoginUser, u))
+}
+
+func TestWriteAPIError(t *testing.T) {
+	cases := map[error]int{
+		fmt.Errorf("App.X: %w", goki.ErrInvalidArgument):      http.StatusBadRequest,
+		fmt.Errorf("App.X: %w", goki.ErrInvalidToken):         http.StatusUnauthorized,
+		fmt.Errorf("App.X: %w", goki.ErrUserNotFound):         http.StatusNotFound,
+		fmt.Errorf("App.X: %w", goki.ErrTokenNotFound):        http.StatusNotFound,
+		fmt.Errorf("App.X: %w", goki.ErrActivityNotFound):     http.StatusNotFound,
+		fmt.Errorf("App.X: %w", goki.ErrUserAlreadyExist):     http.StatusConflict,
+		fmt.Errorf("App.X: %w", goki.ErrActivityAlreadyExist): http.StatusConflict,
+		errors.New("unexpected"):                              http.StatusInternalServerError,
+	}
+	for err, want := range cases {
+		rec := httptest.NewRecorder()
+		writeAPIError(rec, apiErrorStatus(err), err)
+		if rec.Code != want {
+			t.Errorf("%v: got %d want %d", err, rec.Code, want)
+		}
+		var body apiError
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Error.Status != want || body.Error.Message != err.Error() {
+			t.Errorf("%v: got body %+v", err, body)
+		}
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	s, alice := setupServer(t)
+	token, _, err := s.A.CreateAPIToken(ctx, alice, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"valid", "Bearer " + token, http.StatusOK},
+		{"lower_case_scheme", "bearer " + token, http.StatusOK},
+		{"no_header", "", http.StatusUnauthorized}, // passed to apiLoginRequired
+		{"basic", "Basic " + token, http.StatusUnauthorized},
+		{"short", "Bear", http.StatusUnauthorized},
+		{"invalid_token", "Bearer invalid", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, pathAPI+"/me", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler.ServeHTTP(rec, r)
+		if rec.Code != c.want {
+			t.Errorf("%s: got %d want %d", c.name, rec.Code, c.want)
+		}
+	}
+}
+
+func TestCheckToken_NoHeader(t *testing.T) {
+	s, _ := setupServer(t)
+	called := false
+	next := func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if u, ok := r.Context().Value(ctxLoginUser).(*model.User); ok && u != nil {
+			t.Errorf("logged in as %s", u.ID)
+		}
+	}
+	rec := httptest.NewRecorder()
+	s.checkToken(next)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !called {
+		t.Error("next is not called")
+	}
+}
+
+func TestCheckLogin_StaleSession(t *testing.T) {
+	s, _ := setupServer(t)
+	// a session of an user deleted after login
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	sess, err := s.S.New(r, config.SessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.Values[config.SessionUserID] = "deleted"
+	if err := sess.Save(r, rec); err != nil {
+		t.Fatal(err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rec.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	called := false
+	s.checkLogin(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if u, ok := r.Context().Value(ctxLoginUser).(*model.User); ok && u != nil {
+			t.Errorf("logged in as %s", u.ID)
+		}
+	})(rec, r)
+	if !called {
+		t.Error("next is not called")
+	}
+	deleted := false
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == config.SessionName && c.MaxAge < 0 {
+			deleted = true
+		}
+	}
+	if !deleted {
+		t.Error("the session is not deleted")
+	}
+}
+
+func TestServeExport_BadRequest(t *testing.T) {
+	s, alice := setupServer(t)
+	rec := httptest.NewRecorder()
+	s.serveExport(rec, withUser(httptest.NewRequest(http.MethodGet, "/export?format=xml", nil), alice))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got %d want 400", rec.Code)
+	}
+}
+
+func TestServeImport_BadRequest(t *testing.T) {
+	s, alice := setupServer(t)
+	// multipart form without the file
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField(formImportDryRun, "1")
+	mw.Close()
+	cases := map[string]*http.Request{
+		"no_file":  httptest.NewRequest(http.MethodPost, "/import", &body),
+		"not_form": httptest.NewRequest(http.MethodPost, "/import", strings.NewReader("time,s,m,l\n")),
+	}
+	cases["no_file"].Header.Set("Content-Type", mw.FormDataContentType())
+	for name, r := range cases {
+		rec := httptest.NewRecorder()
+		s.serveImport(rec, withUser(r, alice))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d want 400", name, rec.Code)
+		}
+	}
+}
+
+func TestServeChart_BadRequest(t *testing.T) {
+	s, alice := setupServer(t)
+	cases := []struct {
+		target string
+		serve  func(w http.ResponseWriter, r *http.Request)
+		want   int
+	}{
+		{"/charts/monthly.svg?year=abc", s.serveChartMonthly, http.StatusBadRequest},
+		{"/charts/monthly.svg?year=0", s.serveChartMonthly, http.StatusBadRequest},
+		{"/charts/monthly.svg?year=2021", s.serveChartMonthly, http.StatusOK},
+		{"/charts/years.svg?year=10000", s.serveChartYears, http.StatusBadRequest},
+		{"/charts/years.svg?years=0", s.serveChartYears, http.StatusBadRequest},
+		{fmt.Sprintf("/charts/years.svg?years=%d", chartMaxYears+1), s.serveChartYears, http.StatusBadRequest},
+		{"/charts/years.svg?years=2", s.serveChartYears, http.StatusOK},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		c.serve(rec, withUser(httptest.NewRequest(http.MethodGet, c.target, nil), alice))
+		if rec.Code != c.want {
+			t.Errorf("%s: got %d want %d", c.target, rec.Code, c.want)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	cases := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"disabled", "", "", http.StatusNotFound},
+		{"disabled_with_header", "", "Bearer ", http.StatusNotFound},
+		{"no_header", "secret", "", http.StatusUnauthorized},
+		{"wrong_token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"valid", "secret", "Bearer secret", http.StatusOK},
+	}
+	defer func(token string) { config.Params.Metrics.Token = token }(config.Params.Metrics.Token)
+	for _, c := range cases {
+		config.Params.Metrics.Token = c.token
+		s, _ := setupServer(t)
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if c.header != "" {
+			r.Header.Set("Authorization", c.header)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler.ServeHTTP(rec, r)
+		if rec.Code != c.want {
+			t.Errorf("%s: got %d want %d", c.name, rec.Code, c.want)
+		}
+		if c.want == http.StatusOK && !strings.Contains(rec.Body.String(), "goki_http_requests_total") {
+			t.Errorf("%s: metrics are not served", c.name)
+		}
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	s := &Server{proxies: mustParseProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})}
+	cases := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct", "203.0.113.1:1234", nil, "203.0.113.1"},
+		{"direct_spoofed", "203.0.113.1:1234", []string{"198.51.100.1"}, "203.0.113.1"},
+		{"proxy", "10.0.0.1:1234", []string{"203.0.113.1"}, "203.0.113.1"},
+		{"proxy_spoofed", "10.0.0.1:1234", []string{"198.51.100.1, 203.0.113.1"}, "203.0.113.1"},
+		{"proxies", "10.0.0.1:1234", []string{"203.0.113.1, 192.0.2.1", "10.1.1.1"}, "203.0.113.1"},
+		{"proxy_ipv6", "[2001:db8::1]:1234", []string{"2001:db8::2"}, "2001:db8::2"},
+		{"proxy_no_header", "10.0.0.1:1234", nil, ""},
+		{"proxy_broken", "10.0.0.1:1234", []string{"unknown"}, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = c.remote
+		for _, v := range c.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got := s.clientAddr(r); got != c.want {
+			t.Errorf("%s: got %q want %q", c.name, got, c.want)
+		}
+	}
+	if got := (&Server{}).clientAddr(httptest.NewRequest(http.MethodPost, "/", nil)); got != "" {
+		t.Errorf("no trusted proxies: got %q", got)
+	}
+}
+
+func TestServeLocalLogin_SharedRemoteAddr(t *testing.T) {
+	defer func(enabled bool, proxies []string) {
+		config.Params.Local.Enabled, config.Params.Server.TrustedProxies = enabled, proxies
+	}(config.Params.Local.Enabled, config.Params.Server.TrustedProxies)
+	config.Params.Local.Enabled = true
+
+	const proxy, attacker, user = "10.0.0.1:1234", "203.0.113.1", "198.51.100.1"
+	for _, proxies := range [][]string{nil, {"10.0.0.0/8"}} {
+		config.Params.Server.TrustedProxies = proxies
+		s, _ := setupServer(t)
+		if _, err := s.A.SignUp(ctx, "carol", "Carol", "password"); err != nil {
+			t.Fatal(err)
+		}
+		login := func(username, password, client string) int {
+			form := url.Values{formUsername: {username}, formPassword: {password}}
+			r := httptest.NewRequest(http.MethodPost, pathLocalLogin, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("X-Forwarded-For", client)
+			r.RemoteAddr = proxy
+			rec := httptest.NewRecorder()
+			s.Handler.ServeHTTP(rec, r)
+			return rec.Code
+		}
+		// the attacker tries many usernames through the same proxy as the user
+		for i := 0; i < app.LoginMaxFailures; i++ {
+			if got := login(fmt.Sprintf("user%d", i), "wrong", attacker); got != http.StatusUnauthorized {
+				t.Errorf("%v: failure %d: got %d", proxies, i, got)
+			}
+		}
+		if got := login("carol", "password", user); got != http.StatusFound {
+			t.Errorf("%v: user: got %d want 302", proxies, got)
+		}
+		want := http.StatusFound // limited per username only
+		if proxies != nil {
+			want = http.StatusTooManyRequests
+		}
+		if got := login("carol", "password", attacker); got != want {
+			t.Errorf("%v: attacker: got %d want %d", proxies, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ebiiim/goki"
+	"github.com/ebiiim/goki/model"
+)
+
+// formTimeZone is the form name of the time zone in settings.html.
+const formTimeZone = "tz"
+
+// timeZoneSuggestions are shown as options of the time zone input.
+// Any IANA time zone name is accepted.
+var timeZoneSuggestions = []string{
+	"Asia/Tokyo",
+	"Asia/Seoul",
+	"Asia/Shanghai",
+	"Asia/Singapore",
+	"Asia/Kolkata",
+	"Australia/Sydney",
+	"Europe/London",
+	"Europe/Paris",
+	"America/New_York",
+	"America/Chicago",
+	"America/Los_Angeles",
+	"Pacific/Honolulu",
+	"UTC",
+}
+
+// serveSettings handles the user settings page.
+// - GET: show the form.
+// - POST: save the time zone.
+//   - (A) Success: show the form with a message
+//   - (B) Unknown time zone: 400 with the form
+//   - (X) Unexpected error: 500
+func (s *Server) serveSettings(w http.ResponseWriter, r *http.Request) {
+	Log.D("serveSettings")
+
+	tmplStruct := struct {
+		UserName     string
+		TimeZone     string
+		Now          string
+		Suggestions  []string
+		FormTimeZone string
+		Saved        bool
+		Error        string
+	}{
+		Suggestions:  timeZoneSuggestions,
+		FormTimeZone: formTimeZone,
+	}
+
+	u, _ := r.Context().Value(ctxLoginUser).(*model.User)
+	tmplStruct.UserName = u.Name
+
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			Log.I("serveSettings: could not ParseForm")
+			http.Error(w, "invalid form value", http.StatusBadRequest)
+			return
+		}
+		tz := strings.TrimSpace(r.PostFormValue(formTimeZone))
+		err := s.A.SetTimeZone(r.Context(), u, tz)
+		switch {
+		case err == nil:
+			tmplStruct.Saved = true
+			// (A)
+		case errors.Is(err, goki.ErrInvalidArgument):
+			w.WriteHeader(http.StatusBadRequest)
+			tmplStruct.Error = "タイムゾーンが正しくありません"
+			// (B)
+		default:
+			Log.I("serveSettings: could not SetTimeZone")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return // (X)
+		}
+	}
+	tmplStruct.TimeZone = u.TimeZone
+	tmplStruct.Now = goki.TimeNow().In(u.Location()).Format("2006-01-02 15:04 MST")
+
+	if err := s.T[tmplSettings].Execute(w, tmplStruct); err != nil {
+		Log.I("serveSettings: template.Execute error")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}